@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Documents returns every document which has to be written for the event to the given stream.
+// Every event is appended to the data stream, since data streams only accept created documents. A DELETE is
+// appended as tombstone. The operation is applied to the object state when the stream has an entity index:
+// CREATE is merged into it, INDEX replaces it and DELETE removes it. Without an entity index a DELETE
+// removes nothing, which is why the gRPC API rejects it.
+// The documents remember the span of the context so that the bulk request can be linked to it.
+// The overflow is written to mapping.OverflowField of the event, it may be nil. It isn't merged into the object
+// state since the entity index has no template which maps it as a single field.
+func Documents(ctx context.Context, stream opensearch.DataStream, event *types.Event, overflow []*types.EventData) []opensearch.Document {
+	span := trace.SpanContextFromContext(ctx)
+	docs := []opensearch.Document{EventDocument{
+		Event: event, Stream: stream.Name(), Span: span, Overflow: overflow, Timestamp: time.Now().UTC(),
+	}}
+
+	entityIndex := opensearch.EntityIndexOf(stream)
+	if entityIndex != "" && event.GetObjectID() != "" {
+		docs = append(docs, EntityDocument{Event: event, EntityIndex: entityIndex, Span: span})
+	}
+	return docs
+}
+
+// overflowData returns the overflow as a single object which is mapped as flattened field.
+func overflowData(overflow []*types.EventData) map[string]interface{} {
+	data := make(map[string]interface{}, len(overflow))
+	for _, value := range overflow {
+		data[value.Key] = getEventDataValue(value)
+	}
+	return data
+}
+
+// EventDocument allows to write an Event with an opensearch.Bulk.
+type EventDocument struct {
+	Event *types.Event
+
+	// Stream is the target of the document. TargetIndexName is used when empty.
+	Stream string
+
+	// Span is the span the event was received in. It is optional.
+	Span trace.SpanContext
+
+	// Overflow are keys which exceeded the limits of the stream. It is optional.
+	Overflow []*types.EventData
+
+	// Timestamp is the @timestamp of the document, which data streams require. The time Data is called
+	// at is used when it is zero, so it should be set to keep retries of the document identical.
+	Timestamp time.Time
+}
+
+func (e EventDocument) ID() string {
+	return e.Event.GetEventID()
+}
+
+func (e EventDocument) Index() string {
+	if e.Stream == "" {
+		return TargetIndexName
+	}
+	return e.Stream
+}
+
+// Data returns the same document which is written by Index.
+func (e EventDocument) Data() interface{} {
+	data := make([]map[string]interface{}, 0, len(e.Event.GetData()))
+	for _, value := range e.Event.GetData() {
+		data = append(data, map[string]interface{}{value.Key: getEventDataValue(value)})
+	}
+
+	timestamp := e.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+
+	doc := map[string]interface{}{
+		"@timestamp": timestamp,
+		"eventID":    e.Event.GetEventID(),
+		"objectID":   e.Event.GetObjectID(),
+		"operation":  strings.ToLower(e.Event.GetOperation().String()),
+		"data":       data,
+	}
+	if len(e.Overflow) > 0 {
+		doc[mapping.OverflowField] = overflowData(e.Overflow)
+	}
+	return doc
+}
+
+// Action is always create since data streams are append only. The operation is kept in the document,
+// a DELETE is a tombstone.
+func (e EventDocument) Action() opensearch.Action {
+	return opensearch.ActionCreate
+}
+
+// PartitionKey makes sure that all events of an object are written in order.
+func (e EventDocument) PartitionKey() string {
+	return e.Event.GetObjectID()
+}
+
+func (e EventDocument) SpanContext() trace.SpanContext {
+	return e.Span
+}
+
+// EntityDocument applies an Event to the latest state of its object.
+type EntityDocument struct {
+	Event *types.Event
+
+	// EntityIndex is the index which holds one document per object.
+	EntityIndex string
+
+	// Span is the span the event was received in. It is optional.
+	Span trace.SpanContext
+}
+
+func (e EntityDocument) ID() string {
+	return e.Event.GetObjectID()
+}
+
+func (e EntityDocument) Index() string {
+	return e.EntityIndex
+}
+
+// Data returns the partial document which is merged into the object state, or the whole state of an INDEX.
+// EventData keys are kept below "data" so that they can't collide with the other fields.
+func (e EntityDocument) Data() interface{} {
+	data := make(map[string]interface{}, len(e.Event.GetData()))
+	for _, value := range e.Event.GetData() {
+		data[value.Key] = getEventDataValue(value)
+	}
+
+	return map[string]interface{}{
+		"objectID":    e.Event.GetObjectID(),
+		"lastEventID": e.Event.GetEventID(),
+		"data":        data,
+	}
+}
+
+// Action maps the operation of the event to the bulk action.
+func (e EntityDocument) Action() opensearch.Action {
+	switch e.Event.GetOperation() {
+	case types.Operation_INDEX:
+		return opensearch.ActionIndex
+	case types.Operation_DELETE:
+		return opensearch.ActionDelete
+	}
+	return opensearch.ActionUpdate
+}
+
+// Version returns the external version of the event.
+func (e EntityDocument) Version() int64 {
+	return e.Event.GetVersion()
+}
+
+// PartitionKey makes sure that the object state is written by the same worker as the events of the object.
+func (e EntityDocument) PartitionKey() string {
+	return e.Event.GetObjectID()
+}
+
+func (e EntityDocument) SpanContext() trace.SpanContext {
+	return e.Span
+}
@@ -0,0 +1,74 @@
+package api_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kstiehl/index-bouncer/api"
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch/fake"
+	opensearchgo "github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocuments(t *testing.T) {
+	t.Parallel()
+
+	stream := opensearch.Stream{StreamName: "orders", EntityIndexName: "orders-entities"}
+	event := func(id string, operation types.Operation, version int64, state string) *types.Event {
+		return &types.Event{EventID: id, ObjectID: "order-1", Operation: operation, Version: version, Data: []*types.EventData{
+			{Key: "state", Value: &types.EventData_StringValue{StringValue: state}},
+		}}
+	}
+
+	t.Run("Fake Opensearch", func(t *testing.T) {
+		t.Parallel()
+
+		server := fake.New()
+		t.Cleanup(server.Close)
+		client, err := opensearch.NewClient(opensearchgo.Config{Addresses: []string{server.URL}})
+		assert.NoError(t, err)
+		assert.NoError(t, opensearch.EnsureIndexTemplate(context.Background(), client, stream))
+
+		write := func(event *types.Event) opensearch.BulkResult {
+			t.Helper()
+			result, err := client.BulkIndex(context.Background(), api.Documents(context.Background(), stream, event, nil))
+			assert.NoError(t, err)
+			return result
+		}
+
+		assert.Equal(t, 2, write(event("1", types.Operation_CREATE, 0, "created")).Succeeded)
+		assert.Equal(t, 2, write(event("2", types.Operation_INDEX, 2, "paid")).Succeeded)
+		result := write(event("3", types.Operation_INDEX, 1, "stale"))
+		assert.Equal(t, 1, result.Succeeded)
+		assert.Equal(t, 1, result.Stale)
+
+		entity, version, ok := server.Document("orders-entities", "order-1")
+		assert.True(t, ok)
+		assert.Equal(t, int64(2), version)
+		assert.Equal(t, map[string]interface{}{"state": "paid"}, entity["data"])
+
+		assert.Equal(t, 2, write(event("4", types.Operation_DELETE, 3, "")).Succeeded)
+		_, _, ok = server.Document("orders-entities", "order-1")
+		assert.False(t, ok)
+		// deleting an object which doesn't exist isn't a failure.
+		assert.Equal(t, 2, write(event("5", types.Operation_DELETE, 4, "")).Succeeded)
+
+		assert.Equal(t, []string{"orders"}, server.DataStreams())
+		assert.Equal(t, 5, server.Documents("orders"))
+		tombstone, _, ok := server.Document("orders", "4")
+		assert.True(t, ok)
+		assert.Equal(t, "delete", tombstone["operation"])
+		assert.Contains(t, tombstone, "@timestamp")
+	})
+
+	t.Run("Without Entity Index", func(t *testing.T) {
+		t.Parallel()
+
+		docs := api.Documents(context.Background(), opensearch.Stream{StreamName: "orders"}, event("1", types.Operation_DELETE, 1, ""), nil)
+		assert.Len(t, docs, 1)
+		assert.Equal(t, opensearch.ActionCreate, opensearch.ActionOf(docs[0]))
+		assert.Zero(t, opensearch.VersionOf(docs[0]))
+	})
+}
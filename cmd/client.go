@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// clientFlags configure the connection of send and loadgen to a running bouncer.
+type clientFlags struct {
+	address  string
+	useTLS   bool
+	clientID string
+}
+
+func (f *clientFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.address, "address", "localhost:8080", "address of the bouncer's gRPC server")
+	cmd.Flags().BoolVar(&f.useTLS, "tls", false, "connect with TLS verified by the system's certificates")
+	cmd.Flags().StringVar(&f.clientID, "client-id", "", "client-id metadata of the calls, which identifies the client in key limit reports")
+}
+
+// dial connects to the bouncer. The client-id is added to every call.
+func (f *clientFlags) dial(ctx context.Context) (*grpc.ClientConn, error) {
+	options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if f.useTLS {
+		options[0] = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if f.clientID != "" {
+		clientID := f.clientID
+		options = append(options, grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{},
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, "client-id", clientID), method, req, reply, cc, opts...)
+		}))
+	}
+	return grpc.DialContext(ctx, f.address, options...)
+}
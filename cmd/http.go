@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// serveHTTP serves the handler on the address until the context is done.
+func serveHTTP(ctx context.Context, address string, handler http.Handler) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("http")
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		// requests carry the logger, but aren't canceled before they were answered.
+		BaseContext: func(net.Listener) context.Context {
+			return logr.NewContext(context.Background(), log)
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error(err, "shutting down the http server failed")
+		}
+	}()
+
+	log.Info("http server listening", "listenAddr", address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
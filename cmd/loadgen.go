@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/loadgen"
+	"github.com/spf13/cobra"
+)
+
+// LoadgenCmd sends synthetic events to a running bouncer and reports latencies and errors.
+func LoadgenCmd() *cobra.Command {
+	var (
+		client       clientFlags
+		rate         float64
+		duration     time.Duration
+		concurrency  int
+		keys         int
+		keysPerEvent int
+		objects      int
+		objectSkew   float64
+		operation    string
+		seed         int64
+	)
+
+	cmd := &cobra.Command{
+		Use:          "loadgen",
+		Short:        "send synthetic events to a running bouncer and report latencies and errors",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			op, ok := types.Operation_value[strings.ToUpper(operation)]
+			if !ok {
+				return fmt.Errorf("unknown operation %q", operation)
+			}
+			// every run gets its own eventIDs unless the seed is given.
+			if seed == 0 {
+				seed = time.Now().UnixNano()
+			}
+			generator, err := loadgen.NewGenerator(
+				loadgen.WithKeys(keys, keysPerEvent),
+				loadgen.WithObjects(objects, objectSkew),
+				loadgen.WithOperation(types.Operation(op)),
+				loadgen.WithSeed(seed),
+			)
+			if err != nil {
+				return err
+			}
+
+			conn, err := client.dial(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), duration)
+			defer cancel()
+			stats := loadgen.NewStats()
+			started := time.Now()
+			loadgen.Run(ctx, types.NewStreamingServiceClient(conn), generator, rate, concurrency, stats)
+
+			report := stats.Report(time.Since(started))
+			fmt.Fprint(cmd.OutOrStdout(), report.String())
+			if report.Failed() > 0 {
+				return fmt.Errorf("%d of %d calls failed", report.Failed(), report.Sent)
+			}
+			return nil
+		},
+	}
+
+	client.register(cmd)
+	cmd.Flags().Float64Var(&rate, "rate", 100, "events sent per second, as many as possible when 0")
+	cmd.Flags().DurationVar(&duration, "duration", 30*time.Second, "how long events are sent")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 8, "number of calls in flight at the same time")
+	cmd.Flags().IntVar(&keys, "keys", 10, "number of distinct EventData keys")
+	cmd.Flags().IntVar(&keysPerEvent, "keys-per-event", 3, "number of EventData keys of every event")
+	cmd.Flags().IntVar(&objects, "objects", 1000, "number of distinct objectIDs")
+	cmd.Flags().Float64Var(&objectSkew, "object-skew", 0, "exponent greater than 1 of a zipf distribution of the objectIDs, uniform when 0")
+	cmd.Flags().StringVar(&operation, "operation", "create", "operation of the events: create or index")
+	cmd.Flags().Int64Var(&seed, "seed", 0, "seed of the random events and their eventIDs, random when 0")
+	return cmd
+}
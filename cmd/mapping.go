@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+// Modes of the mapping inference.
+const (
+	// inferenceOff doesn't track the types of EventData keys.
+	inferenceOff = "off"
+	// inferenceCheck rejects events whose keys conflict with the types they were seen with before.
+	inferenceCheck = "check"
+	// inferenceSuggest checks and logs explicit mappings for new keys.
+	inferenceSuggest = "suggest"
+	// inferenceApply checks and adds explicit mappings for new keys to the stream and its index template.
+	inferenceApply = "apply"
+)
+
+// mappingInference tracks the types of the EventData keys of a stream.
+type mappingInference struct {
+	mode    string
+	tracker *mapping.Tracker
+	stream  opensearch.Stream
+}
+
+func newMappingInference(mode, sinkName string, stream opensearch.Stream) (*mappingInference, error) {
+	switch mode {
+	case inferenceOff:
+		return &mappingInference{mode: mode, stream: stream}, nil
+	case inferenceCheck, inferenceSuggest:
+	case inferenceApply:
+		if sinkName != "opensearch" {
+			return nil, fmt.Errorf("--mapping-inference=%s requires --sink=opensearch", mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mapping inference %q", mode)
+	}
+
+	tracker := mapping.NewTracker()
+	tracker.Seed(stream.Name(), stream.IndexTemplate.Mappings)
+	return &mappingInference{mode: mode, tracker: tracker, stream: stream}, nil
+}
+
+// Stream returns the stream with the inferred mappings added to its index template when they are applied.
+func (m *mappingInference) Stream() opensearch.Stream {
+	if m.mode != inferenceApply {
+		return m.stream
+	}
+	stream := m.stream
+	stream.IndexTemplate.Mappings = mapping.Merge(stream.IndexTemplate.Mappings,
+		mapping.Mappings(m.tracker.Fields(stream.Name())))
+	return stream
+}
+
+// Seed records the fields which are mapped by the indices of the stream already.
+// The guard counts them as distinct keys of the stream, it may be nil.
+func (m *mappingInference) Seed(ctx context.Context, client opensearch.Client, guard *mapping.Guard) error {
+	if m.tracker == nil && guard == nil {
+		return nil
+	}
+	for _, name := range []string{m.stream.Name(), m.stream.EntityIndex()} {
+		if name == "" {
+			continue
+		}
+		indices, err := opensearch.GetMappings(ctx, client, name)
+		if err != nil {
+			return err
+		}
+		for _, mappings := range indices {
+			m.tracker.Seed(m.stream.Name(), mappings)
+			guard.Seed(m.stream.Name(), mappings)
+		}
+	}
+	return nil
+}
+
+// withTemplateMappings adds the mappings of the EventData keys of the live index template to the stream.
+// They were added by the mapping inference of serve, so they are no drift and mustn't be removed by an update.
+// The mappings the stream configures take precedence.
+func withTemplateMappings(ctx context.Context, client opensearch.Client, stream opensearch.Stream) (opensearch.Stream, error) {
+	live, exists, err := opensearch.GetIndexTemplate(ctx, client, stream.Name())
+	if err != nil || !exists {
+		return stream, err
+	}
+	template, _ := live["template"].(map[string]interface{})
+	mappings, _ := template["mappings"].(map[string]interface{})
+	properties, _ := mappings["properties"].(map[string]interface{})
+	data, ok := properties[mapping.DataField].(map[string]interface{})
+	if !ok {
+		return stream, nil
+	}
+	stream.IndexTemplate.Mappings = mapping.Merge(stream.IndexTemplate.Mappings,
+		map[string]interface{}{"properties": map[string]interface{}{mapping.DataField: data}})
+	return stream, nil
+}
+
+// Run regularly logs or applies the mappings of the keys which were seen since the last interval.
+// Applied mappings are added to the index template by ensure.
+func (m *mappingInference) Run(ctx context.Context, client opensearch.Client, interval time.Duration, ensure func(context.Context) error) error {
+	if m.mode != inferenceSuggest && m.mode != inferenceApply {
+		return nil
+	}
+	log := logr.FromContextOrDiscard(ctx).WithName("mapping-inference").WithValues("stream", m.stream.Name())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		pending := m.tracker.Pending(m.stream.Name())
+		mappings := mapping.Mappings(pending)
+		if mappings == nil {
+			continue
+		}
+		if m.mode == inferenceSuggest {
+			suggestion, _ := json.Marshal(mappings)
+			log.Info("new keys were seen, consider to map them explicitly", "mappings", string(suggestion))
+			continue
+		}
+
+		// keys which were mapped dynamically in the meantime can't be changed.
+		failed := false
+		for _, name := range []string{m.stream.Name(), m.stream.EntityIndex()} {
+			if name == "" {
+				continue
+			}
+			if err := opensearch.PutMapping(ctx, client, name, mappings); err != nil {
+				log.Error(err, "adding the mappings of new keys failed", "index", name)
+				failed = true
+			}
+		}
+		if err := ensure(ctx); err != nil {
+			log.Error(err, "adding the mappings of new keys to the index template failed")
+			failed = true
+		}
+		// the keys are mapped with the next interval, otherwise they are lost until they are seen with another type.
+		if failed {
+			m.tracker.Requeue(m.stream.Name(), pending)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var errNotAccepted = errors.New("not every event was accepted")
+
+// SendCmd sends events from flags, a file or stdin to a running bouncer.
+func SendCmd() *cobra.Command {
+	var (
+		client     clientFlags
+		timeout    time.Duration
+		file       string
+		event      types.Event
+		operation  string
+		data       []string
+		stringData []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "send",
+		Short: "send events to a running bouncer",
+		Long: `send sends a single event configured by flags or, with --file, one event per line of NDJSON.
+Lines are events in the JSON format of protobuf, e.g.
+{"eventID": "1", "objectID": "order-1", "operation": "INDEX", "data": [{"key": "state", "stringValue": "paid"}]}`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var events func() (*types.Event, error)
+			if file == "" {
+				op, ok := types.Operation_value[strings.ToUpper(operation)]
+				if !ok {
+					return fmt.Errorf("unknown operation %q", operation)
+				}
+				event.Operation = types.Operation(op)
+				if event.Data, ok = parseEventData(data, stringData); !ok {
+					return fmt.Errorf("event data has to be given as key=value")
+				}
+				if event.EventID == "" {
+					event.EventID = strconv.FormatInt(time.Now().UnixNano(), 36)
+				}
+				sent := false
+				events = func() (*types.Event, error) {
+					if sent {
+						return nil, io.EOF
+					}
+					sent = true
+					return &event, nil
+				}
+			} else {
+				input := cmd.InOrStdin()
+				if file != "-" {
+					opened, err := os.Open(file)
+					if err != nil {
+						return err
+					}
+					defer opened.Close()
+					input = opened
+				}
+				events = readEvents(input)
+			}
+
+			conn, err := client.dial(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			streaming := types.NewStreamingServiceClient(conn)
+
+			var failed bool
+			for {
+				next, err := events()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+
+				ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+				response, err := streaming.Index(ctx, next)
+				cancel()
+				if err != nil {
+					failed = true
+					fmt.Fprintf(cmd.OutOrStdout(), "%s error: %s\n", next.GetEventID(), err)
+					continue
+				}
+				// the event has to be sent again later.
+				if response.GetCode() == types.StatusCode_RETRY_LATER {
+					failed = true
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", next.GetEventID(), response.GetCode())
+			}
+			if failed {
+				return errNotAccepted
+			}
+			return nil
+		},
+	}
+
+	client.register(cmd)
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "time a single call may take")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "NDJSON file with one event per line, - reads stdin")
+	cmd.Flags().StringVar(&event.EventID, "event-id", "", "eventID of the event, generated when empty")
+	cmd.Flags().StringVar(&event.ObjectID, "object-id", "", "objectID of the event")
+	cmd.Flags().Int64Var(&event.Version, "version", 0, "external version of the event, unversioned when 0")
+	cmd.Flags().StringVar(&operation, "operation", "create", "operation of the event: create, index or delete")
+	cmd.Flags().StringArrayVar(&data, "data", nil, "key=value of the event, true and false are sent as bool, integers as number")
+	cmd.Flags().StringArrayVar(&stringData, "string-data", nil, "key=value of the event which is always sent as string")
+	return cmd
+}
+
+// parseEventData parses key=value pairs. Values of data are sent as bool or number when they look like one.
+func parseEventData(data, stringData []string) ([]*types.EventData, bool) {
+	var values []*types.EventData
+	for _, pair := range data {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, false
+		}
+		eventData := &types.EventData{Key: key, Value: &types.EventData_StringValue{StringValue: value}}
+		if value == "true" || value == "false" {
+			eventData.Value = &types.EventData_BoolValue{BoolValue: value == "true"}
+		} else if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			eventData.Value = &types.EventData_NumberValue{NumberValue: n}
+		}
+		values = append(values, eventData)
+	}
+	for _, pair := range stringData {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, false
+		}
+		values = append(values, &types.EventData{Key: key, Value: &types.EventData_StringValue{StringValue: value}})
+	}
+	return values, true
+}
+
+// readEvents returns the events of the NDJSON input one by one. Empty lines are skipped.
+func readEvents(input io.Reader) func() (*types.Event, error) {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	return func() (*types.Event, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			event := &types.Event{}
+			if err := protojson.Unmarshal([]byte(text), event); err != nil {
+				return nil, fmt.Errorf("invalid event in line %d: %w", line, err)
+			}
+			return event, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
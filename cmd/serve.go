@@ -2,23 +2,410 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/stdr"
 	"github.com/kstiehl/index-bouncer/grpc"
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/ingest"
+	"github.com/kstiehl/index-bouncer/pkg/breaker"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/health"
+	"github.com/kstiehl/index-bouncer/pkg/idempotency"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/kstiehl/index-bouncer/pkg/sink"
+	"github.com/kstiehl/index-bouncer/pkg/spill"
+	"github.com/kstiehl/index-bouncer/pkg/tracing"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func ServeCmd() *cobra.Command {
-	return &cobra.Command{
+	var (
+		listenAddress  string
+		httpListen     string
+		esListen       string
+		esIndices      []string
+		esMaxBodyBytes int64
+		adminTokenFile string
+		healthInterval time.Duration
+
+		otlpEndpoint     string
+		otlpInsecure     bool
+		traceSampleRatio float64
+		streamConfig     streamFlags
+		mappingMode      string
+		mappingInterval  time.Duration
+		flushInterval    time.Duration
+		workers          int
+
+		adaptive              bool
+		adaptiveTargetLatency time.Duration
+		adaptiveMaxBatch      int
+
+		queueMaxDocuments int
+		queueMaxBytes     int64
+		queueFullPolicy   string
+		maxHeapBytes      uint64
+
+		idempotencySize int
+		idempotencyTTL  time.Duration
+
+		bulkOptions opensearch.BulkOptions
+		compression string
+
+		sinkName     string
+		sinkDir      string
+		sinkMaxBytes int64
+		sinkMaxAge   time.Duration
+		sinkGzip     bool
+
+		spillDir        string
+		spillQuota      int64
+		spillDrainRate  int
+		breakerFailures int
+		breakerCooldown time.Duration
+	)
+	bulkOptions.InitWithDefaults()
+
+	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "start the server",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// logs mustn't be mixed with the documents of the stdout sink.
+			logOutput := os.Stdout
+			if sinkName == "stdout" {
+				logOutput = os.Stderr
+			}
 			ctx := logr.NewContext(context.Background(),
-				stdr.New(log.New(os.Stdout, "", log.LstdFlags)))
-			return grpc.RunServer(ctx)
+				stdr.New(log.New(logOutput, "", log.LstdFlags)))
+
+			// the opensearch address is taken from OPENSEARCH_URL.
+			bulkOptions.Compression = opensearch.Compression(compression)
+			switch bulkOptions.Compression {
+			case opensearch.CompressionNone, opensearch.CompressionGzip, opensearch.CompressionDeflate:
+			default:
+				return fmt.Errorf("unknown bulk compression %q", compression)
+			}
+			client, err := opensearch.NewWithDefaultClient(func(options *opensearch.BulkOptions) {
+				*options = bulkOptions
+			})
+			if err != nil {
+				return err
+			}
+
+			var serverMetrics *metrics.Metrics
+			if httpListen != "" {
+				serverMetrics = metrics.New()
+				client.Metrics = serverMetrics
+				serverMetrics.WatchCompression(func() (int64, int64, time.Duration) {
+					uncompressed, compressed := client.CompressionMetrics.Bytes()
+					return uncompressed, compressed, client.CompressionMetrics.Duration()
+				})
+			}
+
+			var tracerProvider trace.TracerProvider
+			if otlpEndpoint != "" {
+				provider, err := tracing.NewProvider(ctx, otlpEndpoint, otlpInsecure, traceSampleRatio)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					// spans which are still batched are exported on shutdown.
+					shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := provider.Shutdown(shutdownCtx); err != nil {
+						logr.FromContextOrDiscard(ctx).Error(err, "exporting the remaining spans failed")
+					}
+				}()
+				tracerProvider = provider
+				client.TracerProvider = provider
+			}
+
+			// pending events are written on shutdown, so the writers get a context of their own
+			// which is canceled after the servers stopped accepting events.
+			writeCtx, stopWriting := context.WithCancel(logr.NewContext(context.Background(), logr.FromContextOrDiscard(ctx)))
+			defer stopWriting()
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			stream, err := streamConfig.stream()
+			if err != nil {
+				return err
+			}
+			templateOptions, err := streamConfig.templateOptions()
+			if err != nil {
+				return err
+			}
+			inference, err := newMappingInference(mappingMode, sinkName, stream)
+			if err != nil {
+				return err
+			}
+			keyGuard, err := streamConfig.keyGuard()
+			if err != nil {
+				return err
+			}
+			var indexer debounce.Indexer = client
+			var cluster health.Cluster = client
+			ensure := func(ctx context.Context) error {
+				// the cluster wasn't reachable at startup.
+				if client.Backend().Flavor == "" {
+					if err := detectBackend(ctx, client); err != nil {
+						return err
+					}
+				}
+				if err := inference.Seed(ctx, client, keyGuard); err != nil {
+					return err
+				}
+				return opensearch.EnsureStream(ctx, client, withOverflow(inference.Stream(), keyGuard, client.Backend()),
+					templateOptions...)
+			}
+			switch sinkName {
+			case "opensearch":
+				detectCtx, cancelDetect := context.WithTimeout(ctx, 10*time.Second)
+				err := detectBackend(detectCtx, client)
+				cancelDetect()
+				if errors.Is(err, opensearch.ErrUnsupportedBackend) {
+					return err
+				}
+				if err != nil {
+					logr.FromContextOrDiscard(ctx).Error(err, "detecting the cluster failed, it is detected again before the templates are ensured")
+				}
+			case "file":
+				fileSink, err := sink.NewFile(sinkDir, sink.WithRotation(sinkMaxBytes, sinkMaxAge), sink.WithGzip(sinkGzip))
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := fileSink.Close(); err != nil {
+						logr.FromContextOrDiscard(ctx).Error(err, "closing the sink failed")
+					}
+				}()
+				indexer, cluster, ensure = fileSink, localCluster{}, ensureNothing
+			case "stdout":
+				indexer, cluster, ensure = sink.NewWriter(os.Stdout), localCluster{}, ensureNothing
+			default:
+				return fmt.Errorf("unknown sink %q", sinkName)
+			}
+
+			spilled := make(chan error, 1)
+			if spillDir != "" {
+				queue, err := spill.Open(spillDir, spillQuota)
+				if err != nil {
+					return err
+				}
+				spillIndexer := spill.NewIndexer(indexer, breaker.New(breakerFailures, breakerCooldown), queue, spillDrainRate)
+				spillIndexer.Metrics = serverMetrics
+				go func() { spilled <- spillIndexer.Run(writeCtx) }()
+				indexer = spillIndexer
+			} else {
+				spilled <- nil
+			}
+
+			fullPolicy, err := debounce.ParseFullPolicy(queueFullPolicy)
+			if err != nil {
+				return err
+			}
+			debounceOptions := []debounce.Option{
+				debounce.WithFlushInterval(flushInterval),
+				debounce.WithWorkers(workers),
+				debounce.WithQueueLimits(queueMaxDocuments, queueMaxBytes, fullPolicy),
+				debounce.WithMaxHeapBytes(maxHeapBytes),
+				debounce.WithTracerProvider(tracerProvider),
+				debounce.WithMetrics(serverMetrics),
+			}
+			if adaptive {
+				debounceOptions = append(debounceOptions, debounce.WithAdaptive(debounce.NewAdaptive(
+					debounce.WithTargetLatency(adaptiveTargetLatency),
+					debounce.WithBatchSizeRange(50, adaptiveMaxBatch),
+					debounce.WithConcurrencyRange(1, workers),
+				)))
+			}
+			debouncer := debounce.New(indexer, debounceOptions...)
+			serverMetrics.WatchQueue(debouncer.Queued)
+
+			checker := health.NewChecker(cluster, ensure, healthInterval, types.StreamingService_ServiceDesc.ServiceName)
+			checked := make(chan error, 1)
+			go func() { checked <- checker.Run(ctx) }()
+
+			served := make(chan error, 1)
+			if httpListen != "" {
+				mux := http.NewServeMux()
+				mux.Handle("/metrics", serverMetrics.Handler())
+				mux.Handle("/livez", checker.LivenessHandler())
+				mux.Handle("/readyz", checker.ReadinessHandler())
+				go func() { served <- serveHTTP(ctx, httpListen, mux) }()
+			} else {
+				served <- nil
+			}
+
+			// pauses of the AdminService, retries and key limits apply to the gRPC and the Elasticsearch API.
+			pauses := grpc.NewPauses()
+			var retries *idempotency.Cache
+			if idempotencySize > 0 {
+				retries = idempotency.New(idempotencySize, idempotencyTTL)
+			}
+			ingested := make(chan error, 1)
+			if esListen != "" {
+				if len(esIndices) == 0 {
+					esIndices = []string{stream.Name()}
+				}
+				ingestServer := &ingest.Server{
+					Debouncer:      debouncer,
+					Indices:        esIndices,
+					MaxBodyBytes:   esMaxBodyBytes,
+					Metrics:        serverMetrics,
+					TracerProvider: tracerProvider,
+					Idempotency:    retries,
+					Keys:           keyGuard,
+				}
+				// the types of the documents are tracked apart from the EventData keys whose mappings are inferred.
+				if inference.tracker != nil {
+					ingestServer.Mappings = mapping.NewTracker()
+				}
+				if adminTokenFile != "" {
+					ingestServer.Paused = pauses.Paused
+				}
+				go func() { ingested <- serveHTTP(ctx, esListen, ingestServer.Handler()) }()
+			} else {
+				ingested <- nil
+			}
+
+			flushed := make(chan error, 1)
+			go func() { flushed <- debouncer.Run(writeCtx) }()
+
+			inferred := make(chan error, 1)
+			go func() { inferred <- inference.Run(ctx, client, mappingInterval, ensure) }()
+
+			serverOptions := []grpc.Option{
+				grpc.WithListenAddress(listenAddress),
+				grpc.WithDebouncer(debouncer),
+				grpc.WithMetrics(serverMetrics),
+				grpc.WithTracerProvider(tracerProvider),
+				grpc.WithStream(stream),
+				grpc.WithHealth(checker.Server()),
+				grpc.WithMappings(inference.tracker),
+				grpc.WithKeyGuard(keyGuard),
+			}
+			if adminTokenFile != "" {
+				token, err := os.ReadFile(adminTokenFile)
+				if err != nil {
+					return fmt.Errorf("unable to read admin token: %w", err)
+				}
+				admin := &grpc.AdminServer{
+					Debouncer: debouncer,
+					Streams:   []opensearch.DataStream{stream},
+					Pauses:    pauses,
+					InFlight:  client.InFlight,
+					Keys:      keyGuard,
+				}
+				serverOptions = append(serverOptions, grpc.WithAdmin(admin, strings.TrimSpace(string(token))))
+			}
+			if retries != nil {
+				serverOptions = append(serverOptions, grpc.WithIdempotency(retries))
+			}
+
+			err = grpc.RunServer(ctx, serverOptions...)
+
+			// the servers stop on their own when they fail.
+			stop()
+			if ingestErr := <-ingested; err == nil {
+				err = ingestErr
+			}
+			if httpErr := <-served; err == nil {
+				err = httpErr
+			}
+			if checkErr := <-checked; err == nil {
+				err = checkErr
+			}
+			if inferErr := <-inferred; err == nil {
+				err = inferErr
+			}
+			// no more events are accepted, so the pending ones can be written.
+			stopWriting()
+			if flushErr := <-flushed; err == nil {
+				err = flushErr
+			}
+			if spillErr := <-spilled; err == nil {
+				err = spillErr
+			}
+			return err
 		},
 	}
+
+	cmd.Flags().StringVar(&listenAddress, "listen", ":8080", "address the grpc server listens on")
+	cmd.Flags().StringVar(&httpListen, "http-listen", ":9090", "address /metrics and the probes /livez and /readyz are served on, disabled when empty")
+	cmd.Flags().StringVar(&esListen, "es-listen", "", "address the Elasticsearch compatible _doc, _create and _bulk APIs are served on, disabled when empty")
+	cmd.Flags().StringSliceVar(&esIndices, "es-indices", nil, "patterns of the indices the Elasticsearch compatible API may write to, only the stream when empty")
+	cmd.Flags().Int64Var(&esMaxBodyBytes, "es-max-body-bytes", 100<<20, "maximum size of a request to the Elasticsearch compatible API, unlimited when 0")
+	cmd.Flags().StringVar(&adminTokenFile, "admin-token-file", "", "file containing the token calls of the AdminService have to carry, disabled when empty")
+	cmd.Flags().DurationVar(&healthInterval, "health-interval", 10*time.Second, "how often the health of opensearch is checked")
+	cmd.Flags().StringVar(&otlpEndpoint, "otlp-endpoint", "", "host:port of an OTLP/HTTP collector spans are exported to, disabled when empty")
+	cmd.Flags().BoolVar(&otlpInsecure, "otlp-insecure", false, "export spans without TLS")
+	cmd.Flags().Float64Var(&traceSampleRatio, "trace-sample-ratio", 1, "fraction of traces without sampled parent which are recorded")
+	streamConfig.register(cmd)
+	cmd.Flags().StringVar(&mappingMode, "mapping-inference", "off", "track the types of EventData keys: off, check to reject conflicting types, suggest to also log mappings of new keys or apply to add them")
+	cmd.Flags().DurationVar(&mappingInterval, "mapping-interval", 30*time.Second, "how often mappings of new keys are suggested or applied")
+	cmd.Flags().DurationVar(&flushInterval, "flush-interval", time.Second, "maximum time an event is pending before it is written")
+	cmd.Flags().IntVar(&workers, "workers", 4, "number of workers which write events partitioned by their objectID")
+	cmd.Flags().BoolVar(&adaptive, "adaptive", false, "adjust batch size and concurrency from the latency and rejections of opensearch")
+	cmd.Flags().DurationVar(&adaptiveTargetLatency, "adaptive-target-latency", time.Second, "bulk latency above which batch size and concurrency are decreased")
+	cmd.Flags().IntVar(&adaptiveMaxBatch, "adaptive-max-batch", 5000, "maximum batch size when --adaptive is enabled")
+	cmd.Flags().IntVar(&queueMaxDocuments, "queue-max-documents", 100000, "number of events which may be pending or in flight, unlimited when 0")
+	cmd.Flags().Int64Var(&queueMaxBytes, "queue-max-bytes", 256<<20, "estimated size of the events which may be pending or in flight, unlimited when 0")
+	cmd.Flags().StringVar(&queueFullPolicy, "queue-full-policy", "block", "what happens to events exceeding the queue limits: block, reject or drop-oldest")
+	cmd.Flags().Uint64Var(&maxHeapBytes, "max-heap-bytes", 0, "heap size above which events are rejected, disabled when 0")
+	cmd.Flags().IntVar(&idempotencySize, "idempotency-size", 100000, "number of recently accepted events which are remembered to answer retries, disabled when 0")
+	cmd.Flags().DurationVar(&idempotencyTTL, "idempotency-ttl", 10*time.Minute, "how long an accepted event is remembered to answer retries")
+	cmd.Flags().IntVar(&bulkOptions.MaxRequestBytes, "bulk-max-bytes", bulkOptions.MaxRequestBytes, "maximum size of a bulk request, should be below http.max_content_length of opensearch")
+	cmd.Flags().IntVar(&bulkOptions.MaxRequestDocuments, "bulk-max-documents", bulkOptions.MaxRequestDocuments, "maximum number of documents in a bulk request")
+	cmd.Flags().IntVar(&bulkOptions.Concurrency, "bulk-concurrency", bulkOptions.Concurrency, "number of bulk requests a worker sends at the same time")
+	cmd.Flags().IntVar(&bulkOptions.MaxInFlight, "bulk-max-in-flight", bulkOptions.MaxInFlight, "number of bulk requests all workers send at the same time, unlimited when 0")
+	cmd.Flags().BoolVar(&bulkOptions.RejectOversized, "bulk-reject-oversized", bulkOptions.RejectOversized, "reject documents exceeding --bulk-max-bytes instead of sending them alone")
+	cmd.Flags().StringVar(&compression, "bulk-compression", "", "content encoding of bulk requests: gzip, deflate or empty to disable")
+	cmd.Flags().IntVar(&bulkOptions.CompressionLevel, "bulk-compression-level", bulkOptions.CompressionLevel, "compression level of bulk requests, -1 selects the default level")
+	cmd.Flags().IntVar(&bulkOptions.CompressionMinBytes, "bulk-compression-min-bytes", bulkOptions.CompressionMinBytes, "size from which on bulk requests are compressed")
+	cmd.Flags().StringVar(&sinkName, "sink", "opensearch", "where events are written to: opensearch, file or stdout")
+	cmd.Flags().StringVar(&sinkDir, "sink-dir", "bouncer-out", "directory --sink=file writes NDJSON bulk requests to")
+	cmd.Flags().Int64Var(&sinkMaxBytes, "sink-max-bytes", 128<<20, "uncompressed size after which --sink=file starts a new file, disabled when 0")
+	cmd.Flags().DurationVar(&sinkMaxAge, "sink-max-age", time.Hour, "time after which --sink=file starts a new file, disabled when 0")
+	cmd.Flags().BoolVar(&sinkGzip, "sink-gzip", false, "compress the files of --sink=file")
+	cmd.Flags().StringVar(&spillDir, "spill-dir", "", "directory events are put aside in while opensearch is unavailable, disabled when empty")
+	cmd.Flags().Int64Var(&spillQuota, "spill-quota-bytes", 1<<30, "maximum size of the spill directory, events are rejected with RETRY_LATER once it is full")
+	cmd.Flags().IntVar(&spillDrainRate, "spill-drain-rate", 1000, "number of spilled events written per second once opensearch recovered")
+	cmd.Flags().IntVar(&breakerFailures, "breaker-failures", 5, "number of consecutive failed bulk requests which open the circuit breaker")
+	cmd.Flags().DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Second, "time the circuit breaker stays open before a probe is sent")
+	return cmd
+}
+
+// localCluster is reported as healthy when events aren't written to opensearch.
+type localCluster struct{}
+
+func (localCluster) ClusterHealth(context.Context) (opensearch.ClusterStatus, error) {
+	return opensearch.ClusterGreen, nil
+}
+
+func ensureNothing(context.Context) error {
+	return nil
+}
+
+// detectBackend detects whether opensearch or Elasticsearch runs in which version.
+func detectBackend(ctx context.Context, client opensearch.Client) error {
+	backend, err := client.Detect(ctx)
+	if err != nil {
+		return err
+	}
+	logr.FromContextOrDiscard(ctx).Info("detected cluster", "flavor", backend.Flavor, "version", backend.Version)
+	return nil
 }
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/kstiehl/index-bouncer/api"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/spf13/cobra"
+)
+
+// streamFlags configure the data stream events are written to.
+// They are shared by serve and template so that both write the same index template.
+type streamFlags struct {
+	name             string
+	entityIndex      string
+	templateFile     string
+	templateUpdate   string
+	templateRollover bool
+	lifecycle        opensearch.Lifecycle
+	warmAllocation   map[string]string
+	keyLimits        mapping.Limits
+	keyPattern       string
+	keyPolicy        string
+}
+
+func (f *streamFlags) register(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&f.name, "stream", api.TargetIndexName, "data stream the events are written to")
+	flags.StringVar(&f.templateFile, "template-file", "", "JSON file with mappings, settings, priority and composed_of of the stream's index template")
+	flags.StringVar(&f.templateUpdate, "template-update", "additive", "which differences to an existing index template are fixed: never, additive or always")
+	flags.BoolVar(&f.templateRollover, "template-rollover", false, "roll the data stream over after its index template was updated")
+	flags.StringVar(&f.lifecycle.RolloverSize, "rollover-size", "", "size like 50gb at which the stream rolls over to a new backing index, managed by an ISM policy")
+	flags.StringVar(&f.lifecycle.RolloverAge, "rollover-age", "", "age like 1d at which the stream rolls over to a new backing index, managed by an ISM policy")
+	flags.StringVar(&f.lifecycle.WarmAfter, "warm-after", "", "age like 7d after which backing indices are made read only and moved to the warm nodes")
+	flags.StringToStringVar(&f.warmAllocation, "warm-allocation", nil, "node attributes like temp=warm of the nodes warm backing indices are moved to")
+	flags.StringVar(&f.lifecycle.DeleteAfter, "delete-after", "", "age like 30d after which backing indices are deleted")
+	flags.IntVar(&f.lifecycle.Priority, "ism-priority", 100, "priority of the ISM policy over other policies matching the backing indices")
+	flags.IntVar(&f.keyLimits.MaxKeys, "max-keys", 0, "number of distinct EventData keys per stream which every replica counts on its own, unlimited when 0")
+	flags.IntVar(&f.keyLimits.MaxKeyLength, "max-key-length", 0, "maximum length of an EventData key in bytes, unlimited when 0")
+	flags.StringVar(&f.keyPattern, "key-pattern", "", "regular expression every EventData key has to match, e.g. ^[a-zA-Z0-9_.]+$, disabled when empty")
+	flags.StringVar(&f.keyPolicy, "key-policy", "reject", "what happens to keys exceeding the key limits: reject the event, drop the keys or flatten them into the overflow field")
+	flags.StringVar(&f.entityIndex, "entity-index", "", "index which holds the latest state of every object, disabled when empty")
+}
+
+// stream returns the configured stream.
+func (f *streamFlags) stream() (opensearch.Stream, error) {
+	lifecycle := f.lifecycle
+	lifecycle.WarmAllocation = f.warmAllocation
+	if err := lifecycle.Validate(); err != nil {
+		return opensearch.Stream{}, err
+	}
+
+	stream := opensearch.Stream{StreamName: f.name, EntityIndexName: f.entityIndex, Retention: lifecycle}
+	if f.templateFile != "" {
+		template, err := readTemplate(f.templateFile)
+		if err != nil {
+			return opensearch.Stream{}, err
+		}
+		stream.IndexTemplate = template
+	}
+	return stream, nil
+}
+
+// templateOptions returns how the index template of the stream is updated.
+func (f *streamFlags) templateOptions() ([]opensearch.TemplateOption, error) {
+	policy, err := opensearch.ParseUpdatePolicy(f.templateUpdate)
+	if err != nil {
+		return nil, err
+	}
+	return []opensearch.TemplateOption{opensearch.WithUpdatePolicy(policy), opensearch.WithRollover(f.templateRollover)}, nil
+}
+
+// keyGuard creates the Guard of the key limits. It is nil when no limit is set.
+func (f *streamFlags) keyGuard() (*mapping.Guard, error) {
+	policy, err := mapping.ParseKeyPolicy(f.keyPolicy)
+	if err != nil {
+		return nil, err
+	}
+	limits := f.keyLimits
+	if f.keyPattern != "" {
+		if limits.KeyPattern, err = regexp.Compile(f.keyPattern); err != nil {
+			return nil, fmt.Errorf("invalid key pattern: %w", err)
+		}
+	}
+	if limits.MaxKeys == 0 && limits.MaxKeyLength == 0 && limits.KeyPattern == nil {
+		return nil, nil
+	}
+	return mapping.NewGuard(limits, policy), nil
+}
+
+// withOverflow maps the overflow field of the stream when the key guard flattens exceeding keys into it.
+func withOverflow(stream opensearch.Stream, guard *mapping.Guard, backend opensearch.Backend) opensearch.Stream {
+	if guard.Policy() != mapping.KeyFlatten {
+		return stream
+	}
+	stream.IndexTemplate.Mappings = mapping.Merge(stream.IndexTemplate.Mappings, map[string]interface{}{
+		"properties": map[string]interface{}{mapping.OverflowField: backend.FlattenedMapping()},
+	})
+	return stream
+}
+
+// readTemplate reads the index template configuration of a stream.
+func readTemplate(path string) (opensearch.Template, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return opensearch.Template{}, fmt.Errorf("unable to read index template: %w", err)
+	}
+	var template opensearch.Template
+	if err := json.Unmarshal(content, &template); err != nil {
+		return opensearch.Template{}, fmt.Errorf("unable to parse index template %s: %w", path, err)
+	}
+	return template, nil
+}
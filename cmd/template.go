@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/stdr"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/spf13/cobra"
+)
+
+var (
+	errDrift           = errors.New("the index template differs from its configuration")
+	errMissingTemplate = errors.New("the index template doesn't exist")
+)
+
+// TemplateCmd manages the index template of a stream without starting the server, e.g. from CI before a deploy.
+// The stream is configured by the same flags as serve. The mappings serve --mapping-inference=apply added
+// to the live index template are kept, they aren't reported as drift.
+func TemplateCmd() *cobra.Command {
+	var (
+		streamConfig streamFlags
+		timeout      time.Duration
+		desired      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "template",
+		Short: "provision and inspect the index template of a stream",
+		// drift is reported through the exit code, the usage doesn't help with it.
+		SilenceUsage: true,
+	}
+
+	// run connects to the cluster taken from OPENSEARCH_URL and passes the configured stream to f.
+	run := func(f func(ctx context.Context, cmd *cobra.Command, client opensearch.Client, stream opensearch.Stream) error) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			ctx := logr.NewContext(cmd.Context(), stdr.New(log.New(os.Stderr, "", log.LstdFlags)))
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			stream, err := streamConfig.stream()
+			if err != nil {
+				return err
+			}
+			guard, err := streamConfig.keyGuard()
+			if err != nil {
+				return err
+			}
+			client, err := opensearch.NewWithDefaultClient()
+			if err != nil {
+				return err
+			}
+			if err := detectBackend(ctx, client); err != nil {
+				return err
+			}
+			stream, err = withTemplateMappings(ctx, client, withOverflow(stream, guard, client.Backend()))
+			if err != nil {
+				return err
+			}
+			return f(ctx, cmd, client, stream)
+		}
+	}
+
+	ensure := &cobra.Command{
+		Use:   "ensure",
+		Short: "create or update the index template and ISM policy of the stream, fails when drift remains",
+		RunE: run(func(ctx context.Context, cmd *cobra.Command, client opensearch.Client, stream opensearch.Stream) error {
+			options, err := streamConfig.templateOptions()
+			if err != nil {
+				return err
+			}
+			if err := opensearch.EnsureStream(ctx, client, stream, options...); err != nil {
+				return err
+			}
+			// the update policy may have left differences in place.
+			return printDiff(ctx, cmd, client, stream)
+		}),
+	}
+
+	diff := &cobra.Command{
+		Use:   "diff",
+		Short: "print the differences between the live and the configured index template, fails when there are any",
+		RunE: run(func(ctx context.Context, cmd *cobra.Command, client opensearch.Client, stream opensearch.Stream) error {
+			return printDiff(ctx, cmd, client, stream)
+		}),
+	}
+
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "print the live index template of the stream",
+		RunE: run(func(ctx context.Context, cmd *cobra.Command, client opensearch.Client, stream opensearch.Stream) error {
+			template, err := opensearch.IndexTemplate(client.Backend(), stream)
+			if err != nil {
+				return err
+			}
+			if !desired {
+				var exists bool
+				if template, exists, err = opensearch.GetIndexTemplate(ctx, client, stream.Name()); err != nil {
+					return err
+				}
+				if !exists {
+					return fmt.Errorf("%w: %s", errMissingTemplate, stream.Name())
+				}
+			}
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(template)
+		}),
+	}
+	show.Flags().BoolVar(&desired, "desired", false, "print the configured index template instead of the live one")
+
+	remove := &cobra.Command{
+		Use:   "delete",
+		Short: "delete the index template of the stream",
+		RunE: run(func(ctx context.Context, cmd *cobra.Command, client opensearch.Client, stream opensearch.Stream) error {
+			deleted, err := opensearch.DeleteIndexTemplate(ctx, client, stream.Name())
+			if err != nil {
+				return err
+			}
+			if !deleted {
+				fmt.Fprintf(cmd.OutOrStdout(), "index template %s doesn't exist\n", stream.Name())
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "deleted index template %s\n", stream.Name())
+			return nil
+		}),
+	}
+
+	for _, sub := range []*cobra.Command{ensure, diff, show, remove} {
+		streamConfig.register(sub)
+		sub.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "time the command may take")
+		cmd.AddCommand(sub)
+	}
+	return cmd
+}
+
+// printDiff prints the differences of the live to the configured index template and returns errDrift if there are any.
+func printDiff(ctx context.Context, cmd *cobra.Command, client opensearch.Client, stream opensearch.Stream) error {
+	diff, err := opensearch.DiffIndexTemplate(ctx, client, stream)
+	if err != nil {
+		return err
+	}
+	if len(diff) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "index template %s is up to date\n", stream.Name())
+		return nil
+	}
+	fmt.Fprint(cmd.OutOrStdout(), diff.String())
+	return fmt.Errorf("%w: %s", errDrift, stream.Name())
+}
@@ -0,0 +1,224 @@
+package grpc
+
+import (
+	context "context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrNoAdminToken = errors.New("the admin service requires a token")
+
+// Pauses keeps track of the streams which don't accept events.
+// All methods can be called on nil Pauses, which never pause a stream.
+type Pauses struct {
+	mu     sync.Mutex
+	paused map[string]bool
+}
+
+func NewPauses() *Pauses {
+	return &Pauses{paused: map[string]bool{}}
+}
+
+func (p *Pauses) Pause(stream string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == nil {
+		p.paused = map[string]bool{}
+	}
+	p.paused[stream] = true
+}
+
+func (p *Pauses) Resume(stream string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.paused, stream)
+}
+
+func (p *Pauses) Paused(stream string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused[stream]
+}
+
+// AdminServer implements the AdminService.
+type AdminServer struct {
+	types.UnimplementedAdminServiceServer
+
+	Debouncer *debounce.Debouncer
+
+	// Streams are the streams the server accepts events for.
+	Streams []opensearch.DataStream
+
+	// Pauses are shared with the Server which rejects events of paused streams.
+	// RunServer creates them when they are nil.
+	Pauses *Pauses
+
+	// InFlight returns the number of bulk requests which are sent right now. It is optional.
+	InFlight func() int64
+
+	// Keys reports the clients exceeding the key limits. It is optional.
+	Keys *mapping.Guard
+}
+
+// Flush writes the pending documents of one or all streams.
+func (a *AdminServer) Flush(ctx context.Context, request *types.FlushRequest) (*types.FlushResponse, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("Admin")
+
+	streams := a.Streams
+	if request.GetStream() != "" {
+		stream, err := a.stream(request.GetStream())
+		if err != nil {
+			return nil, err
+		}
+		streams = []opensearch.DataStream{stream}
+	}
+
+	var indices []string
+	for _, stream := range streams {
+		indices = append(indices, stream.Name())
+		if entityIndex := opensearch.EntityIndexOf(stream); entityIndex != "" {
+			indices = append(indices, entityIndex)
+		}
+	}
+
+	flushed, err := a.Debouncer.FlushIndices(ctx, indices...)
+	log.Info("flushed streams", "stream", request.GetStream(), "documents", flushed)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "flushing failed: %s", err)
+	}
+	return &types.FlushResponse{Documents: int64(flushed)}, nil
+}
+
+// Pause rejects events of the stream until it is resumed.
+func (a *AdminServer) Pause(ctx context.Context, request *types.PauseRequest) (*types.PauseResponse, error) {
+	if _, err := a.stream(request.GetStream()); err != nil {
+		return nil, err
+	}
+	a.Pauses.Pause(request.GetStream())
+	logr.FromContextOrDiscard(ctx).WithName("Admin").Info("paused stream", "stream", request.GetStream())
+	return &types.PauseResponse{}, nil
+}
+
+func (a *AdminServer) Resume(ctx context.Context, request *types.ResumeRequest) (*types.ResumeResponse, error) {
+	if _, err := a.stream(request.GetStream()); err != nil {
+		return nil, err
+	}
+	a.Pauses.Resume(request.GetStream())
+	logr.FromContextOrDiscard(ctx).WithName("Admin").Info("resumed stream", "stream", request.GetStream())
+	return &types.ResumeResponse{}, nil
+}
+
+func (a *AdminServer) Stats(context.Context, *types.StatsRequest) (*types.StatsResponse, error) {
+	stats := a.Debouncer.Stats()
+	queuedDocs, queuedBytes := a.Debouncer.Queued()
+
+	response := &types.StatsResponse{
+		PendingDocuments: map[string]int64{},
+		QueuedDocuments:  int64(queuedDocs),
+		QueuedBytes:      queuedBytes,
+		Written:          int64(stats.Written),
+		Duplicate:        int64(stats.Duplicate),
+		Stale:            int64(stats.Stale),
+		Spilled:          int64(stats.Spilled),
+		Dropped:          int64(stats.Dropped),
+		Failed:           int64(stats.Failed),
+	}
+	for index, pending := range a.Debouncer.PendingByIndex() {
+		response.PendingDocuments[index] = int64(pending)
+	}
+	if a.InFlight != nil {
+		response.InFlightBulks = a.InFlight()
+	}
+	for _, flushErr := range a.Debouncer.LastErrors() {
+		response.LastErrors = append(response.LastErrors, &types.FlushError{
+			Time:  timestamppb.New(flushErr.Time),
+			Error: flushErr.Error,
+		})
+	}
+	return response, nil
+}
+
+func (a *AdminServer) ListStreams(context.Context, *types.ListStreamsRequest) (*types.ListStreamsResponse, error) {
+	response := &types.ListStreamsResponse{}
+	for _, stream := range a.Streams {
+		response.Streams = append(response.Streams, &types.StreamInfo{
+			Name:        stream.Name(),
+			EntityIndex: opensearch.EntityIndexOf(stream),
+			Paused:      a.Pauses.Paused(stream.Name()),
+		})
+	}
+	return response, nil
+}
+
+// KeyOffenders reports the clients which sent the most keys exceeding the key limits.
+func (a *AdminServer) KeyOffenders(_ context.Context, request *types.KeyOffendersRequest) (*types.KeyOffendersResponse, error) {
+	if request.GetStream() != "" {
+		if _, err := a.stream(request.GetStream()); err != nil {
+			return nil, err
+		}
+	}
+	response := &types.KeyOffendersResponse{}
+	for _, offender := range a.Keys.Offenders(request.GetStream(), int(request.GetLimit())) {
+		response.Offenders = append(response.Offenders, &types.KeyOffender{
+			Stream:  offender.Stream,
+			Client:  offender.Client,
+			Keys:    offender.Keys,
+			Events:  offender.Events,
+			LastKey: offender.LastKey,
+		})
+	}
+	return response, nil
+}
+
+// stream returns the configured stream with the given name.
+func (a *AdminServer) stream(name string) (opensearch.DataStream, error) {
+	for _, stream := range a.Streams {
+		if stream.Name() == name {
+			return stream, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "stream %q is not configured", name)
+}
+
+// adminAuth rejects calls of the AdminService which don't carry the admin token.
+// Calls of every other service pass.
+func adminAuth(token string) grpc.UnaryServerInterceptor {
+	prefix := "/" + types.AdminService_ServiceDesc.ServiceName + "/"
+	expected := []byte("Bearer " + token)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(value), expected) == 1 {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "admin token is missing or invalid")
+	}
+}
@@ -0,0 +1,186 @@
+package grpc
+
+import (
+	context "context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestAdmin(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requires Token", func(t *testing.T) {
+		t.Parallel()
+
+		conn, _, _ := startAdminServer(t)
+		admin := types.NewAdminServiceClient(conn)
+
+		_, err := admin.ListStreams(context.Background(), &types.ListStreamsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = admin.ListStreams(withToken("wrong"), &types.ListStreamsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		response, err := admin.ListStreams(withToken("secret"), &types.ListStreamsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, response.GetStreams(), 1)
+		assert.Equal(t, "events", response.GetStreams()[0].GetName())
+		assert.Equal(t, "entities", response.GetStreams()[0].GetEntityIndex())
+	})
+
+	t.Run("Pause", func(t *testing.T) {
+		t.Parallel()
+
+		conn, _, _ := startAdminServer(t)
+		admin := types.NewAdminServiceClient(conn)
+		streaming := types.NewStreamingServiceClient(conn)
+		event := &types.Event{EventID: "1", ObjectID: "object"}
+
+		_, err := admin.Pause(withToken("secret"), &types.PauseRequest{Stream: "unknown"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = admin.Pause(withToken("secret"), &types.PauseRequest{Stream: "events"})
+		assert.NoError(t, err)
+
+		response, err := streaming.Index(context.Background(), event)
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RETRY_LATER, response.GetCode())
+
+		streams, err := admin.ListStreams(withToken("secret"), &types.ListStreamsRequest{})
+		assert.NoError(t, err)
+		assert.True(t, streams.GetStreams()[0].GetPaused())
+
+		_, err = admin.Resume(withToken("secret"), &types.ResumeRequest{Stream: "events"})
+		assert.NoError(t, err)
+
+		response, err = streaming.Index(context.Background(), event)
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, response.GetCode())
+	})
+
+	t.Run("Nil Pauses", func(t *testing.T) {
+		t.Parallel()
+
+		var pauses *Pauses
+		assert.NotPanics(t, func() {
+			pauses.Pause("events")
+			pauses.Resume("events")
+		})
+		assert.False(t, pauses.Paused("events"))
+
+		pauses = &Pauses{}
+		pauses.Pause("events")
+		assert.True(t, pauses.Paused("events"))
+	})
+
+	t.Run("Flush And Stats", func(t *testing.T) {
+		t.Parallel()
+
+		conn, debouncer, indexer := startAdminServer(t)
+		admin := types.NewAdminServiceClient(conn)
+		streaming := types.NewStreamingServiceClient(conn)
+
+		_, err := streaming.Index(context.Background(), &types.Event{EventID: "1", ObjectID: "object"})
+		assert.NoError(t, err)
+
+		stats, err := admin.Stats(withToken("secret"), &types.StatsRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{"events": 1, "entities": 1}, stats.GetPendingDocuments())
+		assert.Equal(t, int64(2), stats.GetQueuedDocuments())
+
+		flushed, err := admin.Flush(withToken("secret"), &types.FlushRequest{Stream: "events"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), flushed.GetDocuments())
+		assert.Equal(t, 0, debouncer.Pending())
+		assert.Equal(t, 2, indexer.written())
+
+		stats, err = admin.Stats(withToken("secret"), &types.StatsRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), stats.GetWritten())
+	})
+
+	t.Run("Key Offenders", func(t *testing.T) {
+		t.Parallel()
+
+		guard := mapping.NewGuard(mapping.Limits{MaxKeys: 1}, mapping.KeyDrop)
+		_, _ = guard.Check("events", "billing", []string{"level", "user-4711", "user-4712"})
+		admin := &AdminServer{Streams: []opensearch.DataStream{opensearch.Stream{StreamName: "events"}}, Keys: guard}
+
+		response, err := admin.KeyOffenders(context.Background(), &types.KeyOffendersRequest{Stream: "events", Limit: 10})
+		assert.NoError(t, err)
+		assert.Len(t, response.GetOffenders(), 1)
+		assert.Equal(t, "billing", response.GetOffenders()[0].GetClient())
+		assert.Equal(t, int64(2), response.GetOffenders()[0].GetKeys())
+		assert.Equal(t, "user-4712", response.GetOffenders()[0].GetLastKey())
+
+		_, err = admin.KeyOffenders(context.Background(), &types.KeyOffendersRequest{Stream: "unknown"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// startAdminServer runs a server with the AdminService on an in-memory connection.
+func startAdminServer(t *testing.T) (*grpc.ClientConn, *debounce.Debouncer, *testingIndexer) {
+	t.Helper()
+
+	indexer := &testingIndexer{}
+	debouncer := debounce.New(indexer)
+	stream := opensearch.Stream{StreamName: "events", EntityIndexName: "entities"}
+	admin := &AdminServer{
+		Debouncer: debouncer,
+		Streams:   []opensearch.DataStream{stream},
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() {
+		served <- RunServer(context.Background(), WithListen(listener), WithDebouncer(debouncer),
+			WithStream(stream), WithAdmin(admin, "secret"))
+	}()
+
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		listener.Close()
+		<-served
+	})
+	return conn, debouncer, indexer
+}
+
+type testingIndexer struct {
+	mu   sync.Mutex
+	docs int
+}
+
+func (t *testingIndexer) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.docs += len(docs)
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
+
+func (t *testingIndexer) written() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.docs
+}
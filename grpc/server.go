@@ -2,31 +2,224 @@ package grpc
 
 import (
 	context "context"
+	"errors"
+	"fmt"
 	"net"
 
 	"github.com/go-logr/logr"
 	"github.com/kstiehl/index-bouncer/api"
 	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/admission"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/idempotency"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/kstiehl/index-bouncer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+var ErrNoDebouncer = errors.New("no debouncer was configured")
+
 type Server struct {
 	types.UnimplementedStreamingServiceServer
+
+	// Debouncer collects the documents of every received event.
+	Debouncer *debounce.Debouncer
+
+	// Stream is the DataStream to which events are written.
+	Stream opensearch.DataStream
+
+	// Idempotency answers retries of recently accepted events. It is optional.
+	Idempotency *idempotency.Cache
+
+	// Metrics counts received, accepted and rejected events. It is optional.
+	Metrics *metrics.Metrics
+
+	// TracerProvider records a span per received event. It is optional.
+	TracerProvider trace.TracerProvider
+
+	// Pauses rejects events of paused streams with RETRY_LATER. It is optional.
+	Pauses *Pauses
+
+	// Mappings rejects events whose keys conflict with the types they were seen with before. It is optional.
+	Mappings *mapping.Tracker
+
+	// Keys limits the distinct keys, key length and key characters of the stream. It is optional.
+	Keys *mapping.Guard
 }
 
-func (Server) Index(ctx context.Context, event *types.Event) (*types.IndexResonse, error) {
-	log := logr.FromContextOrDiscard(ctx).V(1).WithName("Indexer")
+// Index adds the event to the Debouncer within a span which continues the trace of the client.
+// The bulk request which writes the event is linked to this span.
+func (s Server) Index(ctx context.Context, event *types.Event) (*types.IndexResonse, error) {
+	ctx, span := tracing.Tracer(s.TracerProvider).Start(tracing.Extract(ctx), "StreamingService/Index",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("event.id", event.GetEventID()),
+			attribute.String("event.object_id", event.GetObjectID()),
+			attribute.String("event.operation", event.GetOperation().String()),
+			attribute.String("stream", s.Stream.Name()),
+		))
+	defer span.End()
+
+	response, err := s.index(ctx, event)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.String("response.code", response.GetCode().String()))
+	}
+	return response, err
+}
+
+// IndexBatch handles every event of the batch like Index. Events rejected with a gRPC status don't fail
+// the batch, their status is returned as result of the event instead.
+func (s Server) IndexBatch(ctx context.Context, batch *types.EventBatch) (*types.BatchResponse, error) {
+	results := make([]*types.IndexResult, 0, len(batch.GetEvents()))
+	for _, event := range batch.GetEvents() {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		response, err := s.Index(ctx, event)
+		if err != nil {
+			st := status.Convert(err)
+			results = append(results, &types.IndexResult{ErrorCode: int32(st.Code()), ErrorMessage: st.Message()})
+			continue
+		}
+		results = append(results, &types.IndexResult{Code: response.GetCode()})
+	}
+	return &types.BatchResponse{Results: results}, nil
+}
+
+func (s Server) index(ctx context.Context, event *types.Event) (*types.IndexResonse, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("Indexer")
+	stream := s.Stream.Name()
 	if event == nil {
-		log.Info("empty event received. Check client implementation")
+		log.V(1).Info("empty event received. Check client implementation")
+		s.Metrics.Received(stream)
+		s.Metrics.Rejected(stream, metrics.ReasonInvalid)
+		return nil, status.Error(codes.InvalidArgument, "event is empty")
+	}
+
+	if event.GetVersion() != 0 && event.GetOperation() == types.Operation_CREATE {
+		log.V(1).Info("rejected versioned create")
+		s.Metrics.Received(stream)
+		s.Metrics.Rejected(stream, metrics.ReasonInvalid)
+		return nil, status.Error(codes.InvalidArgument, "only INDEX and DELETE operations support versions")
+	}
+
+	// a DELETE without an object state to remove would only append a tombstone, which the client can't tell apart.
+	if event.GetOperation() == types.Operation_DELETE && (opensearch.EntityIndexOf(s.Stream) == "" || event.GetObjectID() == "") {
+		log.V(1).Info("rejected delete without an entity")
+		s.Metrics.Received(stream)
+		s.Metrics.Rejected(stream, metrics.ReasonInvalid)
+		return nil, status.Error(codes.InvalidArgument, "DELETE requires an objectID and a stream with an entity index")
 	}
 
 	// create new logger context so that log messages from now on contain the event.
-	log = log.WithValues("eventID", event.GetEventID(),
-		"objectID", event.ObjectID)
-	ctx = logr.NewContext(ctx, log)
+	ctx = logr.NewContext(ctx, log.WithValues("eventID", event.GetEventID(), "objectID", event.ObjectID))
+
+	write := admission.Write{
+		Stream:         stream,
+		Client:         clientID(ctx),
+		IdempotencyKey: idempotencyKey(event),
+		Documents: func([]int) ([]opensearch.Document, []mapping.Field) {
+			return api.Documents(ctx, s.Stream, event, nil), nil
+		},
+	}
+	if event.GetOperation() != types.Operation_DELETE {
+		for _, value := range event.GetData() {
+			write.Keys = append(write.Keys, value.GetKey())
+		}
+		write.Documents = func(exceeded []int) ([]opensearch.Document, []mapping.Field) {
+			guarded, overflow := s.guard(event, exceeded)
+			return api.Documents(ctx, s.Stream, guarded, overflow), api.EventFields(guarded)
+		}
+	}
+
+	err := s.pipeline().Admit(ctx, write)
+	switch {
+	case err == nil:
+		return &types.IndexResonse{Code: types.StatusCode_RECORD_OK}, nil
+	case errors.Is(err, admission.ErrDuplicate), errors.Is(err, debounce.ErrDuplicateVersion):
+		return &types.IndexResonse{Code: types.StatusCode_DUPLICATE}, nil
+	case errors.Is(err, debounce.ErrStaleVersion):
+		return &types.IndexResonse{Code: types.StatusCode_STALE}, nil
+	case errors.Is(err, admission.ErrPaused), errors.Is(err, debounce.ErrRetryLater):
+		return &types.IndexResonse{Code: types.StatusCode_RETRY_LATER}, nil
+	case errors.Is(err, mapping.ErrKeyLimit), errors.Is(err, mapping.ErrTypeConflict):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, debounce.ErrQueueFull):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil, status.Error(codes.Internal, "failed to index event")
+}
+
+// pipeline returns the admission.Pipeline of the received events.
+func (s Server) pipeline() admission.Pipeline {
+	return admission.Pipeline{
+		Debouncer:   s.Debouncer,
+		Idempotency: s.Idempotency,
+		Metrics:     s.Metrics,
+		Paused:      s.Pauses.Paused,
+		Mappings:    s.Mappings,
+		Keys:        s.Keys,
+	}
+}
+
+// guard returns the event without the data at the exceeded positions,
+// which is returned as overflow when the key policy flattens it.
+func (s Server) guard(event *types.Event, exceeded []int) (*types.Event, []*types.EventData) {
+	if len(exceeded) == 0 {
+		return event, nil
+	}
 
-	api.Index(ctx, nil, event)
-	return &types.IndexResonse{Code: types.StatusCode_RECORD_OK}, nil
+	guarded := proto.Clone(event).(*types.Event)
+	guarded.Data = make([]*types.EventData, 0, len(event.GetData())-len(exceeded))
+	var overflow []*types.EventData
+	for i, value := range event.GetData() {
+		if len(exceeded) > 0 && exceeded[0] == i {
+			exceeded = exceeded[1:]
+			overflow = append(overflow, value)
+			continue
+		}
+		guarded.Data = append(guarded.Data, value)
+	}
+	if s.Keys.Policy() != mapping.KeyFlatten {
+		overflow = nil
+	}
+	return guarded, overflow
+}
+
+// clientID identifies the client of a call by its client-id metadata or its address.
+func clientID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("client-id"); len(ids) > 0 && ids[0] != "" {
+		return ids[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// idempotencyKey identifies retries of an event. Operation and version are part of the key
+// since an event can be indexed again with a newer version.
+func idempotencyKey(event *types.Event) string {
+	return fmt.Sprintf("%s/%d/%d", event.GetEventID(), event.GetOperation(), event.GetVersion())
 }
 
 func (Server) mustEmbedUnimplementedStreamingServiceServer() {
@@ -43,6 +236,71 @@ func WithListenAddress(listen string) Option {
 	}
 }
 
+// WithDebouncer configures the Debouncer which collects the received events.
+func WithDebouncer(debouncer *debounce.Debouncer) Option {
+	return func(options *Options) {
+		options.Debouncer = debouncer
+	}
+}
+
+// WithStream configures the DataStream to which the received events are written.
+func WithStream(stream opensearch.DataStream) Option {
+	return func(options *Options) {
+		options.Stream = stream
+	}
+}
+
+// WithIdempotency configures the cache which answers retries of recently accepted events.
+func WithIdempotency(cache *idempotency.Cache) Option {
+	return func(options *Options) {
+		options.Idempotency = cache
+	}
+}
+
+// WithMetrics configures the Metrics which count received events.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(options *Options) {
+		options.Metrics = m
+	}
+}
+
+// WithTracerProvider configures the provider which records a span per received event.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(options *Options) {
+		options.TracerProvider = provider
+	}
+}
+
+// WithHealth registers the grpc.health.v1 service.
+func WithHealth(server healthpb.HealthServer) Option {
+	return func(options *Options) {
+		options.Health = server
+	}
+}
+
+// WithAdmin registers the AdminService which is protected by the token.
+// Events of streams which are paused by the AdminService are rejected.
+func WithAdmin(admin *AdminServer, token string) Option {
+	return func(options *Options) {
+		options.Admin = admin
+		options.AdminToken = token
+	}
+}
+
+// WithMappings configures the Tracker which rejects events with conflicting types.
+func WithMappings(tracker *mapping.Tracker) Option {
+	return func(options *Options) {
+		options.Mappings = tracker
+	}
+}
+
+// WithKeyGuard configures the Guard which limits the keys of the received events.
+func WithKeyGuard(guard *mapping.Guard) Option {
+	return func(options *Options) {
+		options.Keys = guard
+	}
+}
+
 // WithListen allow to directly configure a net.Listen for the server.
 func WithListen(listener net.Listener) Option {
 	return func(options *Options) {
@@ -57,12 +315,50 @@ type Options struct {
 	// ListenAddress can be used to configure a ListenAddress which is for the grpc server.
 	// This will be ignored when Options.Listen is set.
 	ListenAddress string
+
+	// Debouncer collects the received events. It is required.
+	Debouncer *debounce.Debouncer
+
+	// Stream is the DataStream to which the received events are written.
+	Stream opensearch.DataStream
+
+	// Idempotency answers retries of recently accepted events. It is disabled when nil.
+	Idempotency *idempotency.Cache
+
+	// Metrics counts received events. It is disabled when nil.
+	Metrics *metrics.Metrics
+
+	// TracerProvider records a span per received event. Tracing is disabled when nil.
+	TracerProvider trace.TracerProvider
+
+	// Health is registered as grpc.health.v1 service when set.
+	Health healthpb.HealthServer
+
+	// Admin is registered as AdminService when set. Calls have to carry the AdminToken.
+	Admin      *AdminServer
+	AdminToken string
+
+	// Mappings rejects events with conflicting types. It is disabled when nil.
+	Mappings *mapping.Tracker
+
+	// Keys limits the keys of received events. It is disabled when nil.
+	Keys *mapping.Guard
 }
 
 // InitDefaults initialises Options with default values for each setting.
 func (o *Options) InitWithDefaults() {
 	o.ListenAddress = ":8080"
 	o.Listen = nil
+	o.Debouncer = nil
+	o.Stream = opensearch.Stream{StreamName: api.TargetIndexName}
+	o.Idempotency = nil
+	o.Metrics = nil
+	o.TracerProvider = nil
+	o.Health = nil
+	o.Admin = nil
+	o.AdminToken = ""
+	o.Mappings = nil
+	o.Keys = nil
 }
 
 // ApplyOptions iterates over []Option and applies every single one of them.
@@ -72,7 +368,7 @@ func (o *Options) ApplyOptions(options []Option) {
 	}
 }
 
-// RunServer runs the server and block the goroutine.
+// RunServer runs the server and blocks the goroutine until the context is done and the server stopped gracefully.
 func RunServer(ctx context.Context, options ...Option) error {
 	log := logr.FromContextOrDiscard(ctx)
 
@@ -80,22 +376,72 @@ func RunServer(ctx context.Context, options ...Option) error {
 	serverOptions.InitWithDefaults()
 	serverOptions.ApplyOptions(options)
 
-	gServer := grpc.NewServer()
+	if serverOptions.Debouncer == nil {
+		return ErrNoDebouncer
+	}
 
-	streamServie := Server{}
+	var grpcOptions []grpc.ServerOption
+	var pauses *Pauses
+	if serverOptions.Admin != nil {
+		if serverOptions.AdminToken == "" {
+			return ErrNoAdminToken
+		}
+		grpcOptions = append(grpcOptions, grpc.UnaryInterceptor(adminAuth(serverOptions.AdminToken)))
+		// the admin service has to be able to pause the stream.
+		if serverOptions.Admin.Pauses == nil {
+			serverOptions.Admin.Pauses = NewPauses()
+		}
+		pauses = serverOptions.Admin.Pauses
+	}
+	gServer := grpc.NewServer(grpcOptions...)
+
+	streamServie := Server{
+		Debouncer:      serverOptions.Debouncer,
+		Stream:         serverOptions.Stream,
+		Idempotency:    serverOptions.Idempotency,
+		Metrics:        serverOptions.Metrics,
+		TracerProvider: serverOptions.TracerProvider,
+		Pauses:         pauses,
+		Mappings:       serverOptions.Mappings,
+		Keys:           serverOptions.Keys,
+	}
 	types.RegisterStreamingServiceServer(gServer, streamServie)
+	if serverOptions.Health != nil {
+		healthpb.RegisterHealthServer(gServer, serverOptions.Health)
+	}
+	if serverOptions.Admin != nil {
+		types.RegisterAdminServiceServer(gServer, serverOptions.Admin)
+	}
+	// reflection allows to call the services with grpcurl.
+	reflection.Register(gServer)
 
 	listen, err := getServerListen(serverOptions)
 	if err != nil {
-		log.Error(err, "unbale to to listen", "listenAddr", listen.Addr().String())
+		log.Error(err, "unbale to to listen", "listenAddr", serverOptions.ListenAddress)
 		return err
 	}
 
 	log.Info("server listening", "listenAddr", listen.Addr().String())
 
+	// the server stops once the context is done. Calls which are in progress are completed first,
+	// so that their events are added before the debouncer writes the pending ones.
+	served := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			log.Info("stopping server")
+			gServer.GracefulStop()
+		case <-served:
+		}
+	}()
+
 	if err := gServer.Serve(listen); err != nil {
 		log.Error(err, "error when listening", "port", serverOptions.ListenAddress)
 	}
+	close(served)
+	<-stopped
 	return nil
 }
 
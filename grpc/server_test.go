@@ -0,0 +1,203 @@
+package grpc
+
+import (
+	context "context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Type Conflicts", func(t *testing.T) {
+		t.Parallel()
+
+		server := Server{
+			Debouncer: debounce.New(&testingIndexer{}),
+			Stream:    opensearch.Stream{StreamName: "events"},
+			Mappings:  mapping.NewTracker(),
+		}
+		event := func(id string, value *types.EventData) *types.Event {
+			return &types.Event{EventID: id, ObjectID: "object", Data: []*types.EventData{value}}
+		}
+
+		response, err := server.Index(context.Background(), event("1",
+			&types.EventData{Key: "enabled", Value: &types.EventData_BoolValue{BoolValue: true}}))
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, response.GetCode())
+
+		_, err = server.Index(context.Background(), event("2",
+			&types.EventData{Key: "enabled", Value: &types.EventData_StringValue{StringValue: "yes"}}))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), `key "enabled" of stream events was seen as bool and can't be a string`)
+
+		// deletes carry no data which could conflict.
+		deleted := event("2", &types.EventData{Key: "enabled", Value: &types.EventData_StringValue{StringValue: "yes"}})
+		deleted.Operation = types.Operation_DELETE
+		server.Stream = opensearch.Stream{StreamName: "events", EntityIndexName: "entities"}
+		_, err = server.Index(context.Background(), deleted)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Key Limits", func(t *testing.T) {
+		t.Parallel()
+
+		event := &types.Event{EventID: "1", ObjectID: "object", Data: []*types.EventData{
+			{Key: "level", Value: &types.EventData_StringValue{StringValue: "info"}},
+			{Key: "user-4711", Value: &types.EventData_BoolValue{BoolValue: true}},
+		}}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("client-id", "billing"))
+
+		for _, test := range []struct {
+			policy   mapping.KeyPolicy
+			code     codes.Code
+			overflow []*types.EventData
+		}{
+			{policy: mapping.KeyReject, code: codes.InvalidArgument},
+			{policy: mapping.KeyDrop},
+			{policy: mapping.KeyFlatten, overflow: event.Data[1:]},
+		} {
+			server := Server{
+				Debouncer: debounce.New(&testingIndexer{}),
+				Stream:    opensearch.Stream{StreamName: "events"},
+				Mappings:  mapping.NewTracker(),
+				Keys:      mapping.NewGuard(mapping.Limits{MaxKeyLength: 8}, test.policy),
+			}
+
+			_, err := server.Index(ctx, event)
+			assert.Equal(t, test.code, status.Code(err), test.policy)
+			if test.code != codes.OK {
+				continue
+			}
+			assert.Equal(t, map[string]mapping.Type{"level": mapping.TypeString}, server.Mappings.Fields("events"), test.policy)
+
+			guarded, overflow := server.guard(event, []int{1})
+			assert.Equal(t, event.Data[:1], guarded.Data, test.policy)
+			assert.Equal(t, test.overflow, overflow, test.policy)
+			// the received event is left untouched.
+			assert.Len(t, event.Data, 2)
+
+			offenders := server.Keys.Offenders("events", 0)
+			assert.Equal(t, []mapping.Offender{{Stream: "events", Client: "billing", Keys: 1, Events: 1, LastKey: "user-4711"}}, offenders)
+		}
+	})
+	t.Run("Versioned Create", func(t *testing.T) {
+		t.Parallel()
+
+		server := Server{
+			Debouncer: debounce.New(&testingIndexer{}),
+			Stream:    opensearch.Stream{StreamName: "events"},
+			Mappings:  mapping.NewTracker(),
+		}
+		_, err := server.Index(context.Background(), &types.Event{EventID: "1", ObjectID: "object", Version: 1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		response, err := server.Index(context.Background(), &types.Event{EventID: "2", ObjectID: "object", Version: 1, Operation: types.Operation_INDEX})
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, response.GetCode())
+	})
+	t.Run("Delete Without Entity", func(t *testing.T) {
+		t.Parallel()
+
+		server := Server{Debouncer: debounce.New(&testingIndexer{}), Stream: opensearch.Stream{StreamName: "events"}}
+		_, err := server.Index(context.Background(), &types.Event{EventID: "1", ObjectID: "object", Operation: types.Operation_DELETE})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		server.Stream = opensearch.Stream{StreamName: "events", EntityIndexName: "entities"}
+		_, err = server.Index(context.Background(), &types.Event{EventID: "2", Operation: types.Operation_DELETE})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Zero(t, server.Debouncer.Pending())
+	})
+	t.Run("Rejected Entity Leaves The Stream Untouched", func(t *testing.T) {
+		t.Parallel()
+
+		server := Server{
+			Debouncer: debounce.New(&testingIndexer{}, debounce.WithQueueLimits(3, 0, debounce.PolicyReject)),
+			Stream:    opensearch.Stream{StreamName: "events", EntityIndexName: "entities"},
+		}
+		event := func(id string, version int64) *types.Event {
+			return &types.Event{EventID: id, ObjectID: "object", Version: version, Operation: types.Operation_INDEX}
+		}
+
+		response, err := server.Index(context.Background(), event("1", 2))
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, response.GetCode())
+
+		response, err = server.Index(context.Background(), event("2", 1))
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_STALE, response.GetCode())
+		assert.Equal(t, 2, server.Debouncer.Pending())
+
+		// the stream document fits, the entity document of another object doesn't.
+		other := event("3", 1)
+		other.ObjectID = "other"
+		_, err = server.Index(context.Background(), other)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, 2, server.Debouncer.Pending())
+	})
+	t.Run("Graceful Stop", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := debounce.New(indexer, debounce.WithFlushInterval(time.Hour))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		listener := bufconn.Listen(1 << 20)
+		served := make(chan error, 1)
+		go func() {
+			served <- RunServer(ctx, WithListen(listener), WithDebouncer(debouncer), WithStream(opensearch.Stream{StreamName: "events"}))
+		}()
+		flushed := make(chan error, 1)
+		go func() { flushed <- debouncer.Run(ctx) }()
+
+		conn, err := grpc.DialContext(context.Background(), "bufconn",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		response, err := types.NewStreamingServiceClient(conn).Index(context.Background(), &types.Event{EventID: "1", ObjectID: "object"})
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, response.GetCode())
+
+		// the server stops without closing the listener and the accepted event is written.
+		cancel()
+		assert.NoError(t, <-served)
+		assert.NoError(t, <-flushed)
+		assert.Equal(t, 1, indexer.written())
+	})
+	t.Run("Batch", func(t *testing.T) {
+		t.Parallel()
+
+		server := Server{
+			Debouncer: debounce.New(&testingIndexer{}),
+			Stream:    opensearch.Stream{StreamName: "events"},
+			Mappings:  mapping.NewTracker(),
+		}
+		response, err := server.IndexBatch(context.Background(), &types.EventBatch{Events: []*types.Event{
+			{EventID: "1", ObjectID: "object", Data: []*types.EventData{{Key: "state", Value: &types.EventData_StringValue{StringValue: "paid"}}}},
+			{EventID: "2", ObjectID: "object", Data: []*types.EventData{{Key: "state", Value: &types.EventData_BoolValue{}}}},
+			nil,
+		}})
+		assert.NoError(t, err)
+		assert.Len(t, response.GetResults(), 3)
+		assert.Equal(t, types.StatusCode_RECORD_OK, response.GetResults()[0].GetCode())
+		assert.Equal(t, int32(codes.InvalidArgument), response.GetResults()[1].GetErrorCode())
+		assert.Contains(t, response.GetResults()[1].GetErrorMessage(), "can't be a bool")
+		assert.Equal(t, int32(codes.InvalidArgument), response.GetResults()[2].GetErrorCode())
+	})
+}
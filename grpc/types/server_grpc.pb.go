@@ -23,6 +23,8 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StreamingServiceClient interface {
 	Index(ctx context.Context, in *Event, opts ...grpc.CallOption) (*IndexResonse, error)
+	// IndexBatch handles every event of the batch like Index.
+	IndexBatch(ctx context.Context, in *EventBatch, opts ...grpc.CallOption) (*BatchResponse, error)
 }
 
 type streamingServiceClient struct {
@@ -42,11 +44,22 @@ func (c *streamingServiceClient) Index(ctx context.Context, in *Event, opts ...g
 	return out, nil
 }
 
+func (c *streamingServiceClient) IndexBatch(ctx context.Context, in *EventBatch, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/StreamingService/IndexBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StreamingServiceServer is the server API for StreamingService service.
 // All implementations must embed UnimplementedStreamingServiceServer
 // for forward compatibility
 type StreamingServiceServer interface {
 	Index(context.Context, *Event) (*IndexResonse, error)
+	// IndexBatch handles every event of the batch like Index.
+	IndexBatch(context.Context, *EventBatch) (*BatchResponse, error)
 	mustEmbedUnimplementedStreamingServiceServer()
 }
 
@@ -57,6 +70,9 @@ type UnimplementedStreamingServiceServer struct {
 func (UnimplementedStreamingServiceServer) Index(context.Context, *Event) (*IndexResonse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Index not implemented")
 }
+func (UnimplementedStreamingServiceServer) IndexBatch(context.Context, *EventBatch) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IndexBatch not implemented")
+}
 func (UnimplementedStreamingServiceServer) mustEmbedUnimplementedStreamingServiceServer() {}
 
 // UnsafeStreamingServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +104,24 @@ func _StreamingService_Index_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StreamingService_IndexBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EventBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StreamingServiceServer).IndexBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StreamingService/IndexBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StreamingServiceServer).IndexBatch(ctx, req.(*EventBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StreamingService_ServiceDesc is the grpc.ServiceDesc for StreamingService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +133,10 @@ var StreamingService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Index",
 			Handler:    _StreamingService_Index_Handler,
 		},
+		{
+			MethodName: "IndexBatch",
+			Handler:    _StreamingService_IndexBatch_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/server.proto",
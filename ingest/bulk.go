@@ -0,0 +1,243 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errParse is returned when a request can't be parsed at all.
+var errParse = errors.New("failed to parse request")
+
+// Error types of Elasticsearch which clients and shippers act upon, e.g. 429s are retried.
+const (
+	typeParse           = "parse_exception"
+	typeMapperParsing   = "mapper_parsing_exception"
+	typeValidation      = "action_request_validation_exception"
+	typeVersionConflict = "version_conflict_engine_exception"
+	typeRejected        = "es_rejected_execution_exception"
+	typeIndexNotFound   = "index_not_found_exception"
+	typeContentTooLong  = "content_too_long_exception"
+	typeIllegalArgument = "illegal_argument_exception"
+	typeInternal        = "exception"
+)
+
+type errorCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type errorResponse struct {
+	Error struct {
+		RootCause []errorCause `json:"root_cause"`
+		errorCause
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+type shards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+// itemResult is the response of a single document and an item of the bulk response.
+type itemResult struct {
+	Index   string      `json:"_index"`
+	ID      string      `json:"_id"`
+	Version int64       `json:"_version,omitempty"`
+	Result  string      `json:"result,omitempty"`
+	Shards  *shards     `json:"_shards,omitempty"`
+	Status  int         `json:"status"`
+	Error   *errorCause `json:"error,omitempty"`
+}
+
+func (r itemResult) fail(status int, errorType, reason string) itemResult {
+	r.Status = status
+	r.Error = &errorCause{Type: errorType, Reason: reason}
+	return r
+}
+
+type bulkResponse struct {
+	Took   int64                   `json:"took"`
+	Errors bool                    `json:"errors"`
+	Items  []map[string]itemResult `json:"items"`
+}
+
+// bulkItem is a parsed action of a bulk request.
+type bulkItem struct {
+	action string
+	doc    Document
+
+	// invalid is set when the item can't be added.
+	invalid *itemResult
+}
+
+type bulkMeta struct {
+	Index       string `json:"_index"`
+	ID          string `json:"_id"`
+	Version     int64  `json:"version"`
+	VersionType string `json:"version_type"`
+}
+
+// parseBulk parses the NDJSON body of a bulk request. Items which are invalid are returned
+// with their error, only a malformed action line fails the whole request.
+func parseBulk(body io.Reader, defaultIndex string, span trace.SpanContext) ([]bulkItem, error) {
+	reader := bufio.NewReader(body)
+
+	var items []bulkItem
+	for line := 1; ; line++ {
+		actionLine, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var actions map[string]bulkMeta
+		if err := json.Unmarshal(actionLine, &actions); err != nil || len(actions) != 1 {
+			return nil, fmt.Errorf("%w: malformed action on line [%d], expected an object with a single action", errParse, line)
+		}
+
+		var item bulkItem
+		var meta bulkMeta
+		for action, actionMeta := range actions {
+			item.action, meta = action, actionMeta
+		}
+		item.doc = Document{
+			IndexName:       meta.Index,
+			DocID:           meta.ID,
+			ExternalVersion: meta.Version,
+			Span:            span,
+		}
+		if item.doc.IndexName == "" {
+			item.doc.IndexName = defaultIndex
+		}
+
+		switch item.action {
+		case "index":
+			item.doc.Op = opensearch.ActionIndex
+		case "create":
+			item.doc.Op = opensearch.ActionCreate
+		case "update":
+			item.doc.Op = opensearch.ActionUpdate
+		case "delete":
+			item.doc.Op = opensearch.ActionDelete
+		default:
+			return nil, fmt.Errorf("%w: unknown action [%s] on line [%d]", errParse, item.action, line)
+		}
+
+		var source []byte
+		if item.doc.Op != opensearch.ActionDelete {
+			line++
+			source, err = readLine(reader)
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("%w: the action on line [%d] has no document", errParse, line-1)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		items = append(items, parseItem(item, meta, source))
+	}
+}
+
+// parseItem validates the item and decodes its source.
+func parseItem(item bulkItem, meta bulkMeta, source []byte) bulkItem {
+	invalid := func(errorType, reason string) bulkItem {
+		result := itemResult{Index: item.doc.IndexName, ID: item.doc.DocID}.fail(http.StatusBadRequest, errorType, reason)
+		item.invalid = &result
+		return item
+	}
+
+	if item.doc.IndexName == "" {
+		return invalid(typeValidation, "index is missing")
+	}
+	if item.doc.DocID == "" {
+		if item.doc.Op == opensearch.ActionUpdate || item.doc.Op == opensearch.ActionDelete {
+			return invalid(typeValidation, "id is missing")
+		}
+		item.doc.DocID = newID()
+	}
+	if err := checkVersion(item.doc, meta.VersionType); err != nil {
+		return invalid(typeValidation, err.Error())
+	}
+	if item.doc.Op == opensearch.ActionDelete {
+		return item
+	}
+
+	decoded, err := decodeSource(bytes.NewReader(source))
+	if err != nil {
+		return invalid(typeMapperParsing, err.Error())
+	}
+	if item.doc.Op != opensearch.ActionUpdate {
+		item.doc.Source = decoded
+		return item
+	}
+
+	partial, ok := decoded["doc"].(map[string]interface{})
+	if !ok {
+		return invalid(typeValidation, "updates have to contain a partial document, scripts are not supported")
+	}
+	item.doc.Source = partial
+	return item
+}
+
+// readLine returns the next line which isn't empty or io.EOF.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeError answers with an error in the shape Elasticsearch uses.
+func writeError(w http.ResponseWriter, status int, errorType, reason string) {
+	response := errorResponse{Status: status}
+	response.Error.errorCause = errorCause{Type: errorType, Reason: reason}
+	response.Error.RootCause = []errorCause{response.Error.errorCause}
+	writeJSON(w, status, response)
+}
+
+// writeBodyError answers a request whose body couldn't be read.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, typeContentTooLong,
+			fmt.Sprintf("the request body exceeds the limit of [%d] bytes", tooLarge.Limit))
+	case errors.Is(err, errParse):
+		writeError(w, http.StatusBadRequest, typeParse, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, typeMapperParsing, err.Error())
+	}
+}
+
+func noHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusBadRequest, typeIllegalArgument,
+		fmt.Sprintf("no handler found for uri [%s] and method [%s]", r.URL.Path, r.Method))
+}
@@ -0,0 +1,56 @@
+package ingest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Document is a document which was sent in the format of the Elasticsearch document APIs.
+type Document struct {
+	IndexName string
+	DocID     string
+	Op        opensearch.Action
+
+	// Source is the document, or the partial document of an update. It is nil for deletes.
+	Source map[string]interface{}
+
+	// ExternalVersion is the version given with version_type=external, 0 when unversioned.
+	ExternalVersion int64
+
+	// Span is the span the document was received in. It is optional.
+	Span trace.SpanContext
+}
+
+func (d Document) ID() string {
+	return d.DocID
+}
+
+func (d Document) Index() string {
+	return d.IndexName
+}
+
+func (d Document) Data() interface{} {
+	return d.Source
+}
+
+func (d Document) Action() opensearch.Action {
+	return d.Op
+}
+
+func (d Document) Version() int64 {
+	return d.ExternalVersion
+}
+
+func (d Document) SpanContext() trace.SpanContext {
+	return d.Span
+}
+
+// newID generates an ID for documents which are sent without one, like Elasticsearch does.
+func newID() string {
+	id := make([]byte, 15)
+	_, _ = rand.Read(id)
+	return base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+)
+
+// leaf is a value of a document which isn't an object.
+type leaf struct {
+	path  []string
+	value interface{}
+}
+
+// key returns the dotted path of the leaf like opensearch maps it, e.g. "a.b" of {"a":{"b":1}}.
+func (l leaf) key() string {
+	return strings.Join(l.path, ".")
+}
+
+// field returns the key and type of the leaf. Arrays and nulls aren't tracked.
+func (l leaf) field() (mapping.Field, bool) {
+	field := mapping.Field{Key: l.key()}
+	switch l.value.(type) {
+	case string:
+		field.Type = mapping.TypeString
+	case bool:
+		field.Type = mapping.TypeBool
+	case json.Number:
+		field.Type = mapping.TypeNumber
+	default:
+		return field, false
+	}
+	return field, true
+}
+
+// leaves returns the leaves of the source sorted by their path.
+func leaves(source map[string]interface{}, prefix []string) []leaf {
+	names := make([]string, 0, len(source))
+	for name := range source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var found []leaf
+	for _, name := range names {
+		path := append(prefix[:len(prefix):len(prefix)], name)
+		if object, ok := source[name].(map[string]interface{}); ok {
+			found = append(found, leaves(object, path)...)
+			continue
+		}
+		found = append(found, leaf{path: path, value: source[name]})
+	}
+	return found
+}
+
+// guardSource returns the source without the leaves at the exceeded positions. They are moved
+// to mapping.OverflowField when the policy flattens them. The source itself is left untouched.
+func guardSource(source map[string]interface{}, found []leaf, exceeded []int, policy mapping.KeyPolicy) map[string]interface{} {
+	if len(exceeded) == 0 {
+		return source
+	}
+
+	overflow := make(map[string]interface{}, len(exceeded))
+	for _, i := range exceeded {
+		source = withoutLeaf(source, found[i].path)
+		overflow[found[i].key()] = found[i].value
+	}
+	if policy == mapping.KeyFlatten {
+		source[mapping.OverflowField] = overflow
+	}
+	return source
+}
+
+// withoutLeaf returns a copy of the source without the value at the path. Only the objects
+// along the path are copied.
+func withoutLeaf(source map[string]interface{}, path []string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(source))
+	for name, value := range source {
+		copied[name] = value
+	}
+	if len(path) == 1 {
+		delete(copied, path[0])
+		return copied
+	}
+	if object, ok := source[path[0]].(map[string]interface{}); ok {
+		copied[path[0]] = withoutLeaf(object, path[1:])
+	}
+	return copied
+}
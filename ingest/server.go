@@ -0,0 +1,380 @@
+package ingest
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kstiehl/index-bouncer/pkg/admission"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/idempotency"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/kstiehl/index-bouncer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Version is the Elasticsearch version the Server claims to be. Clients check it
+// before they send requests, 7.10 is the last version every client accepts without a product header.
+const Version = "7.10.2"
+
+// Server accepts documents through the document and bulk APIs of Elasticsearch, so that
+// existing clients and shippers can write through the bouncer without changes.
+// Documents are acknowledged as soon as they are pending, which is why every accepted
+// document is reported as created, or deleted, regardless of what opensearch does with it later.
+// Updates only support partial documents and always upsert.
+type Server struct {
+	// Debouncer collects the received documents. It is required.
+	Debouncer *debounce.Debouncer
+
+	// Indices are path.Match patterns of the indices documents may be written to.
+	// Documents of every index are accepted when empty.
+	Indices []string
+
+	// Paused reports whether documents of the index are rejected right now. It is optional.
+	Paused func(index string) bool
+
+	// Idempotency answers retries of recently accepted creates and versioned documents
+	// with a version conflict. It is optional.
+	Idempotency *idempotency.Cache
+
+	// Mappings rejects documents whose fields conflict with the types they were seen with before. It is optional.
+	Mappings *mapping.Tracker
+
+	// Keys limits the distinct keys, key length and key characters per index. It is optional.
+	Keys *mapping.Guard
+
+	// MaxBodyBytes limits the size of a request body, before and after decompression.
+	// It is unlimited when 0.
+	MaxBodyBytes int64
+
+	// Metrics counts received, accepted and rejected documents per index. It is optional.
+	Metrics *metrics.Metrics
+
+	// TracerProvider records a span per request. It is optional.
+	TracerProvider trace.TracerProvider
+}
+
+// Handler routes the supported Elasticsearch APIs to the Server.
+func (s *Server) Handler() http.Handler {
+	router := httprouter.New()
+	router.GET("/", s.info)
+	router.HEAD("/", s.info)
+
+	// httprouter doesn't allow /_bulk next to the index wildcard, so it is dispatched by bulkRoot.
+	router.POST("/:index", s.bulkRoot)
+	router.PUT("/:index", s.bulkRoot)
+	router.POST("/:index/_bulk", s.bulk)
+	router.PUT("/:index/_bulk", s.bulk)
+
+	router.POST("/:index/_doc", s.document(opensearch.ActionIndex))
+	router.POST("/:index/_doc/:id", s.document(opensearch.ActionIndex))
+	router.PUT("/:index/_doc/:id", s.document(opensearch.ActionIndex))
+	router.DELETE("/:index/_doc/:id", s.document(opensearch.ActionDelete))
+	router.POST("/:index/_create/:id", s.document(opensearch.ActionCreate))
+	router.PUT("/:index/_create/:id", s.document(opensearch.ActionCreate))
+
+	router.NotFound = http.HandlerFunc(noHandler)
+	router.MethodNotAllowed = http.HandlerFunc(noHandler)
+	return router
+}
+
+// info answers the request clients send to detect the version of the cluster.
+func (s *Server) info(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":         "index-bouncer",
+		"cluster_name": "index-bouncer",
+		"version": map[string]interface{}{
+			"number":                              Version,
+			"build_flavor":                        "default",
+			"lucene_version":                      "8.7.0",
+			"minimum_wire_compatibility_version":  "6.8.0",
+			"minimum_index_compatibility_version": "6.0.0-beta1",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+func (s *Server) bulkRoot(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if params.ByName("index") != "_bulk" {
+		noHandler(w, r)
+		return
+	}
+	s.bulk(w, r, httprouter.Params{})
+}
+
+// bulk adds every document of an NDJSON bulk request. The index of the path is used
+// for documents whose action doesn't name one.
+func (s *Server) bulk(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	started := time.Now()
+	ctx, span := s.start(r, "ingest.bulk")
+	defer span.End()
+
+	body, err := s.body(w, r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	items, err := parseBulk(body, params.ByName("index"), span.SpanContext())
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	response := bulkResponse{Items: make([]map[string]itemResult, 0, len(items))}
+	for _, item := range items {
+		result := item.invalid
+		if result == nil {
+			added := s.add(ctx, clientID(r), item.doc)
+			result = &added
+		}
+		if result.Error != nil {
+			response.Errors = true
+		}
+		response.Items = append(response.Items, map[string]itemResult{item.action: *result})
+	}
+	response.Took = time.Since(started).Milliseconds()
+	span.SetAttributes(attribute.Int("bulk.documents", len(items)), attribute.Bool("bulk.errors", response.Errors))
+	writeJSON(w, http.StatusOK, response)
+}
+
+// document returns the handler of the single document APIs which use the given action.
+func (s *Server) document(action opensearch.Action) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		ctx, span := s.start(r, "ingest.document")
+		defer span.End()
+
+		query := r.URL.Query()
+		doc := Document{
+			IndexName: params.ByName("index"),
+			DocID:     params.ByName("id"),
+			Op:        action,
+			Span:      span.SpanContext(),
+		}
+		if doc.DocID == "" {
+			doc.DocID = newID()
+		}
+		if doc.Op == opensearch.ActionIndex && query.Get("op_type") == "create" {
+			doc.Op = opensearch.ActionCreate
+		}
+
+		if version := query.Get("version"); version != "" {
+			parsed, err := strconv.ParseInt(version, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, typeValidation, fmt.Sprintf("invalid version [%s]", version))
+				return
+			}
+			doc.ExternalVersion = parsed
+		}
+		if err := checkVersion(doc, query.Get("version_type")); err != nil {
+			writeError(w, http.StatusBadRequest, typeValidation, err.Error())
+			return
+		}
+
+		if doc.Op != opensearch.ActionDelete {
+			body, err := s.body(w, r)
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			source, err := decodeSource(body)
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			doc.Source = source
+		}
+
+		result := s.add(ctx, clientID(r), doc)
+		if result.Error != nil {
+			writeError(w, result.Status, result.Error.Type, result.Error.Reason)
+			return
+		}
+		writeJSON(w, result.Status, result)
+	}
+}
+
+// add admits the document through the same pipeline as the events of the gRPC API
+// and reports the outcome in the shape of a bulk item.
+func (s *Server) add(ctx context.Context, client string, doc Document) itemResult {
+	result := itemResult{Index: doc.IndexName, ID: doc.DocID}
+	if !s.allowed(doc.IndexName) {
+		return result.fail(http.StatusNotFound, typeIndexNotFound, fmt.Sprintf("no such index [%s]", doc.IndexName))
+	}
+
+	found := leaves(doc.Source, nil)
+	write := admission.Write{
+		Stream: doc.IndexName,
+		Client: client,
+		Keys:   make([]string, 0, len(found)),
+		Documents: func(exceeded []int) ([]opensearch.Document, []mapping.Field) {
+			guarded := doc
+			if doc.Source != nil {
+				guarded.Source = guardSource(doc.Source, found, exceeded, s.Keys.Policy())
+			}
+			var fields []mapping.Field
+			for i, leaf := range found {
+				if len(exceeded) > 0 && exceeded[0] == i {
+					exceeded = exceeded[1:]
+					continue
+				}
+				if field, ok := leaf.field(); ok {
+					fields = append(fields, field)
+				}
+			}
+			return []opensearch.Document{guarded}, fields
+		},
+	}
+	for _, leaf := range found {
+		write.Keys = append(write.Keys, leaf.key())
+	}
+	// only creates and versioned documents are rejected by opensearch when they are written twice.
+	if doc.Op == opensearch.ActionCreate || doc.ExternalVersion > 0 {
+		write.IdempotencyKey = fmt.Sprintf("%s/%s/%s/%d", doc.IndexName, doc.DocID, doc.Op, doc.ExternalVersion)
+	}
+
+	pipeline := admission.Pipeline{
+		Debouncer:   s.Debouncer,
+		Idempotency: s.Idempotency,
+		Metrics:     s.Metrics,
+		Paused:      s.Paused,
+		Mappings:    s.Mappings,
+		Keys:        s.Keys,
+	}
+	err := pipeline.Admit(ctx, write)
+	switch {
+	case errors.Is(err, admission.ErrPaused):
+		return result.fail(http.StatusTooManyRequests, typeRejected, fmt.Sprintf("index [%s] is paused", doc.IndexName))
+	case errors.Is(err, admission.ErrDuplicate):
+		return result.fail(http.StatusConflict, typeVersionConflict, fmt.Sprintf("[%s]: version conflict, document was written already", doc.DocID))
+	case errors.Is(err, debounce.ErrDuplicateVersion), errors.Is(err, debounce.ErrStaleVersion):
+		return result.fail(http.StatusConflict, typeVersionConflict, fmt.Sprintf("[%s]: version conflict, %s", doc.DocID, err))
+	case errors.Is(err, mapping.ErrKeyLimit):
+		return result.fail(http.StatusBadRequest, typeIllegalArgument, err.Error())
+	case errors.Is(err, mapping.ErrTypeConflict):
+		return result.fail(http.StatusBadRequest, typeMapperParsing, err.Error())
+	case errors.Is(err, debounce.ErrRetryLater), errors.Is(err, debounce.ErrQueueFull):
+		return result.fail(http.StatusTooManyRequests, typeRejected, err.Error())
+	case err != nil:
+		return result.fail(http.StatusInternalServerError, typeInternal, "failed to index document")
+	}
+
+	result.Version = 1
+	if doc.ExternalVersion > 0 {
+		result.Version = doc.ExternalVersion
+	}
+	result.Shards = &shards{Total: 1, Successful: 1}
+	switch doc.Op {
+	case opensearch.ActionDelete:
+		result.Result, result.Status = "deleted", http.StatusOK
+	case opensearch.ActionUpdate:
+		result.Result, result.Status = "updated", http.StatusOK
+	default:
+		result.Result, result.Status = "created", http.StatusCreated
+	}
+	return result
+}
+
+// allowed reports whether documents may be written to the index.
+func (s *Server) allowed(index string) bool {
+	if len(s.Indices) == 0 {
+		return true
+	}
+	for _, pattern := range s.Indices {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clientID identifies the client of a request by its X-Client-ID header or its address.
+func clientID(r *http.Request) string {
+	if id := r.Header.Get("X-Client-ID"); id != "" {
+		return id
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// start starts the span of a request, which continues the trace of the client.
+func (s *Server) start(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracing.Tracer(s.TracerProvider).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+		))
+}
+
+// body returns the limited and, if necessary, decompressed request body.
+func (s *Server) body(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	var body io.ReadCloser = r.Body
+	if s.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, body, s.MaxBodyBytes)
+	}
+
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		decompressed, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errParse, err)
+		}
+		if s.MaxBodyBytes > 0 {
+			return http.MaxBytesReader(w, decompressed, s.MaxBodyBytes), nil
+		}
+		return decompressed, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported content encoding [%s]", errParse, r.Header.Get("Content-Encoding"))
+}
+
+// checkVersion rejects versions opensearch can't apply to the document.
+func checkVersion(doc Document, versionType string) error {
+	if doc.ExternalVersion == 0 {
+		return nil
+	}
+	if versionType != "external" {
+		return fmt.Errorf("version_type [%s] is not supported, only external versions are", versionType)
+	}
+	if doc.Op != opensearch.ActionIndex && doc.Op != opensearch.ActionDelete {
+		return fmt.Errorf("[%s] operations don't support external versions", doc.Op)
+	}
+	return nil
+}
+
+// decodeSource decodes a JSON object. Numbers are kept as json.Number so that they are written unchanged.
+func decodeSource(body io.Reader) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+
+	var source map[string]interface{}
+	if err := decoder.Decode(&source); err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+	if source == nil {
+		return nil, errors.New("the document is not an object")
+	}
+	return source, nil
+}
@@ -0,0 +1,305 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/idempotency"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Info", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{})
+		var info struct {
+			Version struct {
+				Number      string `json:"number"`
+				BuildFlavor string `json:"build_flavor"`
+			} `json:"version"`
+			Tagline string `json:"tagline"`
+		}
+		status := request(t, server, http.MethodGet, "/", "", &info)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, Version, info.Version.Number)
+		assert.Equal(t, "default", info.Version.BuildFlavor)
+		assert.Equal(t, "You Know, for Search", info.Tagline)
+	})
+
+	t.Run("Document", func(t *testing.T) {
+		t.Parallel()
+
+		server, indexer := startServer(t, &Server{})
+
+		var result itemResult
+		status := request(t, server, http.MethodPut, "/logs/_doc/1", `{"message":"hello","count":12345678901234567890}`, &result)
+		assert.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, "logs", result.Index)
+		assert.Equal(t, "1", result.ID)
+		assert.Equal(t, "created", result.Result)
+
+		status = request(t, server, http.MethodPost, "/logs/_doc", `{"message":"generated id"}`, &result)
+		assert.Equal(t, http.StatusCreated, status)
+		assert.NotEmpty(t, result.ID)
+
+		status = request(t, server, http.MethodPut, "/logs/_create/2", `{"message":"created"}`, &result)
+		assert.Equal(t, http.StatusCreated, status)
+
+		status = request(t, server, http.MethodDelete, "/logs/_doc/3", "", &result)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "deleted", result.Result)
+
+		assert.Equal(t, 4, server.Debouncer.Pending())
+		assert.NoError(t, server.Debouncer.Flush(context.Background()))
+
+		doc := indexer.doc("logs", "1")
+		assert.Equal(t, opensearch.ActionIndex, opensearch.ActionOf(doc))
+		data, err := json.Marshal(doc.Data())
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"message":"hello","count":12345678901234567890}`, string(data))
+		assert.Equal(t, opensearch.ActionCreate, opensearch.ActionOf(indexer.doc("logs", "2")))
+		assert.Equal(t, opensearch.ActionDelete, opensearch.ActionOf(indexer.doc("logs", "3")))
+	})
+
+	t.Run("Version Conflict", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{})
+
+		status := request(t, server, http.MethodPut, "/logs/_doc/1?version=2&version_type=external", `{}`, nil)
+		assert.Equal(t, http.StatusCreated, status)
+
+		var response errorResponse
+		status = request(t, server, http.MethodPut, "/logs/_doc/1?version=1&version_type=external", `{}`, &response)
+		assert.Equal(t, http.StatusConflict, status)
+		assert.Equal(t, typeVersionConflict, response.Error.Type)
+
+		status = request(t, server, http.MethodPut, "/logs/_doc/1?version=3", `{}`, &response)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, typeValidation, response.Error.Type)
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		t.Parallel()
+
+		server, indexer := startServer(t, &Server{})
+		body := strings.Join([]string{
+			`{"index":{"_id":"1"}}`,
+			`{"message":"indexed"}`,
+			`{"create":{"_index":"other"}}`,
+			`{"message":"created"}`,
+			``,
+			`{"update":{"_id":"1"}}`,
+			`{"doc":{"status":"updated"}}`,
+			`{"update":{"_id":"2"}}`,
+			`{"script":{"source":"ctx._source.count++"}}`,
+			`{"delete":{"_id":"3"}}`,
+			`{"index":{"_id":"4"}}`,
+			`{"message":`,
+		}, "\n")
+
+		var response bulkResponse
+		status := request(t, server, http.MethodPost, "/logs/_bulk", body, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.True(t, response.Errors)
+		assert.Len(t, response.Items, 6)
+
+		assert.Equal(t, http.StatusCreated, response.Items[0]["index"].Status)
+		assert.Equal(t, "logs", response.Items[0]["index"].Index)
+		assert.Equal(t, http.StatusCreated, response.Items[1]["create"].Status)
+		assert.Equal(t, "other", response.Items[1]["create"].Index)
+		assert.NotEmpty(t, response.Items[1]["create"].ID)
+		assert.Equal(t, http.StatusOK, response.Items[2]["update"].Status)
+		assert.Equal(t, http.StatusBadRequest, response.Items[3]["update"].Status)
+		assert.Equal(t, typeValidation, response.Items[3]["update"].Error.Type)
+		assert.Equal(t, http.StatusOK, response.Items[4]["delete"].Status)
+		assert.Equal(t, http.StatusBadRequest, response.Items[5]["index"].Status)
+		assert.Equal(t, typeMapperParsing, response.Items[5]["index"].Error.Type)
+
+		assert.NoError(t, server.Debouncer.Flush(context.Background()))
+		assert.Equal(t, 4, indexer.written())
+		data, err := json.Marshal(indexer.doc("logs", "1").Data())
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"status":"updated"}`, string(data))
+	})
+
+	t.Run("Bulk Without Index", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{})
+		body := `{"index":{"_index":"logs","_id":"1"}}` + "\n{}\n" + `{"index":{"_id":"2"}}` + "\n{}\n"
+
+		var response bulkResponse
+		status := request(t, server, http.MethodPost, "/_bulk", body, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, http.StatusCreated, response.Items[0]["index"].Status)
+		assert.Equal(t, http.StatusBadRequest, response.Items[1]["index"].Status)
+	})
+
+	t.Run("Malformed Bulk", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{})
+
+		var response errorResponse
+		status := request(t, server, http.MethodPost, "/_bulk", `{"index":{}} {"bogus"}`, &response)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, typeParse, response.Error.Type)
+
+		status = request(t, server, http.MethodPost, "/_bulk", `{"index":{"_index":"logs"}}`, &response)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, typeParse, response.Error.Type)
+		assert.Equal(t, 0, server.Debouncer.Pending())
+	})
+
+	t.Run("Indices And Pauses", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{
+			Indices: []string{"logs-*"},
+			Paused:  func(index string) bool { return index == "logs-paused" },
+		})
+
+		assert.Equal(t, http.StatusCreated, request(t, server, http.MethodPut, "/logs-app/_doc/1", `{}`, nil))
+		assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodPut, "/other/_doc/1", `{}`, nil))
+		assert.Equal(t, http.StatusTooManyRequests, request(t, server, http.MethodPut, "/logs-paused/_doc/1", `{}`, nil))
+	})
+
+	t.Run("Admission", func(t *testing.T) {
+		t.Parallel()
+
+		server, indexer := startServer(t, &Server{
+			Idempotency: idempotency.New(10, time.Minute),
+			Mappings:    mapping.NewTracker(),
+			Keys:        mapping.NewGuard(mapping.Limits{MaxKeyLength: 8}, mapping.KeyFlatten),
+		})
+
+		assert.Equal(t, http.StatusCreated, request(t, server, http.MethodPut, "/logs/_create/1", `{"level":"info"}`, nil))
+		var response errorResponse
+		assert.Equal(t, http.StatusConflict, request(t, server, http.MethodPut, "/logs/_create/1", `{"level":"info"}`, &response))
+		assert.Equal(t, typeVersionConflict, response.Error.Type)
+
+		assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPut, "/logs/_doc/2", `{"level":true}`, &response))
+		assert.Equal(t, typeMapperParsing, response.Error.Type)
+
+		source := `{"user":{"id":"4711","session":"a1"}}`
+		assert.Equal(t, http.StatusCreated, request(t, server, http.MethodPut, "/logs/_doc/3", source, nil))
+		assert.NoError(t, server.Debouncer.Flush(context.Background()))
+		data, err := json.Marshal(indexer.doc("logs", "3").Data())
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"user":{"id":"4711"},"overflow":{"user.session":"a1"}}`, string(data))
+	})
+
+	t.Run("Queue Full", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{}, debounce.WithQueueLimits(1, 0, debounce.PolicyReject))
+
+		var response bulkResponse
+		status := request(t, server, http.MethodPost, "/logs/_bulk", "{\"index\":{}}\n{}\n{\"index\":{}}\n{}\n", &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, http.StatusCreated, response.Items[0]["index"].Status)
+		assert.Equal(t, http.StatusTooManyRequests, response.Items[1]["index"].Status)
+		assert.Equal(t, typeRejected, response.Items[1]["index"].Error.Type)
+	})
+
+	t.Run("Body Limit And Compression", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := startServer(t, &Server{MaxBodyBytes: 64})
+
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		_, _ = writer.Write([]byte(`{"message":"compressed"}`))
+		assert.NoError(t, writer.Close())
+
+		httpRequest, err := http.NewRequest(http.MethodPut, server.url+"/logs/_doc/1", &compressed)
+		assert.NoError(t, err)
+		httpRequest.Header.Set("Content-Encoding", "gzip")
+		response, err := http.DefaultClient.Do(httpRequest)
+		assert.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+		large := `{"message":"` + strings.Repeat("x", 100) + `"}`
+		var errResponse errorResponse
+		assert.Equal(t, http.StatusRequestEntityTooLarge, request(t, server, http.MethodPut, "/logs/_doc/2", large, &errResponse))
+		assert.Equal(t, typeContentTooLong, errResponse.Error.Type)
+	})
+}
+
+type testServer struct {
+	*Server
+	url string
+}
+
+func startServer(t *testing.T, server *Server, options ...debounce.Option) (testServer, *testingIndexer) {
+	t.Helper()
+
+	indexer := &testingIndexer{docs: map[string]opensearch.Document{}}
+	server.Debouncer = debounce.New(indexer, options...)
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return testServer{Server: server, url: httpServer.URL}, indexer
+}
+
+// request sends the body and decodes the response into result unless it is nil.
+func request(t *testing.T, server testServer, method, path, body string, result interface{}) int {
+	t.Helper()
+
+	httpRequest, err := http.NewRequest(method, server.url+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(httpRequest)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	defer response.Body.Close()
+
+	if result != nil {
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(result))
+	}
+	return response.StatusCode
+}
+
+type testingIndexer struct {
+	mu    sync.Mutex
+	docs  map[string]opensearch.Document
+	count int
+}
+
+func (t *testingIndexer) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, doc := range docs {
+		t.docs[doc.Index()+"/"+doc.ID()] = doc
+	}
+	t.count += len(docs)
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
+
+func (t *testingIndexer) doc(index, id string) opensearch.Document {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.docs[index+"/"+id]
+}
+
+func (t *testingIndexer) written() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
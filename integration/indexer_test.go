@@ -40,7 +40,7 @@ var _ = Describe("opensearch", Ordered, func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(res.IsError()).To(BeTrue())
 
-		osClient := opensearch.Client{osClient}
+		osClient := opensearch.Client{Client: osClient}
 		err = opensearch.EnsureIndexTemplate(ctx, osClient, helper.TestStream{
 			StreamName: "test",
 		})
@@ -14,10 +14,14 @@ var rootCmd = &cobra.Command{}
 
 func main() {
 	rootCmd.AddCommand(cmd.ServeCmd())
-	fmt.Println("starting")
+	rootCmd.AddCommand(cmd.TemplateCmd())
+	rootCmd.AddCommand(cmd.SendCmd())
+	rootCmd.AddCommand(cmd.LoadgenCmd())
+	// stdout is reserved for the output of commands, e.g. template show.
+	fmt.Fprintln(os.Stderr, "starting")
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Print(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,134 @@
+// Package admission validates writes before their documents are passed to the Debouncer. Every API of the
+// bouncer admits writes through a Pipeline, so that pauses, key limits, type conflicts and retries are
+// handled and counted the same way regardless of the protocol.
+package admission
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/idempotency"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+var (
+	// ErrPaused is returned when writes to the stream are paused.
+	ErrPaused = errors.New("stream is paused")
+	// ErrDuplicate is returned for a retry of a recently accepted write.
+	ErrDuplicate = errors.New("write was accepted already")
+)
+
+// Pipeline admits writes. Besides the Debouncer every field is optional.
+type Pipeline struct {
+	// Debouncer collects the documents of admitted writes.
+	Debouncer *debounce.Debouncer
+
+	// Idempotency answers retries of recently accepted writes with ErrDuplicate.
+	Idempotency *idempotency.Cache
+
+	// Metrics counts received, accepted and rejected writes per stream.
+	Metrics *metrics.Metrics
+
+	// Paused reports whether writes to the stream are rejected right now.
+	Paused func(stream string) bool
+
+	// Mappings rejects writes whose fields conflict with the types they were seen with before.
+	Mappings *mapping.Tracker
+
+	// Keys limits the distinct keys, key length and key characters of the stream.
+	Keys *mapping.Guard
+}
+
+// Write is a request to add documents to a stream.
+type Write struct {
+	Stream string
+
+	// Client identifies the sender to the key Guard.
+	Client string
+
+	// IdempotencyKey identifies retries of the write. Retries aren't detected when it is empty.
+	IdempotencyKey string
+
+	// Keys are the keys of the write which are limited by the key Guard.
+	Keys []string
+
+	// Documents returns the documents of the write and the fields whose types are tracked.
+	// exceeded are the positions of the Keys which exceed the limits and have to be left out.
+	Documents func(exceeded []int) ([]opensearch.Document, []mapping.Field)
+}
+
+// Admit passes the documents of the write to the Debouncer unless it is rejected. Besides ErrPaused and
+// ErrDuplicate the returned error wraps mapping.ErrKeyLimit, mapping.ErrTypeConflict or
+// an error of debounce.Debouncer.Add.
+func (p Pipeline) Admit(ctx context.Context, write Write) error {
+	p.Metrics.Received(write.Stream)
+
+	err := p.admit(ctx, write)
+	if err == nil {
+		p.Metrics.Accepted(write.Stream)
+		return nil
+	}
+
+	rejected := reason(err)
+	if rejected == metrics.ReasonInternal {
+		logr.FromContextOrDiscard(ctx).Error(err, "adding documents failed", "stream", write.Stream)
+	} else {
+		logr.FromContextOrDiscard(ctx).V(1).Info("rejected write", "stream", write.Stream, "reason", err.Error())
+	}
+	p.Metrics.Rejected(write.Stream, rejected)
+	return err
+}
+
+func (p Pipeline) admit(ctx context.Context, write Write) error {
+	if p.Paused != nil && p.Paused(write.Stream) {
+		return ErrPaused
+	}
+
+	exceeded, err := p.Keys.Check(write.Stream, write.Client, write.Keys)
+	if err != nil {
+		return err
+	}
+	docs, fields := write.Documents(exceeded)
+	if err := p.Mappings.Observe(write.Stream, fields); err != nil {
+		return err
+	}
+
+	idempotent := p.Idempotency != nil && write.IdempotencyKey != ""
+	if idempotent && !p.Idempotency.Accept(write.IdempotencyKey) {
+		return ErrDuplicate
+	}
+
+	// the documents are added together, so that a rejected write doesn't leave some of them queued.
+	err = p.Debouncer.Add(ctx, docs...)
+	// retries of duplicate and stale versions are answered the same way, other writes weren't accepted,
+	// so a retry mustn't be answered as duplicate.
+	if err != nil && idempotent && !errors.Is(err, debounce.ErrDuplicateVersion) && !errors.Is(err, debounce.ErrStaleVersion) {
+		p.Idempotency.Forget(write.IdempotencyKey)
+	}
+	return err
+}
+
+// reason returns the reason an error of admit is counted with.
+func reason(err error) string {
+	switch {
+	case errors.Is(err, ErrPaused):
+		return metrics.ReasonPaused
+	case errors.Is(err, mapping.ErrKeyLimit):
+		return metrics.ReasonKeyLimit
+	case errors.Is(err, mapping.ErrTypeConflict):
+		return metrics.ReasonTypeConflict
+	case errors.Is(err, ErrDuplicate), errors.Is(err, debounce.ErrDuplicateVersion):
+		return metrics.ReasonDuplicate
+	case errors.Is(err, debounce.ErrStaleVersion):
+		return metrics.ReasonStale
+	case errors.Is(err, debounce.ErrRetryLater):
+		return metrics.ReasonRetryLater
+	case errors.Is(err, debounce.ErrQueueFull):
+		return metrics.ReasonQueueFull
+	}
+	return metrics.ReasonInternal
+}
@@ -0,0 +1,123 @@
+package admission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/idempotency"
+	"github.com/kstiehl/index-bouncer/pkg/mapping"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+
+	write := func(id string, version int64, keys ...string) Write {
+		return Write{
+			Stream:         "events",
+			Client:         "billing",
+			IdempotencyKey: id,
+			Keys:           keys,
+			Documents: func(exceeded []int) ([]opensearch.Document, []mapping.Field) {
+				var fields []mapping.Field
+				for i, key := range keys {
+					if len(exceeded) > 0 && exceeded[0] == i {
+						exceeded = exceeded[1:]
+						continue
+					}
+					fields = append(fields, mapping.Field{Key: key, Type: mapping.TypeString})
+				}
+				return []opensearch.Document{testingDoc{id: "object", version: version}}, fields
+			},
+		}
+	}
+
+	t.Run("Duplicates", func(t *testing.T) {
+		t.Parallel()
+
+		pipeline := Pipeline{Debouncer: debounce.New(&testingIndexer{}), Idempotency: idempotency.New(10, time.Minute)}
+
+		assert.NoError(t, pipeline.Admit(context.Background(), write("1", 2)))
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), write("1", 2)), ErrDuplicate)
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), write("2", 1)), debounce.ErrStaleVersion)
+		// stale writes are remembered like accepted ones.
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), write("2", 1)), ErrDuplicate)
+		assert.Equal(t, 1, pipeline.Debouncer.Pending())
+	})
+
+	t.Run("Rejected Writes Are Retried", func(t *testing.T) {
+		t.Parallel()
+
+		paused := true
+		pipeline := Pipeline{
+			Debouncer:   debounce.New(&testingIndexer{}, debounce.WithQueueLimits(1, 0, debounce.PolicyReject)),
+			Idempotency: idempotency.New(10, time.Minute),
+			Paused:      func(string) bool { return paused },
+		}
+
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), write("1", 1)), ErrPaused)
+		paused = false
+		assert.NoError(t, pipeline.Admit(context.Background(), write("1", 1)))
+
+		other := write("2", 1)
+		other.Documents = func([]int) ([]opensearch.Document, []mapping.Field) {
+			return []opensearch.Document{testingDoc{id: "other"}}, nil
+		}
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), other), debounce.ErrQueueFull)
+		assert.NoError(t, pipeline.Debouncer.Flush(context.Background()))
+		assert.NoError(t, pipeline.Admit(context.Background(), other))
+	})
+
+	t.Run("Keys And Types", func(t *testing.T) {
+		t.Parallel()
+
+		pipeline := Pipeline{
+			Debouncer: debounce.New(&testingIndexer{}),
+			Mappings:  mapping.NewTracker(),
+			Keys:      mapping.NewGuard(mapping.Limits{MaxKeyLength: 8}, mapping.KeyDrop),
+		}
+
+		assert.NoError(t, pipeline.Admit(context.Background(), write("1", 0, "level", "user-4711")))
+		assert.Equal(t, map[string]mapping.Type{"level": mapping.TypeString}, pipeline.Mappings.Fields("events"))
+
+		conflicting := write("2", 0, "level.x")
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), conflicting), mapping.ErrTypeConflict)
+
+		pipeline.Keys = mapping.NewGuard(mapping.Limits{MaxKeyLength: 8}, mapping.KeyReject)
+		assert.ErrorIs(t, pipeline.Admit(context.Background(), write("3", 0, "user-4711")), mapping.ErrKeyLimit)
+	})
+}
+
+type testingDoc struct {
+	id      string
+	version int64
+}
+
+func (t testingDoc) ID() string {
+	return t.id
+}
+
+func (t testingDoc) Index() string {
+	return "events"
+}
+
+func (t testingDoc) Data() interface{} {
+	return map[string]interface{}{}
+}
+
+func (t testingDoc) Action() opensearch.Action {
+	return opensearch.ActionIndex
+}
+
+func (t testingDoc) Version() int64 {
+	return t.version
+}
+
+type testingIndexer struct{}
+
+func (testingIndexer) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
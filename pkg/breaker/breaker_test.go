@@ -0,0 +1,68 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	newBreaker := func() (*Breaker, *time.Time) {
+		now := time.Now()
+		breaker := New(2, time.Minute)
+		breaker.now = func() time.Time { return now }
+		return breaker, &now
+	}
+
+	t.Run("Opens After Threshold", func(t *testing.T) {
+		t.Parallel()
+
+		breaker, _ := newBreaker()
+
+		assert.True(t, breaker.Allow())
+		breaker.Failure()
+		assert.Equal(t, Closed, breaker.State())
+
+		assert.True(t, breaker.Allow())
+		breaker.Failure()
+		assert.Equal(t, Open, breaker.State())
+		assert.False(t, breaker.Allow())
+	})
+
+	t.Run("Success Resets Failures", func(t *testing.T) {
+		t.Parallel()
+
+		breaker, _ := newBreaker()
+
+		breaker.Failure()
+		breaker.Success()
+		breaker.Failure()
+		assert.Equal(t, Closed, breaker.State())
+	})
+
+	t.Run("Half Open Probe", func(t *testing.T) {
+		t.Parallel()
+
+		breaker, now := newBreaker()
+		breaker.Failure()
+		breaker.Failure()
+
+		*now = now.Add(time.Minute)
+		assert.True(t, breaker.Allow())
+		assert.Equal(t, HalfOpen, breaker.State())
+		assert.False(t, breaker.Allow(), "only a single probe is allowed")
+
+		breaker.Failure()
+		assert.Equal(t, Open, breaker.State())
+		assert.False(t, breaker.Allow())
+
+		*now = now.Add(time.Minute)
+		assert.True(t, breaker.Allow())
+		breaker.Success()
+		assert.Equal(t, Closed, breaker.State())
+		assert.True(t, breaker.Allow())
+	})
+}
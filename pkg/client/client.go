@@ -0,0 +1,250 @@
+// Package client sends events to the bouncer. Events are batched onto IndexBatch calls, which are
+// distributed over a pool of connections, and retried while the bouncer asks for it.
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClosed is returned for events which are indexed after the Client was closed.
+var ErrClosed = errors.New("client is closed")
+
+type request struct {
+	event  *types.Event
+	future *Future
+}
+
+// Client batches indexed events. It is safe for concurrent use.
+type Client struct {
+	options Options
+	conns   []grpc.ClientConnInterface
+	// owned are the dialed connections, which are closed by Close.
+	owned []*grpc.ClientConn
+	next  uint32
+
+	mu      sync.RWMutex
+	closed  bool
+	pending chan *request
+
+	inFlight chan struct{}
+	batches  sync.WaitGroup
+	stopped  chan struct{}
+}
+
+// New dials the connections of the pool to the address and starts batching.
+func New(ctx context.Context, address string, options ...Option) (*Client, error) {
+	o := Options{}
+	o.InitWithDefaults()
+	o.ApplyOptions(options)
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		options:  o,
+		conns:    o.Connections,
+		pending:  make(chan *request, o.QueueSize),
+		inFlight: make(chan struct{}, o.MaxInFlight),
+		stopped:  make(chan struct{}),
+	}
+	if len(c.conns) == 0 {
+		for i := 0; i < o.PoolSize; i++ {
+			conn, err := grpc.DialContext(ctx, address, o.DialOptions...)
+			if err != nil {
+				_ = c.closeConns()
+				return nil, err
+			}
+			c.owned = append(c.owned, conn)
+			c.conns = append(c.conns, conn)
+		}
+	}
+
+	go c.batch()
+	return c, nil
+}
+
+// Index queues the event for the next batch. It only blocks while the queue is full,
+// ctx isn't used for sending the event.
+func (c *Client) Index(ctx context.Context, event *types.Event) *Future {
+	future := newFuture()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		future.resolve(0, ErrClosed)
+		return future
+	}
+
+	select {
+	case c.pending <- &request{event: event, future: future}:
+	case <-ctx.Done():
+		future.resolve(0, ctx.Err())
+	}
+	return future
+}
+
+// Close sends the queued events, waits until every event has its outcome and closes the dialed connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.pending)
+	}
+	c.mu.Unlock()
+
+	<-c.stopped
+	return c.closeConns()
+}
+
+func (c *Client) closeConns() error {
+	var closeErr error
+	for _, conn := range c.owned {
+		if err := conn.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	c.owned = nil
+	return closeErr
+}
+
+// batch collects the queued events into batches until the queue is closed.
+func (c *Client) batch() {
+	defer close(c.stopped)
+
+	var (
+		batch  []*request
+		linger <-chan time.Time
+	)
+	send := func() {
+		if len(batch) > 0 {
+			c.send(batch)
+		}
+		batch, linger = nil, nil
+	}
+	for {
+		select {
+		case r, ok := <-c.pending:
+			if !ok {
+				send()
+				c.batches.Wait()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) == 1 {
+				linger = time.After(c.options.Linger)
+			}
+			if len(batch) >= c.options.BatchSize {
+				send()
+			}
+		case <-linger:
+			send()
+		}
+	}
+}
+
+// send delivers the batch in the background. It blocks while MaxInFlight batches are sent.
+func (c *Client) send(batch []*request) {
+	c.inFlight <- struct{}{}
+	c.batches.Add(1)
+	go func() {
+		defer c.batches.Done()
+		defer func() { <-c.inFlight }()
+		c.deliver(batch)
+	}()
+}
+
+// deliver sends the batch and retries the events which may be retried until MaxRetries is reached.
+// A retry of an event which was accepted, but whose response got lost, is answered as DUPLICATE.
+func (c *Client) deliver(batch []*request) {
+	for attempt := 0; len(batch) > 0; attempt++ {
+		last := attempt >= c.options.MaxRetries
+		results, err := c.call(batch)
+		if err != nil {
+			if last || !retryable(status.Code(err)) {
+				for _, r := range batch {
+					r.future.resolve(0, err)
+				}
+				return
+			}
+		} else {
+			retry := batch[:0]
+			for i, r := range batch {
+				code, err := outcome(results[i])
+				if !last && (code == types.StatusCode_RETRY_LATER || retryable(status.Code(err))) {
+					retry = append(retry, r)
+					continue
+				}
+				r.future.resolve(code, err)
+			}
+			batch = retry
+		}
+		if len(batch) > 0 {
+			time.Sleep(c.backoff(attempt))
+		}
+	}
+}
+
+// call sends the batch on the next connection of the pool.
+func (c *Client) call(batch []*request) ([]*types.IndexResult, error) {
+	events := make([]*types.Event, 0, len(batch))
+	for _, r := range batch {
+		events = append(events, r.event)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.CallTimeout)
+	defer cancel()
+	if c.options.ClientID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "client-id", c.options.ClientID)
+	}
+
+	conn := c.conns[int(atomic.AddUint32(&c.next, 1)%uint32(len(c.conns)))]
+	response, err := types.NewStreamingServiceClient(conn).IndexBatch(ctx, &types.EventBatch{Events: events})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.GetResults()) != len(events) {
+		return nil, status.Errorf(codes.Internal, "received %d results for %d events", len(response.GetResults()), len(events))
+	}
+	return response.GetResults(), nil
+}
+
+// backoff returns the wait before the retry after attempt, which is jittered to spread the retries of batches.
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.options.Backoff
+	for i := 0; i < attempt && backoff < c.options.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.options.MaxBackoff {
+		backoff = c.options.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func outcome(result *types.IndexResult) (types.StatusCode, error) {
+	if code := codes.Code(result.GetErrorCode()); code != codes.OK {
+		return 0, status.Error(code, result.GetErrorMessage())
+	}
+	return result.GetCode(), nil
+}
+
+// retryable reports whether a call failing with code may succeed when it is sent again.
+func retryable(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
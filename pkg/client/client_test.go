@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	accept := func(call int, event *types.Event) *types.IndexResult {
+		return &types.IndexResult{Code: types.StatusCode_RECORD_OK}
+	}
+	event := func(id string) *types.Event {
+		return &types.Event{EventID: id, ObjectID: "object"}
+	}
+
+	t.Run("Batches", func(t *testing.T) {
+		t.Parallel()
+
+		server := &testingServer{answer: accept}
+		client := newTestingClient(t, server, WithBatching(3, time.Minute), WithClientID("billing"))
+
+		var futures []*Future
+		for _, id := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+			futures = append(futures, client.Index(context.Background(), event(id)))
+		}
+		// full batches don't wait for the linger, the last one is sent by Close.
+		code, err := futures[5].Wait(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, code)
+
+		assert.NoError(t, client.Close())
+		for _, future := range futures {
+			code, err := future.Wait(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, types.StatusCode_RECORD_OK, code)
+		}
+		assert.ElementsMatch(t, []int{3, 3, 1}, server.batchSizes())
+		assert.Equal(t, []string{"billing", "billing", "billing"}, server.clients())
+
+		code, err = client.Index(context.Background(), event("8")).Wait(context.Background())
+		assert.ErrorIs(t, err, ErrClosed)
+		assert.Equal(t, types.StatusCode(0), code)
+	})
+
+	t.Run("Linger", func(t *testing.T) {
+		t.Parallel()
+
+		server := &testingServer{answer: accept}
+		client := newTestingClient(t, server, WithBatching(100, 10*time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		code, err := client.Index(ctx, event("1")).Wait(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, code)
+		assert.Equal(t, []int{1}, server.batchSizes())
+	})
+
+	t.Run("Retries", func(t *testing.T) {
+		t.Parallel()
+
+		server := &testingServer{
+			// the first call fails as a whole, the second asks to retry the first event.
+			fail: map[int]error{0: status.Error(codes.Unavailable, "connection refused")},
+			answer: func(call int, event *types.Event) *types.IndexResult {
+				switch {
+				case call == 1 && event.GetEventID() == "1":
+					return &types.IndexResult{Code: types.StatusCode_RETRY_LATER}
+				case event.GetEventID() == "2":
+					return &types.IndexResult{ErrorCode: int32(codes.InvalidArgument), ErrorMessage: "type conflict"}
+				}
+				return &types.IndexResult{Code: types.StatusCode_RECORD_OK}
+			},
+		}
+		client := newTestingClient(t, server, WithBatching(2, time.Minute), WithRetries(3, time.Millisecond, time.Millisecond))
+
+		first, second := client.Index(context.Background(), event("1")), client.Index(context.Background(), event("2"))
+		assert.NoError(t, client.Close())
+
+		code, err := first.Wait(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RECORD_OK, code)
+		_, err = second.Wait(context.Background())
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, "type conflict", status.Convert(err).Message())
+		assert.Equal(t, []int{2, 2, 1}, server.batchSizes())
+	})
+
+	t.Run("Retries Exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		server := &testingServer{answer: func(int, *types.Event) *types.IndexResult {
+			return &types.IndexResult{Code: types.StatusCode_RETRY_LATER}
+		}}
+		client := newTestingClient(t, server, WithBatching(1, time.Millisecond), WithRetries(2, 0, 0))
+
+		future := client.Index(context.Background(), event("1"))
+		assert.NoError(t, client.Close())
+		code, err := future.Wait(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, types.StatusCode_RETRY_LATER, code)
+		assert.Len(t, server.batchSizes(), 3)
+	})
+
+	t.Run("Not Retryable", func(t *testing.T) {
+		t.Parallel()
+
+		server := &testingServer{fail: map[int]error{0: status.Error(codes.PermissionDenied, "denied")}, answer: accept}
+		client := newTestingClient(t, server, WithBatching(1, time.Millisecond))
+
+		future := client.Index(context.Background(), event("1"))
+		assert.NoError(t, client.Close())
+		_, err := future.Wait(context.Background())
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Len(t, server.batchSizes(), 1)
+	})
+
+	t.Run("Invalid Options", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := New(context.Background(), "localhost:8080", WithBatching(0, time.Millisecond))
+		assert.Error(t, err)
+	})
+}
+
+// newTestingClient returns a Client of the server on an in-memory connection.
+func newTestingClient(t *testing.T, server *testingServer, options ...Option) *Client {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	types.RegisterStreamingServiceServer(s, server)
+	go func() { _ = s.Serve(listener) }()
+
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+
+	client, err := New(context.Background(), "", append([]Option{WithConnections(conn)}, options...)...)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		conn.Close()
+		s.Stop()
+	})
+	return client
+}
+
+// testingServer answers the events of every call with answer, unless the call fails as a whole.
+type testingServer struct {
+	types.UnimplementedStreamingServiceServer
+
+	fail   map[int]error
+	answer func(call int, event *types.Event) *types.IndexResult
+
+	mu      sync.Mutex
+	batches []int
+	ids     []string
+}
+
+func (s *testingServer) IndexBatch(ctx context.Context, batch *types.EventBatch) (*types.BatchResponse, error) {
+	s.mu.Lock()
+	call := len(s.batches)
+	s.batches = append(s.batches, len(batch.GetEvents()))
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.ids = append(s.ids, md.Get("client-id")...)
+	s.mu.Unlock()
+
+	if err := s.fail[call]; err != nil {
+		return nil, err
+	}
+	response := &types.BatchResponse{}
+	for _, event := range batch.GetEvents() {
+		response.Results = append(response.Results, s.answer(call, event))
+	}
+	return response, nil
+}
+
+func (s *testingServer) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func (s *testingServer) clients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ids...)
+}
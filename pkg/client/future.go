@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+)
+
+// Future is the outcome of an indexed event.
+type Future struct {
+	done chan struct{}
+	code types.StatusCode
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(code types.StatusCode, err error) {
+	f.code, f.err = code, err
+	close(f.done)
+}
+
+// Done is closed once the outcome of the event is known.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the outcome of the event once it is known. Errors are gRPC status errors
+// of the event, or of the last call when the batch failed as a whole.
+func (f *Future) Wait(ctx context.Context) (types.StatusCode, error) {
+	select {
+	case <-f.done:
+		return f.code, f.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// An Option which can be applied to Options.
+type Option = func(options *Options)
+
+// WithPoolSize configures the number of connections the batches are distributed over.
+func WithPoolSize(size int) Option {
+	return func(options *Options) {
+		options.PoolSize = size
+	}
+}
+
+// WithBatching configures how many events are sent within a single call and how long
+// the first event of a batch waits for more events.
+func WithBatching(size int, linger time.Duration) Option {
+	return func(options *Options) {
+		options.BatchSize = size
+		options.Linger = linger
+	}
+}
+
+// WithMaxInFlight configures how many batches are sent at the same time.
+func WithMaxInFlight(batches int) Option {
+	return func(options *Options) {
+		options.MaxInFlight = batches
+	}
+}
+
+// WithQueueSize configures how many events wait for a batch before Index blocks.
+func WithQueueSize(size int) Option {
+	return func(options *Options) {
+		options.QueueSize = size
+	}
+}
+
+// WithRetries configures how often events are retried and the backoff between the attempts,
+// which doubles with every attempt up to maxBackoff.
+func WithRetries(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(options *Options) {
+		options.MaxRetries = retries
+		options.Backoff = backoff
+		options.MaxBackoff = maxBackoff
+	}
+}
+
+// WithCallTimeout configures the time a single call may take.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.CallTimeout = timeout
+	}
+}
+
+// WithClientID configures the client-id metadata of the calls, which identifies the client in key limit reports.
+func WithClientID(id string) Option {
+	return func(options *Options) {
+		options.ClientID = id
+	}
+}
+
+// WithDialOptions replaces the options the connections are dialed with.
+func WithDialOptions(dialOptions ...grpc.DialOption) Option {
+	return func(options *Options) {
+		options.DialOptions = dialOptions
+	}
+}
+
+// WithConnections configures connections which are used instead of dialing the address.
+// They aren't closed by Close.
+func WithConnections(conns ...grpc.ClientConnInterface) Option {
+	return func(options *Options) {
+		options.Connections = conns
+	}
+}
+
+type Options struct {
+	PoolSize int
+
+	// BatchSize is the maximum number of events of a batch. A batch is sent once it is full
+	// or its first event waited for Linger.
+	BatchSize int
+	Linger    time.Duration
+	// MaxInFlight is the number of batches which are sent at the same time.
+	MaxInFlight int
+	// QueueSize is the number of events which wait for a batch.
+	QueueSize int
+
+	// MaxRetries is the number of retries of an event. Backoff is the wait before the first retry,
+	// which doubles with every retry up to MaxBackoff.
+	MaxRetries  int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	CallTimeout time.Duration
+
+	ClientID    string
+	DialOptions []grpc.DialOption
+	Connections []grpc.ClientConnInterface
+}
+
+// InitWithDefaults initialises Options with default values for each setting.
+func (o *Options) InitWithDefaults() {
+	o.PoolSize = 2
+	o.BatchSize = 100
+	o.Linger = 10 * time.Millisecond
+	o.MaxInFlight = 4
+	o.QueueSize = 10000
+	o.MaxRetries = 5
+	o.Backoff = 50 * time.Millisecond
+	o.MaxBackoff = 5 * time.Second
+	o.CallTimeout = 10 * time.Second
+	o.DialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+}
+
+// ApplyOptions iterates over []Option and applies every single one of them.
+func (o *Options) ApplyOptions(options []Option) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+func (o *Options) validate() error {
+	switch {
+	case o.PoolSize < 1 && len(o.Connections) == 0:
+		return fmt.Errorf("pool size %d has to be at least 1", o.PoolSize)
+	case o.BatchSize < 1:
+		return fmt.Errorf("batch size %d has to be at least 1", o.BatchSize)
+	case o.MaxInFlight < 1:
+		return fmt.Errorf("max in flight %d has to be at least 1", o.MaxInFlight)
+	case o.QueueSize < 0 || o.MaxRetries < 0:
+		return fmt.Errorf("queue size and retries can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package debounce
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// An AdaptiveOption which can be applied to AdaptiveOptions.
+type AdaptiveOption = func(options *AdaptiveOptions)
+
+// WithBatchSizeRange configures the range in which the batch size is adjusted.
+func WithBatchSizeRange(min, max int) AdaptiveOption {
+	return func(options *AdaptiveOptions) {
+		options.MinBatchSize = min
+		options.MaxBatchSize = max
+	}
+}
+
+// WithConcurrencyRange configures the range in which the number of concurrent flushes is adjusted.
+func WithConcurrencyRange(min, max int) AdaptiveOption {
+	return func(options *AdaptiveOptions) {
+		options.MinConcurrency = min
+		options.MaxConcurrency = max
+	}
+}
+
+// WithTargetLatency configures the bulk latency above which limits are decreased.
+func WithTargetLatency(latency time.Duration) AdaptiveOption {
+	return func(options *AdaptiveOptions) {
+		options.TargetLatency = latency
+	}
+}
+
+type AdaptiveOptions struct {
+	// MinBatchSize and MaxBatchSize limit the number of documents of a single flush.
+	MinBatchSize int
+	MaxBatchSize int
+
+	// BatchSizeStep is added to the batch size after every flush which was fast and not rejected.
+	BatchSizeStep int
+
+	// MinConcurrency and MaxConcurrency limit the number of flushes which are in flight across all workers.
+	MinConcurrency int
+	MaxConcurrency int
+
+	// TargetLatency is the bulk latency above which a flush counts as a latency spike.
+	TargetLatency time.Duration
+
+	// DecreaseFactor is multiplied with the limits after a rejected or slow flush.
+	DecreaseFactor float64
+}
+
+// InitWithDefaults initialises AdaptiveOptions with default values for each setting.
+func (o *AdaptiveOptions) InitWithDefaults() {
+	o.MinBatchSize = 50
+	o.MaxBatchSize = 5000
+	o.BatchSizeStep = 50
+	o.MinConcurrency = 1
+	o.MaxConcurrency = 8
+	o.TargetLatency = time.Second
+	o.DecreaseFactor = 0.5
+}
+
+// ApplyOptions iterates over []AdaptiveOption and applies every single one of them.
+func (o *AdaptiveOptions) ApplyOptions(options []AdaptiveOption) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// Limits are the currently effective values of an Adaptive.
+type Limits struct {
+	BatchSize   int
+	Concurrency int
+	InFlight    int
+}
+
+// Adaptive adjusts batch size and concurrency from the feedback of opensearch.
+// It increases the batch size additively while bulk requests are fast and not rejected.
+// Once all workers use the maximum batch size the concurrency is increased as well.
+// Both are decreased multiplicatively when opensearch rejects documents or the latency spikes.
+type Adaptive struct {
+	options AdaptiveOptions
+
+	mu          sync.Mutex
+	released    *sync.Cond
+	batchSize   int
+	concurrency int
+	inFlight    int
+}
+
+// NewAdaptive creates an Adaptive which starts with the minimum batch size and concurrency.
+func NewAdaptive(options ...AdaptiveOption) *Adaptive {
+	adaptiveOptions := AdaptiveOptions{}
+	adaptiveOptions.InitWithDefaults()
+	adaptiveOptions.ApplyOptions(options)
+
+	adaptive := &Adaptive{
+		options:     adaptiveOptions,
+		batchSize:   adaptiveOptions.MinBatchSize,
+		concurrency: adaptiveOptions.MinConcurrency,
+	}
+	adaptive.released = sync.NewCond(&adaptive.mu)
+	return adaptive
+}
+
+// Limits returns the currently effective values.
+func (a *Adaptive) Limits() Limits {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Limits{BatchSize: a.batchSize, Concurrency: a.concurrency, InFlight: a.inFlight}
+}
+
+// BatchSize returns the current maximum number of documents of a single flush.
+func (a *Adaptive) BatchSize() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.batchSize
+}
+
+// Observe adjusts the limits with the outcome of a flush. rejected reports whether
+// documents were rejected or the whole flush failed.
+func (a *Adaptive) Observe(latency time.Duration, rejected bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rejected || latency > a.options.TargetLatency {
+		a.batchSize = decrease(a.batchSize, a.options.DecreaseFactor, a.options.MinBatchSize)
+		a.concurrency = decrease(a.concurrency, a.options.DecreaseFactor, a.options.MinConcurrency)
+		return
+	}
+
+	if a.batchSize < a.options.MaxBatchSize {
+		a.batchSize += a.options.BatchSizeStep
+		if a.batchSize > a.options.MaxBatchSize {
+			a.batchSize = a.options.MaxBatchSize
+		}
+		return
+	}
+
+	if a.concurrency < a.options.MaxConcurrency {
+		a.concurrency++
+		a.released.Broadcast()
+	}
+}
+
+// decrease multiplies the value with the factor but decreases it by at least one.
+func decrease(value int, factor float64, min int) int {
+	decreased := int(float64(value) * factor)
+	if decreased >= value {
+		decreased = value - 1
+	}
+	if decreased < min {
+		return min
+	}
+	return decreased
+}
+
+// Acquire blocks until a flush may be sent without exceeding the current concurrency.
+func (a *Adaptive) Acquire(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stop := wakeOnDone(ctx, a.released)
+	defer stop()
+	for a.inFlight >= a.concurrency {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		a.released.Wait()
+	}
+	a.inFlight++
+	return nil
+}
+
+// Release marks a flush which was started with Acquire as done.
+func (a *Adaptive) Release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	a.released.Broadcast()
+}
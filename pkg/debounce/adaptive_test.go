@@ -0,0 +1,109 @@
+package debounce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptive(t *testing.T) {
+	t.Parallel()
+
+	newAdaptive := func() *Adaptive {
+		return NewAdaptive(
+			WithBatchSizeRange(10, 30),
+			WithConcurrencyRange(1, 3),
+			WithTargetLatency(time.Second),
+		)
+	}
+
+	t.Run("Additive Increase", func(t *testing.T) {
+		t.Parallel()
+
+		adaptive := newAdaptive()
+		assert.Equal(t, Limits{BatchSize: 10, Concurrency: 1}, adaptive.Limits())
+
+		adaptive.options.BatchSizeStep = 15
+		adaptive.Observe(time.Millisecond, false)
+		assert.Equal(t, Limits{BatchSize: 25, Concurrency: 1}, adaptive.Limits())
+
+		// concurrency is only increased once the batch size reached its maximum.
+		adaptive.Observe(time.Millisecond, false)
+		assert.Equal(t, Limits{BatchSize: 30, Concurrency: 1}, adaptive.Limits())
+		adaptive.Observe(time.Millisecond, false)
+		adaptive.Observe(time.Millisecond, false)
+		adaptive.Observe(time.Millisecond, false)
+		assert.Equal(t, Limits{BatchSize: 30, Concurrency: 3}, adaptive.Limits())
+	})
+
+	t.Run("Multiplicative Decrease", func(t *testing.T) {
+		t.Parallel()
+
+		adaptive := newAdaptive()
+		adaptive.batchSize = 30
+		adaptive.concurrency = 3
+
+		adaptive.Observe(time.Millisecond, true)
+		assert.Equal(t, Limits{BatchSize: 15, Concurrency: 1}, adaptive.Limits())
+
+		adaptive.Observe(2*time.Second, false)
+		assert.Equal(t, Limits{BatchSize: 10, Concurrency: 1}, adaptive.Limits())
+	})
+
+	t.Run("Acquire Respects Concurrency", func(t *testing.T) {
+		t.Parallel()
+
+		adaptive := newAdaptive()
+		assert.NoError(t, adaptive.Acquire(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, adaptive.Acquire(ctx), context.DeadlineExceeded)
+
+		adaptive.Release()
+		assert.NoError(t, adaptive.Acquire(context.Background()))
+		assert.Equal(t, 1, adaptive.Limits().InFlight)
+	})
+
+	t.Run("Debouncer Flushes Batches", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithAdaptive(newAdaptive()))
+
+		for i := 0; i < 25; i++ {
+			assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: string(rune('a' + i))}))
+		}
+		assert.NoError(t, debouncer.Flush(context.Background()))
+
+		// the batch size grows by the default step after every fast flush.
+		assert.Len(t, indexer.bulks, 2)
+		assert.Len(t, indexer.bulks[0], 10)
+		assert.Len(t, indexer.bulks[1], 15)
+
+		limits, ok := debouncer.Limits()
+		assert.True(t, ok)
+		assert.Equal(t, 30, limits.BatchSize)
+		assert.Len(t, indexer.flushed(), 25)
+	})
+	t.Run("Debouncer Backs Off On Errors", func(t *testing.T) {
+		t.Parallel()
+
+		adaptive := newAdaptive()
+		adaptive.batchSize = 30
+		adaptive.concurrency = 3
+		indexer := &testingIndexer{err: errors.New("503 Service Unavailable")}
+		debouncer := New(indexer, WithAdaptive(adaptive))
+
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1"}))
+		assert.Error(t, debouncer.Flush(context.Background()))
+
+		limits, ok := debouncer.Limits()
+		assert.True(t, ok)
+		assert.Equal(t, 15, limits.BatchSize)
+		assert.Equal(t, 1, limits.Concurrency)
+	})
+}
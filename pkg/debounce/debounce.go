@@ -0,0 +1,623 @@
+package debounce
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/kstiehl/index-bouncer/pkg/sink"
+	"github.com/kstiehl/index-bouncer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrDuplicateVersion = errors.New("the same version of the document is already pending")
+	ErrStaleVersion     = errors.New("a newer version of the document is already pending")
+	ErrRetryLater       = errors.New("documents can't be accepted right now")
+)
+
+// Indexer is the Sink to which pending documents are flushed.
+type Indexer = sink.Sink
+
+// Admitter can be implemented by an Indexer which temporarily can't accept more documents.
+type Admitter interface {
+	// Admit should return an error wrapping ErrRetryLater while no documents can be accepted.
+	Admit() error
+}
+
+// PartitionedDocument can be implemented by a Document to choose the worker which writes it.
+// Documents with the same partition key are always written in the order they were added.
+type PartitionedDocument interface {
+	opensearch.Document
+
+	// PartitionKey should return the key which is hashed to choose the worker, e.g. the object ID.
+	PartitionKey() string
+}
+
+// An Option which can be applied to Options.
+type Option = func(options *Options)
+
+// WithFlushInterval configures how often pending documents are flushed by Run.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(options *Options) {
+		options.FlushInterval = interval
+	}
+}
+
+// WithMaxPending configures after how many pending documents of a worker Run flushes early.
+func WithMaxPending(maxPending int) Option {
+	return func(options *Options) {
+		options.MaxPending = maxPending
+	}
+}
+
+// WithWorkers configures the number of workers which flush in parallel.
+func WithWorkers(workers int) Option {
+	return func(options *Options) {
+		options.Workers = workers
+	}
+}
+
+// WithAdaptive configures an Adaptive which adjusts batch size and concurrency of the flushes.
+func WithAdaptive(adaptive *Adaptive) Option {
+	return func(options *Options) {
+		options.Adaptive = adaptive
+	}
+}
+
+// WithQueueLimits configures how many documents and bytes may be pending in total
+// and what happens to documents which exceed these limits.
+func WithQueueLimits(maxDocuments int, maxBytes int64, policy FullPolicy) Option {
+	return func(options *Options) {
+		options.MaxQueueDocuments = maxDocuments
+		options.MaxQueueBytes = maxBytes
+		options.FullPolicy = policy
+	}
+}
+
+// WithMaxHeapBytes configures the heap size above which documents are rejected.
+func WithMaxHeapBytes(maxHeap uint64) Option {
+	return func(options *Options) {
+		options.MaxHeapBytes = maxHeap
+	}
+}
+
+// WithMetrics configures the Metrics which count the documents that failed to be written.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(options *Options) {
+		options.Metrics = m
+	}
+}
+
+// WithTracerProvider configures the provider which records a span per flush.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(options *Options) {
+		options.TracerProvider = provider
+	}
+}
+
+type Options struct {
+	// FlushInterval is the maximum time a document stays pending when Run is used.
+	FlushInterval time.Duration
+
+	// MaxPending is the number of pending documents of a worker which trigger a flush
+	// before FlushInterval elapsed.
+	MaxPending int
+
+	// Workers is the number of partitions which are flushed in parallel. Every worker
+	// has at most one flush in flight so that documents of a partition stay in order.
+	Workers int
+
+	// Adaptive adjusts the batch size and the flushes in flight across all workers.
+	// When set the batch size replaces MaxPending. It is disabled when nil.
+	Adaptive *Adaptive
+
+	// MaxQueueDocuments and MaxQueueBytes limit the documents which are pending or
+	// in flight across all workers. 0 disables a limit.
+	MaxQueueDocuments int
+	MaxQueueBytes     int64
+
+	// FullPolicy decides what happens to documents which exceed the queue limits.
+	FullPolicy FullPolicy
+
+	// MaxHeapBytes is the heap size of the process above which documents are rejected
+	// with ErrMemoryLimit regardless of the FullPolicy. 0 disables the limit.
+	MaxHeapBytes uint64
+
+	// TracerProvider records a span per flush. Tracing is disabled when nil.
+	TracerProvider trace.TracerProvider
+
+	// Metrics counts the documents which failed to be written and are dropped. It is optional.
+	Metrics *metrics.Metrics
+}
+
+// InitWithDefaults initialises Options with default values for each setting.
+func (o *Options) InitWithDefaults() {
+	o.FlushInterval = time.Second
+	o.MaxPending = 1000
+	o.Workers = 1
+	o.Adaptive = nil
+	o.MaxQueueDocuments = 100000
+	o.MaxQueueBytes = 256 << 20
+	o.FullPolicy = PolicyBlock
+	o.MaxHeapBytes = 0
+	o.TracerProvider = nil
+	o.Metrics = nil
+}
+
+// ApplyOptions iterates over []Option and applies every single one of them.
+func (o *Options) ApplyOptions(options []Option) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// Stats are counters about the documents which passed the Debouncer.
+type Stats struct {
+	// Written is the number of documents which were written by the Indexer.
+	Written int
+
+	// Duplicate is the number of documents which were deduplicated. These are versioned
+	// documents of which the same version was already pending and created documents
+	// which already existed.
+	Duplicate int
+
+	// Stale is the number of versioned documents which were dropped since a newer
+	// version was already pending or already written.
+	Stale int
+
+	// Spilled is the number of documents the Indexer put aside to write them later.
+	Spilled int
+
+	// Dropped is the number of pending documents which were dropped to make room for newer ones.
+	Dropped int
+
+	// Failed is the number of documents the Indexer couldn't write.
+	Failed int
+}
+
+// Debouncer collects documents and writes them in bulk to an Indexer.
+// Writes to the same document which are still pending are coalesced:
+// a delete or an index supersedes every earlier pending write for the same ID,
+// consecutive updates are merged into one and creates are kept in order since
+// they must fail when the document exists.
+//
+// Documents are hash partitioned by their partition key onto workers which flush
+// independently of each other.
+type Debouncer struct {
+	indexer    Indexer
+	options    Options
+	partitions []*partition
+	limits     *limits
+
+	statsMu    sync.Mutex
+	stats      Stats
+	lastErrors []FlushError
+}
+
+// maxLastErrors is the number of flush errors the Debouncer remembers.
+const maxLastErrors = 10
+
+// FlushError is an error which occurred while flushing.
+type FlushError struct {
+	Time  time.Time
+	Error string
+}
+
+// New creates a Debouncer which flushes to the given Indexer.
+func New(indexer Indexer, options ...Option) *Debouncer {
+	debounceOptions := Options{}
+	debounceOptions.InitWithDefaults()
+	debounceOptions.ApplyOptions(options)
+	if debounceOptions.Workers < 1 {
+		debounceOptions.Workers = 1
+	}
+
+	partitions := make([]*partition, debounceOptions.Workers)
+	for i := range partitions {
+		partitions[i] = newPartition()
+	}
+
+	return &Debouncer{
+		indexer:    indexer,
+		options:    debounceOptions,
+		partitions: partitions,
+		limits:     newLimits(debounceOptions),
+	}
+}
+
+// Add queues documents until the next flush. The documents are added together, so none of them
+// is queued when one is rejected.
+// Versioned documents are dropped with ErrDuplicateVersion or ErrStaleVersion
+// when the same or a newer version of the document is already pending.
+// ErrRetryLater is returned while the Indexer can't accept documents and
+// ErrQueueFull when the documents exceed the queue limits. Depending on the FullPolicy
+// Add blocks until the documents fit or the context is done.
+func (d *Debouncer) Add(ctx context.Context, docs ...opensearch.Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	if admitter, ok := d.indexer.(Admitter); ok {
+		if err := admitter.Admit(); err != nil {
+			return err
+		}
+	}
+
+	partitions := make([]int, len(docs))
+	sizes := make([]int64, len(docs))
+	reserved, size := 0, int64(0)
+	for i, doc := range docs {
+		partitions[i] = d.partitionOf(doc)
+		sizes[i] = estimateSize(doc)
+		size += sizes[i]
+		if d.partitions[partitions[i]].grows(doc) {
+			reserved++
+		}
+	}
+
+	preferred := d.partitions[partitions[0]]
+	dropped, err := d.limits.reserve(ctx, reserved, size, func() (int, int64) { return d.dropOldest(preferred) })
+	if dropped > 0 {
+		d.updateStats(func(stats *Stats) { stats.Dropped += dropped })
+	}
+	if err != nil {
+		return err
+	}
+
+	added, bytes, err := d.addAll(partitions, docs, sizes)
+	if err != nil {
+		d.limits.release(reserved, size)
+	} else {
+		d.limits.adjust(added-reserved, bytes-size)
+	}
+
+	switch {
+	case errors.Is(err, ErrDuplicateVersion):
+		d.updateStats(func(stats *Stats) { stats.Duplicate++ })
+	case errors.Is(err, ErrStaleVersion):
+		d.updateStats(func(stats *Stats) { stats.Stale++ })
+	case err == nil && d.maxPending() > 0:
+		for _, i := range partitions {
+			if p := d.partitions[i]; p.pending() >= d.maxPending() {
+				p.signalFull()
+			}
+		}
+	}
+	return err
+}
+
+// addAll adds the documents to the partitions with the given indices. The versions of all documents
+// are checked before any is added, while every involved partition is locked.
+func (d *Debouncer) addAll(partitions []int, docs []opensearch.Document, sizes []int64) (int, int64, error) {
+	locked := make([]int, 0, len(partitions))
+	for _, i := range partitions {
+		if !containsInt(locked, i) {
+			locked = append(locked, i)
+		}
+	}
+	// partitions are always locked in the same order, so that concurrent calls can't deadlock.
+	sort.Ints(locked)
+	for _, i := range locked {
+		d.partitions[i].mu.Lock()
+	}
+	defer func() {
+		for _, i := range locked {
+			d.partitions[i].mu.Unlock()
+		}
+	}()
+
+	for i, doc := range docs {
+		if err := d.partitions[partitions[i]].checkLocked(doc); err != nil {
+			return 0, 0, err
+		}
+	}
+	added, bytes := 0, int64(0)
+	for i, doc := range docs {
+		docsDelta, bytesDelta := d.partitions[partitions[i]].addLocked(doc, sizes[i])
+		added += docsDelta
+		bytes += bytesDelta
+	}
+	return added, bytes, nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// dropOldest drops the oldest pending document of the partition or, if it has none, of any other one.
+func (d *Debouncer) dropOldest(preferred *partition) (int, int64) {
+	if docs, bytes := preferred.dropOldest(); docs > 0 {
+		return docs, bytes
+	}
+	for _, p := range d.partitions {
+		if docs, bytes := p.dropOldest(); docs > 0 {
+			return docs, bytes
+		}
+	}
+	return 0, 0
+}
+
+// Queued returns the number and estimated size of all documents which are pending or in flight.
+func (d *Debouncer) Queued() (int, int64) {
+	return d.limits.usage()
+}
+
+// maxPending returns the number of pending documents of a worker which trigger a flush.
+func (d *Debouncer) maxPending() int {
+	if d.options.Adaptive != nil {
+		return d.options.Adaptive.BatchSize()
+	}
+	return d.options.MaxPending
+}
+
+// partitionOf returns the index of the partition which is responsible for the document.
+func (d *Debouncer) partitionOf(doc opensearch.Document) int {
+	if len(d.partitions) == 1 {
+		return 0
+	}
+
+	key := doc.ID()
+	if partitioned, ok := doc.(PartitionedDocument); ok && partitioned.PartitionKey() != "" {
+		key = partitioned.PartitionKey()
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(d.partitions)))
+}
+
+// Pending returns the number of documents which will be written with the next flush.
+func (d *Debouncer) Pending() int {
+	pending := 0
+	for _, p := range d.partitions {
+		pending += p.pending()
+	}
+	return pending
+}
+
+// Flush writes all pending documents to the Indexer. The partitions are flushed in parallel.
+func (d *Debouncer) Flush(ctx context.Context) error {
+	_, err := d.flush(ctx, nil)
+	return err
+}
+
+// FlushIndices writes the pending documents of the given indices right away
+// and returns how many documents were passed to the Indexer.
+func (d *Debouncer) FlushIndices(ctx context.Context, indices ...string) (int, error) {
+	match := make(map[string]bool, len(indices))
+	for _, index := range indices {
+		match[index] = true
+	}
+	return d.flush(ctx, func(index string) bool { return match[index] })
+}
+
+// flush writes the matching documents of all partitions in parallel.
+func (d *Debouncer) flush(ctx context.Context, match func(index string) bool) (int, error) {
+	flushed := make([]int, len(d.partitions))
+	errs := make([]error, len(d.partitions))
+	wg := sync.WaitGroup{}
+	for i, p := range d.partitions {
+		wg.Add(1)
+		go func(i int, p *partition) {
+			defer wg.Done()
+			flushed[i], errs[i] = d.flushPartition(ctx, p, match)
+		}(i, p)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, docs := range flushed {
+		total += docs
+	}
+	return total, firstError(errs)
+}
+
+// flushPartition writes the pending documents of a single partition.
+// Flushes of the same partition never overlap so that its documents stay in order.
+func (d *Debouncer) flushPartition(ctx context.Context, p *partition, match func(index string) bool) (int, error) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	docs, bytes := p.drain(match)
+	if len(docs) == 0 {
+		return 0, nil
+	}
+	// the documents count against the queue limits until they are written.
+	defer d.limits.release(len(docs), bytes)
+
+	ctx, span := tracing.Tracer(d.options.TracerProvider).Start(ctx, "debounce.flush",
+		trace.WithAttributes(attribute.Int("flush.documents", len(docs))))
+	defer span.End()
+
+	err := d.write(ctx, docs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return len(docs), err
+}
+
+// write passes the documents to the Indexer, in batches when the batch size is adaptive.
+func (d *Debouncer) write(ctx context.Context, docs []opensearch.Document) error {
+	if d.options.Adaptive == nil {
+		result, err := d.indexer.BulkIndex(ctx, docs)
+		d.record(result, err, docs)
+		return err
+	}
+
+	// documents could pile up while waiting for the last flush, so they are written in batches.
+	var firstErr error
+	for len(docs) > 0 {
+		batch := docs
+		if batchSize := d.options.Adaptive.BatchSize(); len(batch) > batchSize {
+			batch = docs[:batchSize]
+		}
+		docs = docs[len(batch):]
+
+		if err := d.flushAdaptive(ctx, batch); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// flushAdaptive writes a batch within the concurrency of the Adaptive and reports the outcome back to it.
+func (d *Debouncer) flushAdaptive(ctx context.Context, docs []opensearch.Document) error {
+	adaptive := d.options.Adaptive
+	if err := adaptive.Acquire(ctx); err != nil {
+		d.record(opensearch.BulkResult{}, err, docs)
+		return err
+	}
+	defer adaptive.Release()
+
+	start := time.Now()
+	result, err := d.indexer.BulkIndex(ctx, docs)
+	// failed requests, e.g. timeouts or 5xx, are as much a sign of overload as rejected documents.
+	adaptive.Observe(time.Since(start), err != nil || result.Rejected() > 0)
+
+	d.record(result, err, docs)
+	return err
+}
+
+// Limits returns the currently effective limits of the Adaptive or false if there is none.
+func (d *Debouncer) Limits() (Limits, bool) {
+	if d.options.Adaptive == nil {
+		return Limits{}, false
+	}
+	return d.options.Adaptive.Limits(), true
+}
+
+// LastErrors returns the most recent flush errors, the oldest first.
+func (d *Debouncer) LastErrors() []FlushError {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	return append([]FlushError(nil), d.lastErrors...)
+}
+
+// PendingByIndex returns the number of pending documents per index.
+func (d *Debouncer) PendingByIndex() map[string]int {
+	counts := map[string]int{}
+	for _, p := range d.partitions {
+		p.pendingByIndex(counts)
+	}
+	return counts
+}
+
+// Stats returns the counters since the Debouncer was created.
+func (d *Debouncer) Stats() Stats {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	return d.stats
+}
+
+func (d *Debouncer) updateStats(update func(stats *Stats)) {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	update(&d.stats)
+}
+
+// record updates the stats with the outcome of a flush.
+func (d *Debouncer) record(result opensearch.BulkResult, err error, docs []opensearch.Document) {
+	if err != nil {
+		d.statsMu.Lock()
+		d.lastErrors = append(d.lastErrors, FlushError{Time: time.Now(), Error: err.Error()})
+		if len(d.lastErrors) > maxLastErrors {
+			d.lastErrors = d.lastErrors[len(d.lastErrors)-maxLastErrors:]
+		}
+		d.statsMu.Unlock()
+	}
+
+	// the request failed as a whole, none of the documents were written.
+	failedRequest := err != nil && result.Succeeded+result.Stale+result.Duplicate+result.Spilled+len(result.Failed) == 0
+
+	// failed documents aren't retried, retryable ones were spilled by the Indexer already.
+	for _, item := range result.Failed {
+		d.options.Metrics.Dropped(item.Index, item.ErrorType())
+	}
+	if failedRequest {
+		for _, doc := range docs {
+			d.options.Metrics.Dropped(doc.Index(), "bouncer_exception")
+		}
+	}
+
+	d.updateStats(func(stats *Stats) {
+		stats.Written += result.Succeeded
+		stats.Stale += result.Stale
+		stats.Duplicate += result.Duplicate
+		stats.Spilled += result.Spilled
+		stats.Failed += len(result.Failed)
+		if failedRequest {
+			stats.Failed += len(docs)
+		}
+	})
+}
+
+// Run starts a worker per partition which flushes periodically and blocks until the context is done.
+// Documents which are pending when the context is done are flushed one last time.
+func (d *Debouncer) Run(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("debouncer")
+
+	errs := make([]error, len(d.partitions))
+	wg := sync.WaitGroup{}
+	for i, p := range d.partitions {
+		wg.Add(1)
+		go func(i int, p *partition) {
+			defer wg.Done()
+			errs[i] = d.runPartition(ctx, log.WithValues("worker", i), p)
+		}(i, p)
+	}
+	wg.Wait()
+
+	return firstError(errs)
+}
+
+// runPartition is the worker loop of a single partition.
+func (d *Debouncer) runPartition(ctx context.Context, log logr.Logger, p *partition) error {
+	ticker := time.NewTicker(d.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the context is already done, so the last flush gets a fresh one.
+			_, err := d.flushPartition(logr.NewContext(context.Background(), log), p, nil)
+			return err
+		case <-ticker.C:
+		case <-p.full:
+		}
+
+		if _, err := d.flushPartition(ctx, p, nil); err != nil {
+			log.Error(err, "flushing pending documents failed")
+		}
+		stats := d.Stats()
+		log.V(1).Info("flushed pending documents", "written", stats.Written,
+			"duplicate", stats.Duplicate, "stale", stats.Stale, "spilled", stats.Spilled,
+			"dropped", stats.Dropped, "failed", stats.Failed)
+		if limits, ok := d.Limits(); ok {
+			log.V(1).Info("adaptive limits", "batchSize", limits.BatchSize,
+				"concurrency", limits.Concurrency, "inFlight", limits.InFlight)
+		}
+	}
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
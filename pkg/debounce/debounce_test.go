@@ -0,0 +1,341 @@
+package debounce
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebouncer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Index Supersedes", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		debouncer.Add(context.Background(), testingDoc{id: "1", version: "first"})
+		debouncer.Add(context.Background(), testingDoc{id: "2", version: "first"})
+		debouncer.Add(context.Background(), testingDoc{id: "1", version: "second"})
+
+		assert.Equal(t, 2, debouncer.Pending())
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.Equal(t, []opensearch.Document{
+			testingDoc{id: "1", version: "second"},
+			testingDoc{id: "2", version: "first"},
+		}, indexer.flushed())
+		assert.Equal(t, 0, debouncer.Pending())
+	})
+
+	t.Run("Delete Supersedes", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		debouncer.Add(context.Background(), testingDoc{id: "1", action: opensearch.ActionCreate})
+		debouncer.Add(context.Background(), testingDoc{id: "1", action: opensearch.ActionIndex})
+		debouncer.Add(context.Background(), testingDoc{id: "1", action: opensearch.ActionDelete})
+
+		assert.Equal(t, 1, debouncer.Pending())
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.Equal(t, []opensearch.Document{
+			testingDoc{id: "1", action: opensearch.ActionDelete},
+		}, indexer.flushed())
+	})
+
+	t.Run("Create After Delete", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		debouncer.Add(context.Background(), testingDoc{id: "1", action: opensearch.ActionDelete})
+		debouncer.Add(context.Background(), testingDoc{id: "1", action: opensearch.ActionCreate})
+
+		assert.Equal(t, 2, debouncer.Pending())
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.Equal(t, []opensearch.Document{
+			testingDoc{id: "1", action: opensearch.ActionDelete},
+			testingDoc{id: "1", action: opensearch.ActionCreate},
+		}, indexer.flushed())
+	})
+
+	t.Run("Updates Merge", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		debouncer.Add(context.Background(), testingUpdate{id: "1", data: map[string]interface{}{
+			"lastEventID": "a",
+			"data":        map[string]interface{}{"foo": "bar", "count": 1},
+		}})
+		debouncer.Add(context.Background(), testingUpdate{id: "1", data: map[string]interface{}{
+			"lastEventID": "b",
+			"data":        map[string]interface{}{"count": 2},
+		}})
+
+		assert.Equal(t, 1, debouncer.Pending())
+		assert.NoError(t, debouncer.Flush(context.Background()))
+
+		flushed := indexer.flushed()
+		assert.Len(t, flushed, 1)
+		assert.Equal(t, opensearch.ActionUpdate, opensearch.ActionOf(flushed[0]))
+		assert.Equal(t, "1", flushed[0].ID())
+		assert.Equal(t, map[string]interface{}{
+			"lastEventID": "b",
+			"data":        map[string]interface{}{"foo": "bar", "count": 2},
+		}, flushed[0].Data())
+	})
+
+	t.Run("Index Then Update", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		debouncer.Add(context.Background(), testingDoc{id: "1", action: opensearch.ActionIndex})
+		debouncer.Add(context.Background(), testingUpdate{id: "1", data: map[string]interface{}{"foo": "bar"}})
+
+		assert.Equal(t, 2, debouncer.Pending())
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1", version: "first", external: 2}))
+		assert.ErrorIs(t, debouncer.Add(context.Background(), testingDoc{id: "1", version: "retry", external: 2}), ErrDuplicateVersion)
+		assert.ErrorIs(t, debouncer.Add(context.Background(), testingDoc{id: "1", version: "old", external: 1}), ErrStaleVersion)
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1", version: "newer", external: 3}))
+
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.Equal(t, []opensearch.Document{
+			testingDoc{id: "1", version: "newer", external: 3},
+		}, indexer.flushed())
+		assert.Equal(t, Stats{Written: 1, Duplicate: 1, Stale: 1}, debouncer.Stats())
+	})
+
+	t.Run("Adds Documents Together", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithWorkers(4))
+
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1", version: "first", external: 2}))
+		assert.ErrorIs(t, debouncer.Add(context.Background(),
+			testingDoc{id: "event", action: opensearch.ActionCreate},
+			testingDoc{id: "1", version: "old", external: 1},
+		), ErrStaleVersion)
+		queued, _ := debouncer.Queued()
+		assert.Equal(t, 1, queued)
+		assert.Equal(t, 1, debouncer.Pending())
+
+		assert.NoError(t, debouncer.Add(context.Background(),
+			testingDoc{id: "event", action: opensearch.ActionCreate},
+			testingDoc{id: "1", version: "newer", external: 3},
+		))
+		assert.Equal(t, 2, debouncer.Pending())
+	})
+
+	t.Run("Partitions Keep Order", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithWorkers(4))
+
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, debouncer.Add(context.Background(), testingDoc{
+				id: strconv.Itoa(i), partition: "object" + strconv.Itoa(i%2), action: opensearch.ActionCreate,
+			}))
+		}
+
+		assert.Equal(t, 20, debouncer.Pending())
+		assert.NoError(t, debouncer.Flush(context.Background()))
+
+		// every object is written by a single bulk in the order the documents were added.
+		for _, bulk := range indexer.bulks {
+			last := map[string]int{}
+			for _, doc := range bulk {
+				id, _ := strconv.Atoi(doc.ID())
+				partition := doc.(testingDoc).partition
+				if previous, ok := last[partition]; ok {
+					assert.Less(t, previous, id)
+				}
+				last[partition] = id
+			}
+		}
+		assert.LessOrEqual(t, len(indexer.bulks), 2)
+		assert.Len(t, indexer.flushed(), 20)
+	})
+
+	t.Run("Flush Indices", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithWorkers(2))
+
+		debouncer.Add(context.Background(), testingDoc{id: "1"})
+		debouncer.Add(context.Background(), testingUpdate{id: "2", data: map[string]interface{}{}})
+		assert.Equal(t, map[string]int{"testIndex": 2}, debouncer.PendingByIndex())
+
+		flushed, err := debouncer.FlushIndices(context.Background(), "otherIndex")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, flushed)
+		assert.Equal(t, 2, debouncer.Pending())
+
+		flushed, err = debouncer.FlushIndices(context.Background(), "testIndex")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, flushed)
+		assert.Equal(t, 0, debouncer.Pending())
+		assert.Empty(t, debouncer.PendingByIndex())
+	})
+
+	t.Run("Last Errors", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{err: errors.New("unavailable")}
+		m := metrics.New()
+		debouncer := New(indexer, WithMetrics(m))
+
+		for i := 0; i < maxLastErrors+2; i++ {
+			debouncer.Add(context.Background(), testingDoc{id: strconv.Itoa(i)})
+			assert.Error(t, debouncer.Flush(context.Background()))
+		}
+
+		lastErrors := debouncer.LastErrors()
+		assert.Len(t, lastErrors, maxLastErrors)
+		assert.Equal(t, "unavailable", lastErrors[0].Error)
+		assert.Equal(t, maxLastErrors+2, debouncer.Stats().Failed)
+		// the documents aren't written again, so they are counted as dropped.
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(),
+			`bouncer_documents_dropped_total{stream="testIndex",type="bouncer_exception"} `+strconv.Itoa(maxLastErrors+2))
+	})
+
+	t.Run("Flush Empty", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer)
+
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.Empty(t, indexer.bulks)
+	})
+
+	t.Run("Run Flushes When Full", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithFlushInterval(time.Hour), WithMaxPending(2))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- debouncer.Run(ctx) }()
+
+		debouncer.Add(context.Background(), testingDoc{id: "1"})
+		debouncer.Add(context.Background(), testingDoc{id: "2"})
+
+		assert.Eventually(t, func() bool {
+			return len(indexer.flushed()) == 2
+		}, time.Second, time.Millisecond)
+
+		debouncer.Add(context.Background(), testingDoc{id: "3"})
+		cancel()
+		assert.NoError(t, <-done)
+		assert.Len(t, indexer.flushed(), 3)
+	})
+}
+
+type testingDoc struct {
+	id        string
+	version   string
+	action    opensearch.Action
+	external  int64
+	partition string
+}
+
+func (t testingDoc) ID() string {
+	return t.id
+}
+
+func (t testingDoc) Index() string {
+	return "testIndex"
+}
+
+func (t testingDoc) Data() interface{} {
+	return map[string]interface{}{"version": t.version}
+}
+
+func (t testingDoc) Action() opensearch.Action {
+	return t.action
+}
+
+func (t testingDoc) Version() int64 {
+	return t.external
+}
+
+func (t testingDoc) PartitionKey() string {
+	return t.partition
+}
+
+type testingUpdate struct {
+	id   string
+	data map[string]interface{}
+}
+
+func (t testingUpdate) ID() string {
+	return t.id
+}
+
+func (t testingUpdate) Index() string {
+	return "testIndex"
+}
+
+func (t testingUpdate) Data() interface{} {
+	return t.data
+}
+
+func (t testingUpdate) Action() opensearch.Action {
+	return opensearch.ActionUpdate
+}
+
+type testingIndexer struct {
+	mu    sync.Mutex
+	bulks [][]opensearch.Document
+	err   error
+}
+
+func (t *testingIndexer) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return opensearch.BulkResult{}, t.err
+	}
+	t.bulks = append(t.bulks, docs)
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
+
+func (t *testingIndexer) flushed() []opensearch.Document {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var docs []opensearch.Document
+	for _, bulk := range t.bulks {
+		docs = append(docs, bulk...)
+	}
+	return docs
+}
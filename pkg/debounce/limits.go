@@ -0,0 +1,196 @@
+package debounce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull   = errors.New("too many documents are pending")
+	ErrMemoryLimit = fmt.Errorf("%w: memory limit reached", ErrQueueFull)
+)
+
+// FullPolicy decides what happens to a document which doesn't fit into the pending limits.
+type FullPolicy int
+
+const (
+	// PolicyBlock waits until the document fits or the context of Add is done.
+	PolicyBlock FullPolicy = iota
+	// PolicyReject fails with ErrQueueFull right away.
+	PolicyReject
+	// PolicyDropOldest drops the oldest pending documents until the document fits.
+	PolicyDropOldest
+)
+
+// ParseFullPolicy returns the FullPolicy with the given name: block, reject or drop-oldest.
+func ParseFullPolicy(name string) (FullPolicy, error) {
+	switch name {
+	case "block":
+		return PolicyBlock, nil
+	case "reject":
+		return PolicyReject, nil
+	case "drop-oldest":
+		return PolicyDropOldest, nil
+	}
+	return 0, fmt.Errorf("unknown full policy %q", name)
+}
+
+// heapObjectsMetric is the runtime metric which is compared to the memory limit.
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// limits keeps track of all pending documents of a Debouncer and enforces the configured limits.
+type limits struct {
+	maxDocs  int
+	maxBytes int64
+	maxHeap  uint64
+	policy   FullPolicy
+
+	mu       sync.Mutex
+	released *sync.Cond
+	docs     int
+	bytes    int64
+
+	heapMu     sync.Mutex
+	heap       uint64
+	heapReadAt time.Time
+}
+
+func newLimits(options Options) *limits {
+	l := &limits{
+		maxDocs:  options.MaxQueueDocuments,
+		maxBytes: options.MaxQueueBytes,
+		maxHeap:  options.MaxHeapBytes,
+		policy:   options.FullPolicy,
+	}
+	l.released = sync.NewCond(&l.mu)
+	return l
+}
+
+// fits reports whether the documents of the given size fit. Limits which are 0 are disabled.
+// Documents which supersede pending ones always fit since they don't need more room.
+// It has to be called with mu held.
+func (l *limits) fits(docs int, size int64) bool {
+	if docs == 0 {
+		return true
+	}
+	if l.maxDocs > 0 && l.docs+docs > l.maxDocs {
+		return false
+	}
+	// a single document which is larger than the limit is accepted into an empty queue.
+	if l.maxBytes > 0 && l.docs > 0 && l.bytes+size > l.maxBytes {
+		return false
+	}
+	return true
+}
+
+// reserve makes room for the documents of the given size according to the FullPolicy.
+// dropOldest is called without holding the lock and should return what it dropped.
+func (l *limits) reserve(ctx context.Context, docs int, size int64, dropOldest func() (int, int64)) (dropped int, err error) {
+	if l.overHeap() {
+		return 0, ErrMemoryLimit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the context is only watched once Add has to wait, documents which fit right away don't pay for it.
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+
+	for !l.fits(docs, size) {
+		switch l.policy {
+		case PolicyReject:
+			return dropped, ErrQueueFull
+		case PolicyDropOldest:
+			l.mu.Unlock()
+			droppedDocs, droppedBytes := dropOldest()
+			l.mu.Lock()
+			if droppedDocs == 0 {
+				return dropped, ErrQueueFull
+			}
+			l.docs -= droppedDocs
+			l.bytes -= droppedBytes
+			dropped += droppedDocs
+		default:
+			if ctx.Err() != nil {
+				return dropped, fmt.Errorf("%w: %s", ErrQueueFull, ctx.Err())
+			}
+			if stop == nil {
+				stop = wakeOnDone(ctx, l.released)
+			}
+			l.released.Wait()
+		}
+	}
+
+	l.docs += docs
+	l.bytes += size
+	return dropped, nil
+}
+
+// adjust corrects a reservation once it is known how much the pending documents actually grew.
+func (l *limits) adjust(docs int, bytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.docs += docs
+	l.bytes += bytes
+	if docs < 0 || bytes < 0 {
+		l.released.Broadcast()
+	}
+}
+
+// release frees the room of documents which are no longer pending.
+func (l *limits) release(docs int, bytes int64) {
+	l.adjust(-docs, -bytes)
+}
+
+// usage returns the number and size of all pending documents.
+func (l *limits) usage() (int, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.docs, l.bytes
+}
+
+// overHeap reports whether the heap of the process exceeds the memory limit.
+// The heap size is read at most every 100ms since it is sampled on every Add.
+func (l *limits) overHeap() bool {
+	if l.maxHeap == 0 {
+		return false
+	}
+
+	l.heapMu.Lock()
+	defer l.heapMu.Unlock()
+
+	if time.Since(l.heapReadAt) > 100*time.Millisecond {
+		sample := []metrics.Sample{{Name: heapObjectsMetric}}
+		metrics.Read(sample)
+		if sample[0].Value.Kind() == metrics.KindUint64 {
+			l.heap = sample[0].Value.Uint64()
+		}
+		l.heapReadAt = time.Now()
+	}
+	return l.heap > l.maxHeap
+}
+
+// wakeOnDone broadcasts on the condition once the context is done, since sync.Cond can't wait for it.
+// The returned function stops waiting for the context.
+func wakeOnDone(ctx context.Context, cond *sync.Cond) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
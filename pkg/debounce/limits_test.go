@@ -0,0 +1,112 @@
+package debounce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reject", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithQueueLimits(2, 0, PolicyReject))
+
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1"}))
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "2"}))
+		// superseding a pending document doesn't need more room.
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1", version: "second"}))
+		assert.ErrorIs(t, debouncer.Add(context.Background(), testingDoc{id: "3"}), ErrQueueFull)
+
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		docs, bytes := debouncer.Queued()
+		assert.Equal(t, 0, docs)
+		assert.Equal(t, int64(0), bytes)
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "3"}))
+	})
+
+	t.Run("Drop Oldest", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithQueueLimits(2, 0, PolicyDropOldest))
+
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1"}))
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "2"}))
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "3"}))
+
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.Equal(t, []opensearch.Document{
+			testingDoc{id: "2"},
+			testingDoc{id: "3"},
+		}, indexer.flushed())
+		assert.Equal(t, Stats{Written: 2, Dropped: 1}, debouncer.Stats())
+	})
+
+	t.Run("Block Until Flushed", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithQueueLimits(1, 0, PolicyBlock))
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1"}))
+
+		added := make(chan error)
+		go func() { added <- debouncer.Add(context.Background(), testingDoc{id: "2"}) }()
+
+		select {
+		case <-added:
+			t.Fatal("Add didn't block while the queue was full")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		assert.NoError(t, debouncer.Flush(context.Background()))
+		assert.NoError(t, <-added)
+		assert.Equal(t, 1, debouncer.Pending())
+	})
+
+	t.Run("Block Respects Deadline", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		debouncer := New(indexer, WithQueueLimits(1, 0, PolicyBlock))
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1"}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, debouncer.Add(ctx, testingDoc{id: "2"}), ErrQueueFull)
+		assert.Equal(t, 1, debouncer.Pending())
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		t.Parallel()
+
+		indexer := &testingIndexer{}
+		size := estimateSize(testingDoc{id: "1"})
+		debouncer := New(indexer, WithQueueLimits(0, 2*size, PolicyReject))
+
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "1"}))
+		assert.NoError(t, debouncer.Add(context.Background(), testingDoc{id: "2"}))
+		assert.ErrorIs(t, debouncer.Add(context.Background(), testingDoc{id: "3"}), ErrQueueFull)
+
+		docs, bytes := debouncer.Queued()
+		assert.Equal(t, 2, docs)
+		assert.Equal(t, 2*size, bytes)
+	})
+
+	t.Run("Parse Policy", func(t *testing.T) {
+		t.Parallel()
+
+		policy, err := ParseFullPolicy("drop-oldest")
+		assert.NoError(t, err)
+		assert.Equal(t, PolicyDropOldest, policy)
+
+		_, err = ParseFullPolicy("unknown")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,77 @@
+package debounce
+
+import (
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+// mergedUpdate is the result of merging multiple pending updates of the same document.
+type mergedUpdate struct {
+	index string
+	id    string
+	data  map[string]interface{}
+}
+
+func (m mergedUpdate) ID() string {
+	return m.id
+}
+
+func (m mergedUpdate) Index() string {
+	return m.index
+}
+
+func (m mergedUpdate) Data() interface{} {
+	return m.data
+}
+
+func (m mergedUpdate) Action() opensearch.Action {
+	return opensearch.ActionUpdate
+}
+
+// appendUpdate merges the update into the last pending write if that is an update as well.
+// Updates with data which is not a map can't be merged and are appended.
+func appendUpdate(docs []opensearch.Document, update opensearch.Document) []opensearch.Document {
+	if len(docs) == 0 {
+		return append(docs, update)
+	}
+
+	last := docs[len(docs)-1]
+	if opensearch.ActionOf(last) != opensearch.ActionUpdate {
+		return append(docs, update)
+	}
+
+	lastData, ok := last.Data().(map[string]interface{})
+	if !ok {
+		return append(docs, update)
+	}
+	updateData, ok := update.Data().(map[string]interface{})
+	if !ok {
+		return append(docs, update)
+	}
+
+	docs[len(docs)-1] = mergedUpdate{
+		index: update.Index(),
+		id:    update.ID(),
+		data:  mergeMaps(lastData, updateData),
+	}
+	return docs
+}
+
+// mergeMaps merges src into a copy of dst the same way opensearch merges partial documents:
+// nested objects are merged recursively and every other value of src replaces the one of dst.
+func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		merged[key] = value
+	}
+
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := merged[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merged[key] = mergeMaps(dstMap, srcMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
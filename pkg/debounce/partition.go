@@ -0,0 +1,217 @@
+package debounce
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+// docKey identifies a document across indices.
+type docKey struct {
+	index string
+	id    string
+}
+
+// pendingDocs are the coalesced pending writes of a single document.
+type pendingDocs struct {
+	docs  []opensearch.Document
+	bytes int64
+}
+
+// partition holds the pending documents of a single worker.
+type partition struct {
+	// flushMu serializes flushes so that documents are written in the order they were added.
+	flushMu sync.Mutex
+
+	mu    sync.Mutex
+	docs  map[docKey]pendingDocs
+	order []docKey
+	count int
+	bytes int64
+
+	full chan struct{}
+}
+
+func newPartition() *partition {
+	return &partition{
+		docs: map[docKey]pendingDocs{},
+		full: make(chan struct{}, 1),
+	}
+}
+
+// checkLocked rejects the document when its version is pending already or older than a pending one.
+// It has to be called with mu held.
+func (p *partition) checkLocked(doc opensearch.Document) error {
+	return checkVersion(p.docs[docKey{index: doc.Index(), id: doc.ID()}].docs, doc)
+}
+
+// addLocked coalesces the document with the pending writes of the same document.
+// It returns by how many documents and bytes the partition grew, which is negative
+// when the document superseded larger pending writes. The version of the document
+// has to be checked with checkLocked before and mu has to be held.
+func (p *partition) addLocked(doc opensearch.Document, size int64) (int, int64) {
+	key := docKey{index: doc.Index(), id: doc.ID()}
+
+	pending, ok := p.docs[key]
+	if !ok {
+		p.order = append(p.order, key)
+	}
+
+	var coalesced pendingDocs
+	switch opensearch.ActionOf(doc) {
+	case opensearch.ActionCreate:
+		coalesced = pendingDocs{docs: append(pending.docs, doc), bytes: pending.bytes + size}
+	case opensearch.ActionUpdate:
+		docs := appendUpdate(pending.docs, doc)
+		coalesced = pendingDocs{docs: docs, bytes: pending.bytes + size}
+		if len(docs) == len(pending.docs) {
+			// the update was merged, so the size of the merged update is unknown.
+			coalesced.bytes = 0
+			for _, pendingDoc := range docs {
+				coalesced.bytes += estimateSize(pendingDoc)
+			}
+		}
+	default:
+		coalesced = pendingDocs{docs: []opensearch.Document{doc}, bytes: size}
+	}
+
+	docsDelta := len(coalesced.docs) - len(pending.docs)
+	bytesDelta := coalesced.bytes - pending.bytes
+	p.count += docsDelta
+	p.bytes += bytesDelta
+	p.docs[key] = coalesced
+	return docsDelta, bytesDelta
+}
+
+// grows reports whether adding the document increases the number of pending documents,
+// which is not the case when it supersedes or is merged into a pending write.
+func (p *partition) grows(doc opensearch.Document) bool {
+	key := docKey{index: doc.Index(), id: doc.ID()}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := p.docs[key].docs
+	if len(pending) == 0 {
+		return true
+	}
+	switch opensearch.ActionOf(doc) {
+	case opensearch.ActionCreate:
+		return true
+	case opensearch.ActionUpdate:
+		return opensearch.ActionOf(pending[len(pending)-1]) != opensearch.ActionUpdate
+	}
+	return false
+}
+
+// checkVersion compares the version of the document to the versions which are already pending.
+func checkVersion(pending []opensearch.Document, doc opensearch.Document) error {
+	version := opensearch.VersionOf(doc)
+	if version == 0 {
+		return nil
+	}
+
+	for _, pendingDoc := range pending {
+		pendingVersion := opensearch.VersionOf(pendingDoc)
+		if pendingVersion == version {
+			return ErrDuplicateVersion
+		}
+		if pendingVersion > version {
+			return ErrStaleVersion
+		}
+	}
+	return nil
+}
+
+func (p *partition) pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count
+}
+
+// signalFull wakes up the worker of the partition without blocking.
+func (p *partition) signalFull() {
+	select {
+	case p.full <- struct{}{}:
+	default:
+	}
+}
+
+// drain removes the pending documents of all indices which match in the order they were first added.
+// A nil match drains every document. It returns the documents and their estimated size.
+func (p *partition) drain(match func(index string) bool) ([]opensearch.Document, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if match == nil {
+		docs := make([]opensearch.Document, 0, p.count)
+		for _, key := range p.order {
+			docs = append(docs, p.docs[key].docs...)
+		}
+		bytes := p.bytes
+
+		p.docs = map[docKey]pendingDocs{}
+		p.order = nil
+		p.count = 0
+		p.bytes = 0
+		return docs, bytes
+	}
+
+	var docs []opensearch.Document
+	var bytes int64
+	order := p.order[:0]
+	for _, key := range p.order {
+		if !match(key.index) {
+			order = append(order, key)
+			continue
+		}
+		pending := p.docs[key]
+		docs = append(docs, pending.docs...)
+		bytes += pending.bytes
+		p.count -= len(pending.docs)
+		p.bytes -= pending.bytes
+		delete(p.docs, key)
+	}
+	p.order = order
+	return docs, bytes
+}
+
+// pendingByIndex adds the number of pending documents per index to counts.
+func (p *partition) pendingByIndex(counts map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, pending := range p.docs {
+		counts[key.index] += len(pending.docs)
+	}
+}
+
+// dropOldest removes the pending writes of the document which was added first.
+// It returns how many documents and bytes were removed.
+func (p *partition) dropOldest() (int, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.order) == 0 {
+		return 0, 0
+	}
+
+	key := p.order[0]
+	dropped := p.docs[key]
+	p.order = p.order[1:]
+	delete(p.docs, key)
+	p.count -= len(dropped.docs)
+	p.bytes -= dropped.bytes
+	return len(dropped.docs), dropped.bytes
+}
+
+// estimateSize approximates how much memory a pending document uses by its JSON size.
+func estimateSize(doc opensearch.Document) int64 {
+	size := int64(len(doc.Index()) + len(doc.ID()))
+	data, err := json.Marshal(doc.Data())
+	if err == nil {
+		size += int64(len(data))
+	}
+	return size
+}
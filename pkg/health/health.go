@@ -0,0 +1,167 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Names of the gRPC health services which report liveness and readiness separately.
+// The unnamed service "" and the served services mirror readiness.
+const (
+	ServiceLiveness  = "liveness"
+	ServiceReadiness = "readiness"
+)
+
+// Cluster reports the health of opensearch.
+type Cluster interface {
+	ClusterHealth(ctx context.Context) (opensearch.ClusterStatus, error)
+}
+
+// Checker decides whether the bouncer is ready to accept events.
+// It is ready once all stream templates were ensured and as long as opensearch
+// is reachable and at least yellow. It is alive as long as it runs.
+type Checker struct {
+	cluster  Cluster
+	ensure   func(ctx context.Context) error
+	interval time.Duration
+	services []string
+
+	server *health.Server
+
+	mu      sync.Mutex
+	ensured bool
+	ready   bool
+	reason  string
+}
+
+// NewChecker creates a Checker which checks the cluster every interval.
+// ensure should create the templates of all streams. It is called until it succeeds once.
+// services are the names of gRPC services which are reported as serving while the Checker is ready.
+func NewChecker(cluster Cluster, ensure func(ctx context.Context) error, interval time.Duration, services ...string) *Checker {
+	c := &Checker{
+		cluster:  cluster,
+		ensure:   ensure,
+		interval: interval,
+		services: append([]string{"", ServiceReadiness}, services...),
+		server:   health.NewServer(),
+		reason:   "not checked yet",
+	}
+	c.server.SetServingStatus(ServiceLiveness, healthpb.HealthCheckResponse_SERVING)
+	c.setReady(false, c.reason)
+	return c
+}
+
+// Server returns the grpc.health.v1 service which reports the state of the Checker.
+func (c *Checker) Server() healthpb.HealthServer {
+	return c.server
+}
+
+// Ready reports whether the bouncer is ready and if not, why.
+func (c *Checker) Ready() (bool, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready, c.reason
+}
+
+// Check updates the readiness once.
+func (c *Checker) Check(ctx context.Context) {
+	ready, reason := c.check(ctx)
+	c.setReady(ready, reason)
+}
+
+func (c *Checker) check(ctx context.Context) (bool, string) {
+	c.mu.Lock()
+	ensured := c.ensured
+	c.mu.Unlock()
+
+	if !ensured {
+		if err := c.ensure(ctx); err != nil {
+			return false, fmt.Sprintf("stream templates are not ensured: %s", err)
+		}
+		c.mu.Lock()
+		c.ensured = true
+		c.mu.Unlock()
+	}
+
+	status, err := c.cluster.ClusterHealth(ctx)
+	if err != nil {
+		return false, fmt.Sprintf("opensearch is unreachable: %s", err)
+	}
+	if !status.Writable() {
+		return false, fmt.Sprintf("opensearch cluster is %s", status)
+	}
+	return true, ""
+}
+
+func (c *Checker) setReady(ready bool, reason string) {
+	c.mu.Lock()
+	c.ready = ready
+	c.reason = reason
+	c.mu.Unlock()
+
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
+
+// Run checks the readiness every interval until the context is done.
+// Afterwards every service, including liveness, is reported as not serving.
+func (c *Checker) Run(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("health")
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	wasReady := false
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, c.interval)
+		c.Check(checkCtx)
+		cancel()
+
+		ready, reason := c.Ready()
+		if ready != wasReady {
+			log.Info("readiness changed", "ready", ready, "reason", reason)
+			wasReady = ready
+		}
+
+		select {
+		case <-ctx.Done():
+			c.server.Shutdown()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// LivenessHandler answers with 200 as long as the process runs.
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadinessHandler answers with 200 while the Checker is ready and 503 with the reason otherwise.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		ready, reason := c.Ready()
+		if !ready {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestChecker(t *testing.T) {
+	t.Parallel()
+
+	ensured := func(context.Context) error { return nil }
+
+	t.Run("Ready", func(t *testing.T) {
+		t.Parallel()
+
+		checker := NewChecker(&testingCluster{status: opensearch.ClusterYellow}, ensured, time.Second, "StreamingService")
+		assertServing(t, checker, "", healthpb.HealthCheckResponse_NOT_SERVING)
+
+		checker.Check(context.Background())
+		ready, _ := checker.Ready()
+		assert.True(t, ready)
+		assertServing(t, checker, "", healthpb.HealthCheckResponse_SERVING)
+		assertServing(t, checker, ServiceReadiness, healthpb.HealthCheckResponse_SERVING)
+		assertServing(t, checker, "StreamingService", healthpb.HealthCheckResponse_SERVING)
+		assertServing(t, checker, ServiceLiveness, healthpb.HealthCheckResponse_SERVING)
+	})
+
+	t.Run("Red Cluster", func(t *testing.T) {
+		t.Parallel()
+
+		checker := NewChecker(&testingCluster{status: opensearch.ClusterRed}, ensured, time.Second)
+		checker.Check(context.Background())
+
+		ready, reason := checker.Ready()
+		assert.False(t, ready)
+		assert.Contains(t, reason, "red")
+		assertServing(t, checker, ServiceReadiness, healthpb.HealthCheckResponse_NOT_SERVING)
+		// a broken cluster doesn't make the process dead.
+		assertServing(t, checker, ServiceLiveness, healthpb.HealthCheckResponse_SERVING)
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := &testingCluster{err: errors.New("connection refused")}
+		checker := NewChecker(cluster, ensured, time.Second)
+		checker.Check(context.Background())
+		ready, reason := checker.Ready()
+		assert.False(t, ready)
+		assert.Contains(t, reason, "unreachable")
+
+		cluster.set(opensearch.ClusterGreen, nil)
+		checker.Check(context.Background())
+		ready, _ = checker.Ready()
+		assert.True(t, ready)
+	})
+
+	t.Run("Templates Are Ensured Once", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		err := errors.New("template failed")
+		checker := NewChecker(&testingCluster{status: opensearch.ClusterGreen}, func(context.Context) error {
+			calls++
+			return err
+		}, time.Second)
+
+		checker.Check(context.Background())
+		ready, reason := checker.Ready()
+		assert.False(t, ready)
+		assert.Contains(t, reason, "template failed")
+
+		err = nil
+		checker.Check(context.Background())
+		checker.Check(context.Background())
+		ready, _ = checker.Ready()
+		assert.True(t, ready)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		t.Parallel()
+
+		checker := NewChecker(&testingCluster{status: opensearch.ClusterRed}, ensured, time.Second)
+		checker.Check(context.Background())
+
+		recorder := httptest.NewRecorder()
+		checker.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+		recorder = httptest.NewRecorder()
+		checker.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Run Stops Serving", func(t *testing.T) {
+		t.Parallel()
+
+		checker := NewChecker(&testingCluster{status: opensearch.ClusterGreen}, ensured, time.Millisecond)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- checker.Run(ctx) }()
+
+		assert.Eventually(t, func() bool {
+			ready, _ := checker.Ready()
+			return ready
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-done)
+		assertServing(t, checker, ServiceLiveness, healthpb.HealthCheckResponse_NOT_SERVING)
+	})
+}
+
+func assertServing(t *testing.T, checker *Checker, service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	response, err := checker.Server().Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, response.GetStatus())
+}
+
+type testingCluster struct {
+	mu     sync.Mutex
+	status opensearch.ClusterStatus
+	err    error
+}
+
+func (c *testingCluster) ClusterHealth(context.Context) (opensearch.ClusterStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status, c.err
+}
+
+func (c *testingCluster) set(status opensearch.ClusterStatus, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.err = err
+}
@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache remembers recently accepted keys so that retries of the same request can be answered immediately.
+// It holds at most size keys, the least recently accepted key is evicted first.
+// Keys are forgotten after the ttl expired.
+type Cache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    int
+}
+
+type entry struct {
+	key     string
+	expires time.Time
+}
+
+// New creates a Cache holding up to size keys for the given ttl.
+func New(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// Accept remembers the key and returns true if it wasn't accepted within the ttl before.
+func (c *Cache) Accept(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if element, ok := c.entries[key]; ok {
+		if now.Before(element.Value.(*entry).expires) {
+			c.hits++
+			return false
+		}
+		c.remove(element)
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{key: key, expires: now.Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+	return true
+}
+
+// Forget removes the key so that the next request with it is accepted again.
+// This should be used when an accepted request failed later on.
+func (c *Cache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Hits returns how many keys were rejected as duplicates.
+func (c *Cache) Hits() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// Len returns the number of remembered keys including the expired ones which weren't evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Duplicate", func(t *testing.T) {
+		t.Parallel()
+
+		cache := New(10, time.Minute)
+
+		assert.True(t, cache.Accept("a"))
+		assert.False(t, cache.Accept("a"))
+		assert.True(t, cache.Accept("b"))
+		assert.Equal(t, 1, cache.Hits())
+	})
+
+	t.Run("Evicts Least Recently Accepted", func(t *testing.T) {
+		t.Parallel()
+
+		cache := New(2, time.Minute)
+
+		assert.True(t, cache.Accept("a"))
+		assert.True(t, cache.Accept("b"))
+		assert.True(t, cache.Accept("c"))
+
+		assert.Equal(t, 2, cache.Len())
+		assert.True(t, cache.Accept("a"))
+		assert.False(t, cache.Accept("c"))
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		cache := New(10, time.Minute)
+		cache.now = func() time.Time { return now }
+
+		assert.True(t, cache.Accept("a"))
+		now = now.Add(time.Minute)
+		assert.True(t, cache.Accept("a"))
+		assert.Equal(t, 0, cache.Hits())
+	})
+
+	t.Run("Forget", func(t *testing.T) {
+		t.Parallel()
+
+		cache := New(10, time.Minute)
+
+		assert.True(t, cache.Accept("a"))
+		cache.Forget("a")
+		assert.True(t, cache.Accept("a"))
+	})
+}
@@ -0,0 +1,149 @@
+package loadgen
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+)
+
+// An Option which can be applied to Options.
+type Option = func(options *Options)
+
+// WithKeys configures the number of distinct keys and how many of them each event carries.
+func WithKeys(keys, perEvent int) Option {
+	return func(options *Options) {
+		options.Keys = keys
+		options.KeysPerEvent = perEvent
+	}
+}
+
+// WithObjects configures the number of distinct objectIDs and their skew.
+func WithObjects(objects int, skew float64) Option {
+	return func(options *Options) {
+		options.Objects = objects
+		options.ObjectSkew = skew
+	}
+}
+
+// WithOperation configures the operation of the generated events.
+func WithOperation(operation types.Operation) Option {
+	return func(options *Options) {
+		options.Operation = operation
+	}
+}
+
+// WithSeed configures the seed of the random values.
+func WithSeed(seed int64) Option {
+	return func(options *Options) {
+		options.Seed = seed
+	}
+}
+
+type Options struct {
+	// Keys is the cardinality of the EventData keys, KeysPerEvent the number of keys of a single event.
+	Keys         int
+	KeysPerEvent int
+
+	// Objects is the number of distinct objectIDs.
+	Objects int
+	// ObjectSkew is the exponent of a zipf distribution of the objectIDs, which has to be greater than 1.
+	// A few objects receive most of the events then. ObjectIDs are distributed uniformly when it is 0.
+	ObjectSkew float64
+
+	Operation types.Operation
+	Seed      int64
+}
+
+// InitWithDefaults initialises Options with default values for each setting.
+func (o *Options) InitWithDefaults() {
+	o.Keys = 10
+	o.KeysPerEvent = 3
+	o.Objects = 1000
+	o.ObjectSkew = 0
+	o.Operation = types.Operation_CREATE
+	o.Seed = 1
+}
+
+// ApplyOptions iterates over []Option and applies every single one of them.
+func (o *Options) ApplyOptions(options []Option) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// Generator creates synthetic events. It is safe for concurrent use.
+type Generator struct {
+	options Options
+
+	mu     sync.Mutex
+	random *rand.Rand
+	zipf   *rand.Zipf
+	events int64
+}
+
+// NewGenerator creates a Generator.
+func NewGenerator(options ...Option) (*Generator, error) {
+	generatorOptions := Options{}
+	generatorOptions.InitWithDefaults()
+	generatorOptions.ApplyOptions(options)
+
+	if generatorOptions.Keys < 1 || generatorOptions.Objects < 1 {
+		return nil, fmt.Errorf("keys and objects have to be positive")
+	}
+	if generatorOptions.KeysPerEvent > generatorOptions.Keys {
+		generatorOptions.KeysPerEvent = generatorOptions.Keys
+	}
+
+	generator := &Generator{
+		options: generatorOptions,
+		random:  rand.New(rand.NewSource(generatorOptions.Seed)),
+	}
+	if generatorOptions.ObjectSkew != 0 {
+		if generatorOptions.ObjectSkew <= 1 {
+			return nil, fmt.Errorf("the object skew has to be greater than 1, got %g", generatorOptions.ObjectSkew)
+		}
+		generator.zipf = rand.NewZipf(generator.random, generatorOptions.ObjectSkew, 1, uint64(generatorOptions.Objects-1))
+	}
+	return generator, nil
+}
+
+// Event returns the next event. Every key always has the same value type, so the events don't cause type conflicts.
+func (g *Generator) Event() *types.Event {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.events++
+	var object uint64
+	if g.zipf != nil {
+		object = g.zipf.Uint64()
+	} else {
+		object = uint64(g.random.Intn(g.options.Objects))
+	}
+
+	event := &types.Event{
+		EventID:   fmt.Sprintf("loadgen-%d-%d", g.options.Seed, g.events),
+		ObjectID:  fmt.Sprintf("object-%d", object),
+		Operation: g.options.Operation,
+		Data:      make([]*types.EventData, 0, g.options.KeysPerEvent),
+	}
+	for _, key := range g.random.Perm(g.options.Keys)[:g.options.KeysPerEvent] {
+		event.Data = append(event.Data, g.value(key))
+	}
+	return event
+}
+
+// value returns a random value of the key, whose type depends on the key.
+func (g *Generator) value(key int) *types.EventData {
+	data := &types.EventData{Key: fmt.Sprintf("key%d", key)}
+	switch key % 3 {
+	case 0:
+		data.Value = &types.EventData_StringValue{StringValue: fmt.Sprintf("value-%d", g.random.Intn(100))}
+	case 1:
+		data.Value = &types.EventData_NumberValue{NumberValue: g.random.Int63n(1_000_000)}
+	default:
+		data.Value = &types.EventData_BoolValue{BoolValue: g.random.Intn(2) == 0}
+	}
+	return data
+}
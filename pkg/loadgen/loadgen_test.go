@@ -0,0 +1,142 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGenerator(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Keys", func(t *testing.T) {
+		t.Parallel()
+
+		generator, err := NewGenerator(WithKeys(6, 4), WithOperation(types.Operation_INDEX))
+		assert.NoError(t, err)
+
+		valueTypes := map[string]string{}
+		ids := map[string]bool{}
+		for i := 0; i < 200; i++ {
+			event := generator.Event()
+			assert.Len(t, event.GetData(), 4)
+			assert.Equal(t, "INDEX", event.GetOperation().String())
+			assert.False(t, ids[event.GetEventID()], "event ids are unique")
+			ids[event.GetEventID()] = true
+
+			for _, value := range event.GetData() {
+				valueType := fmt.Sprintf("%T", value.GetValue())
+				if seen, ok := valueTypes[value.GetKey()]; ok {
+					assert.Equal(t, seen, valueType, "keys keep their type")
+				}
+				valueTypes[value.GetKey()] = valueType
+			}
+		}
+		assert.Len(t, valueTypes, 6)
+	})
+
+	t.Run("Object Skew", func(t *testing.T) {
+		t.Parallel()
+
+		count := func(skew float64) map[string]int {
+			generator, err := NewGenerator(WithObjects(100, skew))
+			assert.NoError(t, err)
+			objects := map[string]int{}
+			for i := 0; i < 10000; i++ {
+				objects[generator.Event().GetObjectID()]++
+			}
+			return objects
+		}
+
+		uniform, skewed := count(0), count(2)
+		assert.Less(t, uniform["object-0"], 300)
+		assert.Greater(t, skewed["object-0"], 5000)
+
+		_, err := NewGenerator(WithObjects(100, 0.5))
+		assert.Error(t, err)
+	})
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	stats := NewStats()
+	for i := 1; i <= 100; i++ {
+		stats.Record(time.Duration(i)*time.Millisecond, types.StatusCode_RECORD_OK, nil)
+	}
+	stats.Record(time.Second, types.StatusCode_RETRY_LATER, nil)
+	stats.Record(time.Millisecond, 0, status.Error(codes.Unavailable, "connection refused"))
+	stats.Record(time.Millisecond, 0, errors.New("no status"))
+
+	report := stats.Report(time.Second)
+	assert.Equal(t, 103, report.Sent)
+	assert.Equal(t, 103.0, report.Rate)
+	assert.Equal(t, map[string]int{"RECORD_OK": 100, "RETRY_LATER": 1}, report.Codes)
+	assert.Equal(t, map[string]int{"Unavailable": 1, "Unknown": 1}, report.Errors)
+	assert.Equal(t, 2, report.Failed())
+	assert.Equal(t, 50*time.Millisecond, report.P50)
+	assert.Equal(t, 91*time.Millisecond, report.P90)
+	assert.Equal(t, 100*time.Millisecond, report.P99)
+	assert.Equal(t, time.Second, report.Max)
+	assert.Contains(t, report.String(), "responses: RECORD_OK=100 RETRY_LATER=1\n")
+	assert.Contains(t, report.String(), "errors: Unavailable=1 Unknown=1\n")
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Rate", func(t *testing.T) {
+		t.Parallel()
+
+		client := &testingClient{}
+		generator, err := NewGenerator()
+		assert.NoError(t, err)
+		stats := NewStats()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		Run(ctx, client, generator, 100, 4, stats)
+
+		report := stats.Report(500 * time.Millisecond)
+		assert.InDelta(t, 50, report.Sent, 10)
+		assert.Equal(t, report.Sent, report.Codes["RECORD_OK"])
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		t.Parallel()
+
+		client := &testingClient{}
+		generator, err := NewGenerator()
+		assert.NoError(t, err)
+		stats := NewStats()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		Run(ctx, client, generator, 0, 2, stats)
+		assert.Greater(t, stats.Report(time.Second).Sent, 100)
+	})
+}
+
+// testingClient accepts every event.
+type testingClient struct {
+	types.StreamingServiceClient
+
+	mu     sync.Mutex
+	events int
+}
+
+func (c *testingClient) Index(context.Context, *types.Event, ...grpc.CallOption) (*types.IndexResonse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events++
+	return &types.IndexResonse{Code: types.StatusCode_RECORD_OK}, nil
+}
@@ -0,0 +1,82 @@
+package loadgen
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+)
+
+// Run sends events of the generator with the given concurrency until ctx is done.
+// At most rate events are sent per second, as many as possible when rate is 0.
+func Run(ctx context.Context, client types.StreamingServiceClient, generator *Generator, rate float64, concurrency int, stats *Stats) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// without a rate the workers don't wait for tokens.
+	var tokens chan struct{}
+	if rate > 0 {
+		tokens = make(chan struct{}, concurrency)
+		go pace(ctx, rate, tokens)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				event := generator.Event()
+				started := time.Now()
+				response, err := client.Index(ctx, event)
+				// calls which were cancelled at the end of the run aren't counted.
+				if ctx.Err() != nil {
+					return
+				}
+				stats.Record(time.Since(started), response.GetCode(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// pace hands out rate tokens per second. Tokens which can't be handed out, since the workers are too slow, are skipped.
+func pace(ctx context.Context, rate float64, tokens chan<- struct{}) {
+	// tickers can't be faster than the scheduler, so several tokens may be due per tick.
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	started, handed := time.Now(), 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		due := int(rate*time.Since(started).Seconds()) - handed
+		for i := 0; i < due; i++ {
+			select {
+			case tokens <- struct{}{}:
+			default:
+			}
+		}
+		handed += due
+	}
+}
@@ -0,0 +1,132 @@
+package loadgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/grpc/types"
+	"google.golang.org/grpc/status"
+)
+
+// Stats records the outcome and latency of every sent event. It is safe for concurrent use.
+type Stats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	codes     map[string]int
+	errors    map[string]int
+}
+
+// NewStats creates empty Stats.
+func NewStats() *Stats {
+	return &Stats{codes: map[string]int{}, errors: map[string]int{}}
+}
+
+// Record adds the outcome of an event. Errors are counted by their gRPC status code.
+func (s *Stats) Record(latency time.Duration, code types.StatusCode, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, latency)
+	if err != nil {
+		s.errors[status.Code(err).String()]++
+		return
+	}
+	s.codes[code.String()]++
+}
+
+// Report summarizes the recorded events which were sent within elapsed.
+func (s *Stats) Report(elapsed time.Duration) Report {
+	s.mu.Lock()
+	latencies := append([]time.Duration(nil), s.latencies...)
+	report := Report{Elapsed: elapsed, Codes: copyCounts(s.codes), Errors: copyCounts(s.errors)}
+	s.mu.Unlock()
+
+	report.Sent = len(latencies)
+	if elapsed > 0 {
+		report.Rate = float64(report.Sent) / elapsed.Seconds()
+	}
+	if len(latencies) == 0 {
+		return report
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 0.5)
+	report.P90 = percentile(latencies, 0.9)
+	report.P99 = percentile(latencies, 0.99)
+	report.Max = latencies[len(latencies)-1]
+	return report
+}
+
+// percentile returns the latency below which the fraction p of the sorted latencies are.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// Report is the summary of Stats.
+type Report struct {
+	Sent    int
+	Elapsed time.Duration
+	// Rate is the number of sent events per second.
+	Rate float64
+
+	// Codes counts the answered events by their StatusCode, Errors the failed calls by their gRPC status code.
+	Codes  map[string]int
+	Errors map[string]int
+
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// Failed returns the number of calls which failed.
+func (r Report) Failed() int {
+	failed := 0
+	for _, count := range r.Errors {
+		failed += count
+	}
+	return failed
+}
+
+func (r Report) String() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "sent %d events in %s (%.1f/s)\n", r.Sent, r.Elapsed.Round(time.Millisecond), r.Rate)
+	fmt.Fprintf(builder, "latency p50 %s p90 %s p99 %s max %s\n", r.P50, r.P90, r.P99, r.Max)
+	writeCounts(builder, "responses", r.Codes)
+	writeCounts(builder, "errors", r.Errors)
+	return builder.String()
+}
+
+// writeCounts writes the counts ordered by their key.
+func writeCounts(builder *strings.Builder, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(builder, "%s:", title)
+	for _, key := range keys {
+		fmt.Fprintf(builder, " %s=%d", key, counts[key])
+	}
+	builder.WriteString("\n")
+}
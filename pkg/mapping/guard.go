@@ -0,0 +1,237 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrKeyLimit is returned by Guard.Check when a key exceeds the Limits and the KeyPolicy rejects the event.
+	ErrKeyLimit = errors.New("key limit exceeded")
+	// ErrUnknownKeyPolicy is returned by ParseKeyPolicy.
+	ErrUnknownKeyPolicy = errors.New("unknown key policy")
+)
+
+// OverflowField is the catch-all field keys exceeding the Limits are moved to by KeyFlatten.
+const OverflowField = "overflow"
+
+// maxOffenders bounds the number of clients which are remembered per stream.
+const maxOffenders = 1000
+
+// KeyPolicy decides what happens to an event with keys exceeding the Limits.
+type KeyPolicy string
+
+const (
+	// KeyReject rejects the whole event.
+	KeyReject KeyPolicy = "reject"
+	// KeyDrop removes the exceeding keys from the event.
+	KeyDrop KeyPolicy = "drop"
+	// KeyFlatten moves the exceeding keys to OverflowField, which is mapped as a single flattened field.
+	KeyFlatten KeyPolicy = "flatten"
+)
+
+// ParseKeyPolicy parses the name of a KeyPolicy.
+func ParseKeyPolicy(name string) (KeyPolicy, error) {
+	switch policy := KeyPolicy(name); policy {
+	case KeyReject, KeyDrop, KeyFlatten:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownKeyPolicy, name)
+}
+
+// Limits restrict the keys of a stream. Zero values disable a limit.
+type Limits struct {
+	// MaxKeys is the number of distinct keys per stream.
+	MaxKeys int
+	// MaxKeyLength is the number of bytes of a key.
+	MaxKeyLength int
+	// KeyPattern has to match every key.
+	KeyPattern *regexp.Regexp
+}
+
+// Offender is a client which sent keys exceeding the Limits of a stream.
+type Offender struct {
+	Stream string
+	Client string
+	// Keys is the number of exceeding keys the client sent, Events the number of events they were part of.
+	Keys   int64
+	Events int64
+	// LastKey is the most recent exceeding key.
+	LastKey string
+}
+
+// Guard enforces Limits on the keys of every stream and remembers the clients exceeding them.
+// The distinct keys are counted in memory, so every replica of the bouncer counts them separately.
+// Seed them with the keys the stream maps already to keep the count across restarts.
+// All methods can be called on a nil Guard, which accepts every key.
+type Guard struct {
+	limits Limits
+	policy KeyPolicy
+
+	mu        sync.Mutex
+	keys      map[string]map[string]struct{}
+	offenders map[string]map[string]*Offender
+}
+
+// NewGuard creates a Guard which applies the policy to keys exceeding the limits.
+func NewGuard(limits Limits, policy KeyPolicy) *Guard {
+	return &Guard{
+		limits:    limits,
+		policy:    policy,
+		keys:      map[string]map[string]struct{}{},
+		offenders: map[string]map[string]*Offender{},
+	}
+}
+
+// Policy returns the KeyPolicy of the Guard. It is KeyReject for a nil Guard.
+func (g *Guard) Policy() KeyPolicy {
+	if g == nil {
+		return KeyReject
+	}
+	return g.policy
+}
+
+// Seed counts the keys below DataField which are mapped by the mappings as distinct keys of the stream.
+// Seeded keys are known, even when they exceed the limits.
+func (g *Guard) Seed(stream string, mappings map[string]interface{}) {
+	if g == nil {
+		return
+	}
+	properties, _ := mappings["properties"].(map[string]interface{})
+	data, _ := properties[DataField].(map[string]interface{})
+	types := map[string]Type{}
+	seedProperties(types, "", data)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	known, ok := g.keys[stream]
+	if !ok {
+		known = map[string]struct{}{}
+		g.keys[stream] = known
+	}
+	for key, fieldType := range types {
+		// objects are no keys of their own, only their leaves are.
+		if fieldType != TypeObject {
+			known[key] = struct{}{}
+		}
+	}
+}
+
+// Check returns the positions of the keys which exceed the limits of the stream. New keys within the limits
+// are counted as distinct keys of the stream, unless the event is rejected. The error wraps ErrKeyLimit
+// and describes the first exceeding key when the KeyPolicy is KeyReject.
+func (g *Guard) Check(stream, client string, keys []string) ([]int, error) {
+	if g == nil {
+		return nil, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	known, ok := g.keys[stream]
+	if !ok {
+		known = map[string]struct{}{}
+		g.keys[stream] = known
+	}
+
+	var exceeded []int
+	var reason string
+	added := map[string]struct{}{}
+	for i, key := range keys {
+		keyReason := g.exceeds(stream, key, known, added)
+		if keyReason == "" {
+			continue
+		}
+		if reason == "" {
+			reason = keyReason
+		}
+		exceeded = append(exceeded, i)
+	}
+
+	if len(exceeded) > 0 {
+		g.offend(stream, client, keys, exceeded)
+		if g.policy == KeyReject {
+			return exceeded, fmt.Errorf("%w: %s", ErrKeyLimit, reason)
+		}
+	}
+	for key := range added {
+		known[key] = struct{}{}
+	}
+	return exceeded, nil
+}
+
+// exceeds returns why the key exceeds the limits or an empty string. Keys within the limits are added to added.
+func (g *Guard) exceeds(stream, key string, known, added map[string]struct{}) string {
+	if g.limits.MaxKeyLength > 0 && len(key) > g.limits.MaxKeyLength {
+		return fmt.Sprintf("key %q is longer than %d bytes", key, g.limits.MaxKeyLength)
+	}
+	if g.limits.KeyPattern != nil && !g.limits.KeyPattern.MatchString(key) {
+		return fmt.Sprintf("key %q doesn't match %s", key, g.limits.KeyPattern)
+	}
+	if _, ok := known[key]; ok {
+		return ""
+	}
+	if _, ok := added[key]; ok {
+		return ""
+	}
+	if g.limits.MaxKeys > 0 && len(known)+len(added) >= g.limits.MaxKeys {
+		return fmt.Sprintf("stream %s has %d distinct keys already, key %q is new", stream, g.limits.MaxKeys, key)
+	}
+	added[key] = struct{}{}
+	return ""
+}
+
+func (g *Guard) offend(stream, client string, keys []string, exceeded []int) {
+	clients, ok := g.offenders[stream]
+	if !ok {
+		clients = map[string]*Offender{}
+		g.offenders[stream] = clients
+	}
+	offender, ok := clients[client]
+	if !ok {
+		// clients beyond the bound aren't reported, the worst offenders show up early anyway.
+		if len(clients) >= maxOffenders {
+			return
+		}
+		offender = &Offender{Stream: stream, Client: client}
+		clients[client] = offender
+	}
+	offender.Keys += int64(len(exceeded))
+	offender.Events++
+	offender.LastKey = keys[exceeded[len(exceeded)-1]]
+}
+
+// Offenders returns the clients which sent the most exceeding keys to the stream, or to every stream when
+// stream is empty. At most limit clients are returned unless limit is 0.
+func (g *Guard) Offenders(stream string, limit int) []Offender {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	var offenders []Offender
+	for name, clients := range g.offenders {
+		if stream != "" && name != stream {
+			continue
+		}
+		for _, offender := range clients {
+			offenders = append(offenders, *offender)
+		}
+	}
+	g.mu.Unlock()
+
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].Keys != offenders[j].Keys {
+			return offenders[i].Keys > offenders[j].Keys
+		}
+		if offenders[i].Stream != offenders[j].Stream {
+			return offenders[i].Stream < offenders[j].Stream
+		}
+		return offenders[i].Client < offenders[j].Client
+	})
+	if limit > 0 && len(offenders) > limit {
+		offenders = offenders[:limit]
+	}
+	return offenders
+}
@@ -0,0 +1,100 @@
+package mapping
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Distinct Keys", func(t *testing.T) {
+		t.Parallel()
+
+		guard := NewGuard(Limits{MaxKeys: 2}, KeyDrop)
+		exceeded, err := guard.Check("events", "a", []string{"level", "message", "user-4711"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2}, exceeded)
+
+		// known keys stay accepted, other streams have their own budget.
+		exceeded, err = guard.Check("events", "a", []string{"message", "level", "user-4712"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2}, exceeded)
+		exceeded, err = guard.Check("other", "a", []string{"user-4711"})
+		assert.NoError(t, err)
+		assert.Empty(t, exceeded)
+	})
+
+	t.Run("Seed", func(t *testing.T) {
+		t.Parallel()
+
+		guard := NewGuard(Limits{MaxKeys: 3}, KeyDrop)
+		guard.Seed("events", Mappings(map[string]Type{"level": TypeString, "user": TypeObject, "user.id": TypeNumber}))
+
+		exceeded, err := guard.Check("events", "a", []string{"user.id", "message", "user-4711"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2}, exceeded)
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		t.Parallel()
+
+		guard := NewGuard(Limits{MaxKeys: 2, MaxKeyLength: 8, KeyPattern: regexp.MustCompile(`^[a-z.]+$`)}, KeyReject)
+		_, err := guard.Check("events", "a", []string{"level", "Level"})
+		assert.ErrorIs(t, err, ErrKeyLimit)
+		assert.EqualError(t, err, `key limit exceeded: key "Level" doesn't match ^[a-z.]+$`)
+
+		_, err = guard.Check("events", "a", []string{"verylongkey"})
+		assert.EqualError(t, err, `key limit exceeded: key "verylongkey" is longer than 8 bytes`)
+
+		// keys of rejected events don't count.
+		_, err = guard.Check("events", "a", []string{"level", "message"})
+		assert.NoError(t, err)
+		_, err = guard.Check("events", "a", []string{"user"})
+		assert.EqualError(t, err, `key limit exceeded: stream events has 2 distinct keys already, key "user" is new`)
+	})
+
+	t.Run("Offenders", func(t *testing.T) {
+		t.Parallel()
+
+		guard := NewGuard(Limits{MaxKeys: 1}, KeyFlatten)
+		_, _ = guard.Check("events", "a", []string{"level"})
+		_, _ = guard.Check("events", "a", []string{"id-1"})
+		_, _ = guard.Check("events", "b", []string{"id-2", "id-3"})
+		_, _ = guard.Check("other", "c", []string{"level", "id-4"})
+
+		assert.Equal(t, []Offender{
+			{Stream: "events", Client: "b", Keys: 2, Events: 1, LastKey: "id-3"},
+			{Stream: "events", Client: "a", Keys: 1, Events: 1, LastKey: "id-1"},
+			{Stream: "other", Client: "c", Keys: 1, Events: 1, LastKey: "id-4"},
+		}, guard.Offenders("", 0))
+		assert.Equal(t, []Offender{
+			{Stream: "events", Client: "b", Keys: 2, Events: 1, LastKey: "id-3"},
+		}, guard.Offenders("events", 1))
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+
+		var guard *Guard
+		guard.Seed("events", Mappings(map[string]Type{"level": TypeString}))
+		exceeded, err := guard.Check("events", "a", []string{"level"})
+		assert.NoError(t, err)
+		assert.Empty(t, exceeded)
+		assert.Equal(t, KeyReject, guard.Policy())
+		assert.Empty(t, guard.Offenders("", 0))
+	})
+
+	t.Run("Parse Policy", func(t *testing.T) {
+		t.Parallel()
+
+		policy, err := ParseKeyPolicy("flatten")
+		assert.NoError(t, err)
+		assert.Equal(t, KeyFlatten, policy)
+
+		_, err = ParseKeyPolicy("ignore")
+		assert.ErrorIs(t, err, ErrUnknownKeyPolicy)
+	})
+}
@@ -0,0 +1,308 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrTypeConflict is returned when a key arrives with another type than it was seen with before.
+var ErrTypeConflict = errors.New("type conflict")
+
+// DataField is the field of documents which holds the EventData keys.
+const DataField = "data"
+
+// Type is the kind of value an EventData key was seen with.
+type Type string
+
+const (
+	TypeString Type = "string"
+	TypeBool   Type = "bool"
+	TypeNumber Type = "number"
+	// TypeObject is the type of keys which are a prefix of dotted keys, e.g. "a" of "a.b".
+	TypeObject Type = "object"
+)
+
+// MappingType returns the field type of the explicit mapping of the Type.
+// Strings are mapped as keyword, the dynamic mapping would add a text field as well.
+func (t Type) MappingType() string {
+	switch t {
+	case TypeString:
+		return "keyword"
+	case TypeBool:
+		return "boolean"
+	case TypeNumber:
+		return "long"
+	}
+	return "object"
+}
+
+// typeOfMapping returns the Type of a mapped field type. Other field types, e.g. date, aren't tracked.
+func typeOfMapping(fieldType string) (Type, bool) {
+	switch fieldType {
+	case "keyword", "text", "match_only_text", "wildcard", "constant_keyword":
+		return TypeString, true
+	case "boolean":
+		return TypeBool, true
+	case "long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float", "unsigned_long":
+		return TypeNumber, true
+	}
+	return "", false
+}
+
+// Field is an EventData key and the Type of its value.
+type Field struct {
+	Key  string
+	Type Type
+}
+
+// ConflictError describes which key of a stream arrived with which type.
+type ConflictError struct {
+	Stream string
+	Key    string
+	Seen   Type
+	Got    Type
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("key %q of stream %s was seen as %s and can't be a %s", e.Key, e.Stream, e.Seen, e.Got)
+}
+
+func (e ConflictError) Unwrap() error {
+	return ErrTypeConflict
+}
+
+// Tracker remembers the type of every key per stream. Keys are expanded at dots like opensearch does,
+// so a key can't be a value and the parent of other keys at the same time.
+// All methods can be called on a nil Tracker, which accepts everything.
+type Tracker struct {
+	mu      sync.Mutex
+	streams map[string]*streamFields
+}
+
+type streamFields struct {
+	types map[string]Type
+	// pending are the keys which were seen since the last call of Pending.
+	pending map[string]Type
+}
+
+// NewTracker creates an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{streams: map[string]*streamFields{}}
+}
+
+func (t *Tracker) stream(name string) *streamFields {
+	fields, ok := t.streams[name]
+	if !ok {
+		fields = &streamFields{types: map[string]Type{}, pending: map[string]Type{}}
+		t.streams[name] = fields
+	}
+	return fields
+}
+
+// Observe records the fields of a document of the stream. Nothing is recorded when one of them conflicts
+// with a type seen before or with another field of the document.
+func (t *Tracker) Observe(stream string, fields []Field) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known := t.stream(stream)
+	added := map[string]Type{}
+	lookup := func(key string) (Type, bool) {
+		if fieldType, ok := added[key]; ok {
+			return fieldType, true
+		}
+		fieldType, ok := known.types[key]
+		return fieldType, ok
+	}
+	for _, field := range fields {
+		if err := check(stream, field.Key, field.Type, lookup, added); err != nil {
+			return err
+		}
+	}
+
+	for key, fieldType := range added {
+		known.types[key] = fieldType
+		known.pending[key] = fieldType
+	}
+	return nil
+}
+
+// check adds the key and its parents to added unless they conflict with the types found by lookup.
+func check(stream, key string, fieldType Type, lookup func(string) (Type, bool), added map[string]Type) error {
+	parts := strings.Split(key, ".")
+	for i := range parts {
+		path, want := strings.Join(parts[:i+1], "."), TypeObject
+		if i == len(parts)-1 {
+			want = fieldType
+		}
+		seen, ok := lookup(path)
+		if !ok {
+			added[path] = want
+			continue
+		}
+		if seen != want {
+			return ConflictError{Stream: stream, Key: path, Seen: seen, Got: want}
+		}
+	}
+	return nil
+}
+
+// Seed records the fields of the mappings of an index of the stream, e.g. of its template or a backing index.
+// Seeded fields aren't pending, they are mapped already. Fields which conflict with known ones are skipped.
+func (t *Tracker) Seed(stream string, mappings map[string]interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	properties, _ := mappings["properties"].(map[string]interface{})
+	data, _ := properties[DataField].(map[string]interface{})
+	known := t.stream(stream)
+	seedProperties(known.types, "", data)
+}
+
+func seedProperties(types map[string]Type, prefix string, field map[string]interface{}) {
+	properties, ok := field["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for name, value := range properties {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key := prefix + name
+		if fieldType, ok := child["type"].(string); ok && fieldType != "object" {
+			if seeded, ok := typeOfMapping(fieldType); ok {
+				if _, exists := types[key]; !exists {
+					types[key] = seeded
+				}
+			}
+			continue
+		}
+		if seen, exists := types[key]; exists && seen != TypeObject {
+			continue
+		}
+		types[key] = TypeObject
+		seedProperties(types, key+".", child)
+	}
+}
+
+// Fields returns the type of every key seen for the stream.
+func (t *Tracker) Fields(stream string) map[string]Type {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyTypes(t.stream(stream).types)
+}
+
+// Pending returns the keys of the stream which were observed since the last call and forgets them.
+func (t *Tracker) Pending(stream string) map[string]Type {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known := t.stream(stream)
+	pending := known.pending
+	known.pending = map[string]Type{}
+	return pending
+}
+
+// Requeue marks keys returned by Pending as pending again, e.g. when mapping them failed.
+func (t *Tracker) Requeue(stream string, pending map[string]Type) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known := t.stream(stream)
+	for key, fieldType := range pending {
+		known.pending[key] = fieldType
+	}
+}
+
+func copyTypes(types map[string]Type) map[string]Type {
+	copied := make(map[string]Type, len(types))
+	for key, fieldType := range types {
+		copied[key] = fieldType
+	}
+	return copied
+}
+
+// Mappings returns the explicit mappings of the keys below DataField, e.g.
+// {"properties": {"data": {"properties": {"level": {"type": "keyword"}}}}}.
+// It is nil when there are no keys.
+func Mappings(types map[string]Type) map[string]interface{} {
+	if len(types) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(types))
+	for key := range types {
+		keys = append(keys, key)
+	}
+	// parents are sorted before their children.
+	sort.Strings(keys)
+
+	data := map[string]interface{}{}
+	for _, key := range keys {
+		properties := data
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			properties = objectProperties(properties, part)
+		}
+		name := parts[len(parts)-1]
+		if types[key] == TypeObject {
+			objectProperties(properties, name)
+			continue
+		}
+		properties[name] = map[string]interface{}{"type": types[key].MappingType()}
+	}
+	return map[string]interface{}{
+		"properties": map[string]interface{}{DataField: map[string]interface{}{"properties": data}},
+	}
+}
+
+// objectProperties returns the properties of the object field name within properties and adds it if missing.
+func objectProperties(properties map[string]interface{}, name string) map[string]interface{} {
+	object, ok := properties[name].(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{"properties": map[string]interface{}{}}
+		properties[name] = object
+	}
+	return object["properties"].(map[string]interface{})
+}
+
+// Merge returns the mappings of explicit with every field of inferred which explicit doesn't configure.
+// Neither of both is modified.
+func Merge(explicit, inferred map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(explicit))
+	for key, value := range explicit {
+		merged[key] = value
+	}
+	for key, value := range inferred {
+		existing, ok := merged[key]
+		if !ok {
+			merged[key] = value
+			continue
+		}
+		existingMap, existingOk := existing.(map[string]interface{})
+		valueMap, valueOk := value.(map[string]interface{})
+		if existingOk && valueOk {
+			merged[key] = Merge(existingMap, valueMap)
+		}
+	}
+	return merged
+}
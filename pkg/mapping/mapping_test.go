@@ -0,0 +1,122 @@
+package mapping
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Conflicts", func(t *testing.T) {
+		t.Parallel()
+
+		tracker := NewTracker()
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "enabled", Type: TypeBool}, {Key: "user.name", Type: TypeString}}))
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "enabled", Type: TypeBool}}))
+		// streams are tracked independently.
+		assert.NoError(t, tracker.Observe("other", []Field{{Key: "enabled", Type: TypeString}}))
+
+		err := tracker.Observe("events", []Field{{Key: "count", Type: TypeNumber}, {Key: "enabled", Type: TypeString}})
+		assert.ErrorIs(t, err, ErrTypeConflict)
+		assert.EqualError(t, err, `key "enabled" of stream events was seen as bool and can't be a string`)
+		// nothing of the rejected document is recorded.
+		assert.NotContains(t, tracker.Fields("events"), "count")
+
+		err = tracker.Observe("events", []Field{{Key: "user", Type: TypeString}})
+		assert.Equal(t, ConflictError{Stream: "events", Key: "user", Seen: TypeObject, Got: TypeString}, err)
+		err = tracker.Observe("events", []Field{{Key: "enabled.value", Type: TypeBool}})
+		assert.Equal(t, ConflictError{Stream: "events", Key: "enabled", Seen: TypeBool, Got: TypeObject}, err)
+
+		// the fields of a single document have to agree as well.
+		err = tracker.Observe("events", []Field{{Key: "level", Type: TypeString}, {Key: "level", Type: TypeNumber}})
+		assert.ErrorIs(t, err, ErrTypeConflict)
+	})
+
+	t.Run("Pending", func(t *testing.T) {
+		t.Parallel()
+
+		tracker := NewTracker()
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "user.name", Type: TypeString}}))
+		assert.Equal(t, map[string]Type{"user": TypeObject, "user.name": TypeString}, tracker.Pending("events"))
+		assert.Empty(t, tracker.Pending("events"))
+
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "user.name", Type: TypeString}, {Key: "count", Type: TypeNumber}}))
+		pending := tracker.Pending("events")
+		assert.Equal(t, map[string]Type{"count": TypeNumber}, pending)
+
+		// keys which failed to be mapped are pending again, next to the ones seen meanwhile.
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "level", Type: TypeString}}))
+		tracker.Requeue("events", pending)
+		assert.Equal(t, map[string]Type{"count": TypeNumber, "level": TypeString}, tracker.Pending("events"))
+	})
+
+	t.Run("Seed", func(t *testing.T) {
+		t.Parallel()
+
+		tracker := NewTracker()
+		tracker.Seed("events", map[string]interface{}{"properties": map[string]interface{}{
+			"eventID": map[string]interface{}{"type": "keyword"},
+			"data": map[string]interface{}{"properties": map[string]interface{}{
+				"message": map[string]interface{}{"type": "text", "fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword"}}},
+				"count":   map[string]interface{}{"type": "long"},
+				"created": map[string]interface{}{"type": "date"},
+				"user":    map[string]interface{}{"properties": map[string]interface{}{"admin": map[string]interface{}{"type": "boolean"}}},
+			}},
+		}})
+
+		assert.Equal(t, map[string]Type{
+			"message": TypeString, "count": TypeNumber, "user": TypeObject, "user.admin": TypeBool,
+		}, tracker.Fields("events"))
+		assert.Empty(t, tracker.Pending("events"))
+		assert.ErrorIs(t, tracker.Observe("events", []Field{{Key: "count", Type: TypeString}}), ErrTypeConflict)
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "created", Type: TypeString}}))
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+
+		var tracker *Tracker
+		assert.NoError(t, tracker.Observe("events", []Field{{Key: "enabled", Type: TypeBool}}))
+		assert.Empty(t, tracker.Pending("events"))
+	})
+}
+
+func TestMappings(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Mappings", func(t *testing.T) {
+		t.Parallel()
+
+		mappings := Mappings(map[string]Type{
+			"level": TypeString, "user": TypeObject, "user.admin": TypeBool, "stats.count": TypeNumber,
+		})
+		encoded, _ := json.Marshal(mappings)
+		assert.JSONEq(t, `{"properties": {"data": {"properties": {
+			"level": {"type": "keyword"},
+			"user": {"properties": {"admin": {"type": "boolean"}}},
+			"stats": {"properties": {"count": {"type": "long"}}}
+		}}}}`, string(encoded))
+		assert.Nil(t, Mappings(nil))
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		t.Parallel()
+
+		explicit := map[string]interface{}{
+			"dynamic": "true",
+			"properties": map[string]interface{}{
+				"data": map[string]interface{}{"properties": map[string]interface{}{"level": map[string]interface{}{"type": "text"}}},
+			},
+		}
+		merged := Merge(explicit, Mappings(map[string]Type{"level": TypeString, "count": TypeNumber}))
+		encoded, _ := json.Marshal(merged)
+		assert.JSONEq(t, `{"dynamic": "true", "properties": {"data": {"properties": {
+			"level": {"type": "text"},
+			"count": {"type": "long"}
+		}}}}`, string(encoded))
+		assert.Len(t, explicit["properties"].(map[string]interface{})["data"].(map[string]interface{})["properties"], 1)
+	})
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bouncer"
+
+// Reasons an event is rejected for.
+const (
+	ReasonInvalid      = "invalid"
+	ReasonTypeConflict = "type_conflict"
+	ReasonKeyLimit     = "key_limit"
+	ReasonDuplicate    = "duplicate"
+	ReasonStale        = "stale"
+	ReasonRetryLater   = "retry_later"
+	ReasonQueueFull    = "queue_full"
+	ReasonPaused       = "paused"
+	ReasonInternal     = "internal"
+)
+
+// Metrics are the prometheus collectors of the bouncer.
+// All methods can be called on nil Metrics, which records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	received   *prometheus.CounterVec
+	accepted   *prometheus.CounterVec
+	rejected   *prometheus.CounterVec
+	bulkDocs   prometheus.Histogram
+	bulkBytes  prometheus.Histogram
+	bulkTime   *prometheus.HistogramVec
+	itemErrors *prometheus.CounterVec
+	retried    prometheus.Counter
+	spilled    prometheus.Counter
+	dropped    *prometheus.CounterVec
+}
+
+// New creates Metrics with their own registry, which includes the go and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_received_total",
+			Help:      "Number of events which were received.",
+		}, []string{"stream"}),
+		accepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_accepted_total",
+			Help:      "Number of events which were accepted to be written.",
+		}, []string{"stream"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_rejected_total",
+			Help:      "Number of events which were not accepted by the reason they were rejected for.",
+		}, []string{"stream", "reason"}),
+		bulkDocs: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "bulk_documents",
+			Help:      "Number of documents in a bulk request.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		bulkBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "bulk_bytes",
+			Help:      "Uncompressed size of a bulk request.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+		}),
+		bulkTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "bulk_duration_seconds",
+			Help:      "Latency of bulk requests by whether the request as a whole succeeded.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"result"}),
+		itemErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bulk_item_errors_total",
+			Help:      "Number of documents opensearch didn't write by the type of the error.",
+		}, []string{"type"}),
+		retried: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retried_documents_total",
+			Help:      "Number of spilled documents which were sent to opensearch again.",
+		}),
+		spilled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "spilled_documents_total",
+			Help:      "Number of documents which were written to the spill queue.",
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "documents_dropped_total",
+			Help:      "Number of documents which failed permanently and are given up on by the type of the error.",
+		}, []string{"stream", "type"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.received, m.accepted, m.rejected,
+		m.bulkDocs, m.bulkBytes, m.bulkTime, m.itemErrors,
+		m.retried, m.spilled, m.dropped,
+	)
+	return m
+}
+
+// Registry returns the registry the metrics are registered with.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// WatchQueue exports the number and size of the documents which are pending or in flight.
+func (m *Metrics) WatchQueue(queued func() (int, int64)) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_documents",
+			Help:      "Number of documents which are pending or in flight.",
+		}, func() float64 {
+			docs, _ := queued()
+			return float64(docs)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_bytes",
+			Help:      "Estimated size of the documents which are pending or in flight.",
+		}, func() float64 {
+			_, bytes := queued()
+			return float64(bytes)
+		}),
+	)
+}
+
+// WatchCompression exports how well bulk request bodies compress. compression returns the sum of the
+// body sizes before and after compression and the total time spent compressing.
+func (m *Metrics) WatchCompression(compression func() (uncompressed, compressed int64, duration time.Duration)) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bulk_uncompressed_bytes_total",
+			Help:      "Size of the compressed bulk request bodies before compression.",
+		}, func() float64 {
+			uncompressed, _, _ := compression()
+			return float64(uncompressed)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bulk_compressed_bytes_total",
+			Help:      "Size of the compressed bulk request bodies after compression.",
+		}, func() float64 {
+			_, compressed, _ := compression()
+			return float64(compressed)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "bulk_compression_ratio",
+			Help:      "Uncompressed divided by compressed size of all compressed bulk request bodies.",
+		}, func() float64 {
+			uncompressed, compressed, _ := compression()
+			if compressed == 0 {
+				return 0
+			}
+			return float64(uncompressed) / float64(compressed)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bulk_compression_seconds_total",
+			Help:      "Time spent compressing bulk request bodies.",
+		}, func() float64 {
+			_, _, duration := compression()
+			return duration.Seconds()
+		}),
+	)
+}
+
+// Received counts an event which was received for the stream.
+func (m *Metrics) Received(stream string) {
+	if m == nil {
+		return
+	}
+	m.received.WithLabelValues(stream).Inc()
+}
+
+// Accepted counts an event which was accepted to be written to the stream.
+func (m *Metrics) Accepted(stream string) {
+	if m == nil {
+		return
+	}
+	m.accepted.WithLabelValues(stream).Inc()
+}
+
+// Rejected counts an event which was not accepted for one of the reasons.
+func (m *Metrics) Rejected(stream, reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(stream, reason).Inc()
+}
+
+// ObserveBulk records the size and latency of a bulk request.
+// The request failed as a whole when err is set.
+func (m *Metrics) ObserveBulk(documents, bytes int, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.bulkDocs.Observe(float64(documents))
+	m.bulkBytes.Observe(float64(bytes))
+	m.bulkTime.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// ItemError counts a document which opensearch didn't write.
+func (m *Metrics) ItemError(errorType string) {
+	if m == nil {
+		return
+	}
+	m.itemErrors.WithLabelValues(errorType).Inc()
+}
+
+// Retried counts documents which are sent again.
+func (m *Metrics) Retried(documents int) {
+	if m == nil {
+		return
+	}
+	m.retried.Add(float64(documents))
+}
+
+// Spilled counts documents which were written to the spill queue.
+func (m *Metrics) Spilled(documents int) {
+	if m == nil {
+		return
+	}
+	m.spilled.Add(float64(documents))
+}
+
+// Dropped counts a document of the stream which failed permanently and won't be written.
+func (m *Metrics) Dropped(stream, errorType string) {
+	if m == nil {
+		return
+	}
+	m.dropped.WithLabelValues(stream, errorType).Inc()
+}
+
+// Handler serves the metrics in the prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Events", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.Received("events")
+		m.Received("events")
+		m.Accepted("events")
+		m.Rejected("events", ReasonQueueFull)
+
+		assert.Equal(t, 2.0, testutil.ToFloat64(m.received.WithLabelValues("events")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.accepted.WithLabelValues("events")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.rejected.WithLabelValues("events", ReasonQueueFull)))
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.ObserveBulk(10, 2048, 50*time.Millisecond, nil)
+		m.ObserveBulk(5, 1024, time.Second, errors.New("unavailable"))
+		m.ItemError("mapper_parsing_exception")
+		m.Retried(3)
+		m.Spilled(4)
+		m.Dropped("events", "mapper_parsing_exception")
+
+		assert.Equal(t, 1, testutil.CollectAndCount(m.bulkDocs))
+		assert.Equal(t, 2, testutil.CollectAndCount(m.bulkTime))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.itemErrors.WithLabelValues("mapper_parsing_exception")))
+		assert.Equal(t, 3.0, testutil.ToFloat64(m.retried))
+		assert.Equal(t, 4.0, testutil.ToFloat64(m.spilled))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.dropped.WithLabelValues("events", "mapper_parsing_exception")))
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.WatchQueue(func() (int, int64) { return 7, 1024 })
+
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "bouncer_queue_documents 7")
+		assert.Contains(t, recorder.Body.String(), "bouncer_queue_bytes 1024")
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.WatchCompression(func() (int64, int64, time.Duration) { return 4096, 1024, 1500 * time.Millisecond })
+
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Contains(t, recorder.Body.String(), "bouncer_bulk_uncompressed_bytes_total 4096")
+		assert.Contains(t, recorder.Body.String(), "bouncer_bulk_compressed_bytes_total 1024")
+		assert.Contains(t, recorder.Body.String(), "bouncer_bulk_compression_ratio 4")
+		assert.Contains(t, recorder.Body.String(), "bouncer_bulk_compression_seconds_total 1.5")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+
+		var m *Metrics
+		assert.NotPanics(t, func() {
+			m.Received("events")
+			m.Rejected("events", ReasonInvalid)
+			m.ObserveBulk(1, 1, time.Millisecond, nil)
+			m.ItemError("error")
+			m.Spilled(1)
+			m.WatchQueue(func() (int, int64) { return 0, 0 })
+			m.WatchCompression(func() (int64, int64, time.Duration) { return 0, 0, 0 })
+		})
+	})
+}
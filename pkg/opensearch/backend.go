@@ -0,0 +1,171 @@
+package opensearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+var ErrUnsupportedBackend = errors.New("the cluster is neither opensearch nor elasticsearch 7.9 or newer")
+
+// Flavor is the product of the cluster documents are written to.
+type Flavor string
+
+const (
+	FlavorOpenSearch    Flavor = "opensearch"
+	FlavorElasticsearch Flavor = "elasticsearch"
+)
+
+// Backend is the product and version of the cluster documents are written to.
+// The zero Backend is treated like opensearch.
+type Backend struct {
+	Flavor  Flavor
+	Version string
+	Major   int64
+	Minor   int64
+}
+
+func (b Backend) String() string {
+	return fmt.Sprintf("%s %s", b.Flavor, b.Version)
+}
+
+// compatibilityHeaders reports whether requests have to ask for the API of Elasticsearch 7.
+// Elasticsearch 8 removed parts of it, e.g. the _type of bulk items, unless asked for it.
+func (b Backend) compatibilityHeaders() bool {
+	return b.Flavor == FlavorElasticsearch && b.Major >= 8
+}
+
+// templatePriority is the priority of stream templates. Elasticsearch ships templates
+// for logs-*-* and metrics-*-* with priority 100 and rejects overlapping templates of the same priority.
+func (b Backend) templatePriority() int {
+	if b.Flavor == FlavorElasticsearch {
+		return 200
+	}
+	return 100
+}
+
+// FlattenedMapping returns the mapping of an object field whose keys don't become fields of their own.
+// opensearch supports flat_object since 2.7, older versions store the object without indexing it.
+func (b Backend) FlattenedMapping() map[string]interface{} {
+	switch {
+	case b.Flavor == FlavorElasticsearch:
+		return map[string]interface{}{"type": "flattened"}
+	case b.Major > 2 || (b.Major == 2 && b.Minor >= 7):
+		return map[string]interface{}{"type": "flat_object"}
+	}
+	return map[string]interface{}{"type": "object", "enabled": false}
+}
+
+// backendState shares the detected Backend between all copies of a Client.
+type backendState struct {
+	mu      sync.RWMutex
+	backend Backend
+}
+
+func (s *backendState) get() Backend {
+	if s == nil {
+		return Backend{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+func (s *backendState) set(backend Backend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = backend
+}
+
+// Backend returns the Backend found by Detect, the zero Backend before.
+func (client Client) Backend() Backend {
+	return client.backend.get()
+}
+
+// Detect asks the cluster for its product and version. Once Elasticsearch 8 was detected,
+// every request of the Client carries the headers of its REST API compatibility.
+func (client Client) Detect(ctx context.Context) (Backend, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client")
+
+	request := opensearchapi.InfoRequest{}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return Backend{}, fmt.Errorf("executing info request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.IsError() {
+		analyzeBody(log, response)
+		return Backend{}, fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return Backend{}, fmt.Errorf("unable to decode cluster info: %w", err)
+	}
+
+	backend, err := parseBackend(info.Version.Distribution, info.Version.Number)
+	if err != nil {
+		return Backend{}, err
+	}
+	if client.backend != nil {
+		client.backend.set(backend)
+	}
+	return backend, nil
+}
+
+// parseBackend checks whether the bouncer can write to the cluster.
+// Elasticsearch supports data streams since 7.9, opensearch since its first version.
+func parseBackend(distribution, version string) (Backend, error) {
+	major, minor, _, err := opensearch.ParseVersion(version)
+	if err != nil {
+		return Backend{}, fmt.Errorf("%w: unknown version %q", ErrUnsupportedBackend, version)
+	}
+
+	backend := Backend{Flavor: FlavorOpenSearch, Version: version, Major: major, Minor: minor}
+	if distribution == string(FlavorOpenSearch) {
+		return backend, nil
+	}
+
+	backend.Flavor = FlavorElasticsearch
+	if major < 7 || (major == 7 && minor < 9) {
+		return Backend{}, fmt.Errorf("%w: found elasticsearch %s", ErrUnsupportedBackend, version)
+	}
+	return backend, nil
+}
+
+// compatibilityTransport sets the headers Elasticsearch 8 requires to answer like Elasticsearch 7.
+type compatibilityTransport struct {
+	next    http.RoundTripper
+	backend *backendState
+}
+
+func (t compatibilityTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !t.backend.get().compatibilityHeaders() {
+		return t.next.RoundTrip(request)
+	}
+
+	mediaType := "application/vnd.elasticsearch+json; compatible-with=7"
+	request = request.Clone(request.Context())
+	request.Header.Set("Accept", mediaType)
+	if request.Header.Get("Content-Type") != "" {
+		if strings.HasSuffix(request.URL.Path, "/_bulk") {
+			mediaType = "application/vnd.elasticsearch+x-ndjson; compatible-with=7"
+		}
+		request.Header.Set("Content-Type", mediaType)
+	}
+	return t.next.RoundTrip(request)
+}
@@ -0,0 +1,160 @@
+package opensearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Detect", func(t *testing.T) {
+		t.Parallel()
+
+		for _, test := range []struct {
+			info    string
+			backend Backend
+			err     error
+		}{
+			{
+				info:    `{"version": {"distribution": "opensearch", "number": "2.4.0"}}`,
+				backend: Backend{Flavor: FlavorOpenSearch, Version: "2.4.0", Major: 2, Minor: 4},
+			},
+			{
+				info:    `{"version": {"number": "7.17.7", "build_flavor": "default"}}`,
+				backend: Backend{Flavor: FlavorElasticsearch, Version: "7.17.7", Major: 7, Minor: 17},
+			},
+			{
+				info:    `{"version": {"number": "8.5.3", "build_flavor": "default"}}`,
+				backend: Backend{Flavor: FlavorElasticsearch, Version: "8.5.3", Major: 8, Minor: 5},
+			},
+			{
+				info: `{"version": {"number": "7.8.1", "build_flavor": "default"}}`,
+				err:  ErrUnsupportedBackend,
+			},
+		} {
+			cluster := newTestingCluster(test.info)
+			defer cluster.Close()
+
+			client := cluster.client(t)
+			backend, err := client.Detect(context.Background())
+			assert.ErrorIs(t, err, test.err, test.info)
+			assert.Equal(t, test.backend, backend, test.info)
+			assert.Equal(t, test.backend, client.Backend(), test.info)
+		}
+	})
+
+	t.Run("Compatibility Headers", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newTestingCluster(`{"version": {"number": "8.5.3", "build_flavor": "default"}}`)
+		defer cluster.Close()
+		client := cluster.client(t)
+
+		_, err := client.BulkIndex(context.Background(), []Document{testingDoc{targetIndex: "testIndex", id: "1"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "application/json", cluster.header("/_bulk", "Content-Type"))
+
+		_, err = client.Detect(context.Background())
+		assert.NoError(t, err)
+		_, err = client.BulkIndex(context.Background(), []Document{testingDoc{targetIndex: "testIndex", id: "1"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "application/vnd.elasticsearch+x-ndjson; compatible-with=7", cluster.header("/_bulk", "Content-Type"))
+		assert.Equal(t, "application/vnd.elasticsearch+json; compatible-with=7", cluster.header("/_bulk", "Accept"))
+	})
+
+	t.Run("Template Priority", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newTestingCluster(`{"version": {"number": "7.17.7", "build_flavor": "default"}}`)
+		defer cluster.Close()
+		client := cluster.client(t)
+
+		_, err := client.Detect(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), client, Stream{StreamName: "events"}))
+		assert.JSONEq(t, `{"index_patterns": ["events"], "data_stream": {}, "priority": 200}`,
+			cluster.body("/_index_template/events"))
+	})
+
+	t.Run("Flattened Mapping", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, "flattened", Backend{Flavor: FlavorElasticsearch, Major: 8}.FlattenedMapping()["type"])
+		assert.Equal(t, "flat_object", Backend{Flavor: FlavorOpenSearch, Major: 2, Minor: 7}.FlattenedMapping()["type"])
+		assert.Equal(t, map[string]interface{}{"type": "object", "enabled": false},
+			Backend{Flavor: FlavorOpenSearch, Major: 2, Minor: 4}.FlattenedMapping())
+	})
+
+	t.Run("Rejections", func(t *testing.T) {
+		t.Parallel()
+
+		result := BulkResponse{Items: []map[Action]BulkResponseItem{
+			{ActionIndex: {ID: "opensearch", Status: 503, Error: &BulkItemError{Type: "rejected_execution_exception"}}},
+			{ActionIndex: {ID: "elasticsearch", Status: 503, Error: &BulkItemError{Type: "es_rejected_execution_exception"}}},
+			{ActionIndex: {ID: "other", Status: 400, Error: &BulkItemError{Type: "mapper_parsing_exception"}}},
+		}}.Result(nil)
+		assert.Equal(t, 2, result.Rejected())
+	})
+}
+
+// testingCluster answers the info request with a fixed body, every other request
+// is acknowledged. It remembers the last request per path.
+type testingCluster struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]*http.Request
+	bodies   map[string]string
+}
+
+func newTestingCluster(info string) *testingCluster {
+	cluster := &testingCluster{requests: map[string]*http.Request{}, bodies: map[string]string{}}
+	cluster.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		cluster.mu.Lock()
+		cluster.requests[r.URL.Path] = r
+		cluster.bodies[r.URL.Path] = string(body)
+		cluster.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/":
+			_, _ = io.WriteString(w, info)
+		case r.URL.Path == "/_bulk":
+			_, _ = io.WriteString(w, `{"took": 1, "errors": false, "items": [{"index": {"_id": "1", "status": 201}}]}`)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = io.WriteString(w, `{"acknowledged": true}`)
+		}
+	}))
+	return cluster
+}
+
+func (c *testingCluster) client(t *testing.T) Client {
+	t.Helper()
+
+	client, err := NewClient(opensearch.Config{Addresses: []string{c.URL}})
+	assert.NoError(t, err)
+	return client
+}
+
+func (c *testingCluster) header(path, key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests[path].Header.Get(key)
+}
+
+func (c *testingCluster) body(path string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bodies[path]
+}
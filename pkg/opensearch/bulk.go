@@ -0,0 +1,141 @@
+package opensearch
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrorBulkItemsFailed = errors.New("opensearch failed to write documents of the bulk request")
+
+const (
+	errorTypeVersionConflict = "version_conflict_engine_exception"
+	// opensearch dropped the prefix Elasticsearch uses for rejections.
+	errorTypeRejected   = "rejected_execution_exception"
+	errorTypeEsRejected = "es_rejected_execution_exception"
+)
+
+// BulkResponse is the body opensearch replies with to a bulk request.
+type BulkResponse struct {
+	Took   int                           `json:"took"`
+	Errors bool                          `json:"errors"`
+	Items  []map[Action]BulkResponseItem `json:"items"`
+}
+
+// BulkResponseItem is the outcome of a single document of a bulk request.
+type BulkResponseItem struct {
+	Index  string         `json:"_index"`
+	ID     string         `json:"_id"`
+	Status int            `json:"status"`
+	Error  *BulkItemError `json:"error,omitempty"`
+}
+
+// BulkItemError describes why a single document of a bulk request was not written.
+type BulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+func (e BulkItemError) String() string {
+	return e.Type + ": " + e.Reason
+}
+
+// BulkResult summarises the outcome of all documents of a bulk request.
+type BulkResult struct {
+	// Succeeded is the number of documents which were written.
+	Succeeded int
+
+	// Stale is the number of versioned documents which were rejected since
+	// the same or a newer version was already written. They are no failures.
+	Stale int
+
+	// Duplicate is the number of created documents which already existed.
+	// They are no failures since they were written by an earlier request.
+	Duplicate int
+
+	// Spilled is the number of documents which were put aside to be written later
+	// since opensearch was unavailable.
+	Spilled int
+
+	// Failed contains every document which couldn't be written.
+	Failed []BulkResponseItem
+}
+
+// Result evaluates the response items for the documents which were sent with the bulk request.
+// Items are matched by their position since opensearch replies in the order of the request.
+func (b BulkResponse) Result(docs []Document) BulkResult {
+	result := BulkResult{}
+	for i, actionItem := range b.Items {
+		for _, item := range actionItem {
+			switch {
+			case item.Status < http.StatusMultipleChoices:
+				result.Succeeded++
+			case item.Status == http.StatusNotFound && item.Error == nil && i < len(docs) && ActionOf(docs[i]) == ActionDelete:
+				// the document which should be deleted didn't exist.
+				result.Succeeded++
+			case isVersionConflict(item) && i < len(docs) && VersionOf(docs[i]) > 0:
+				result.Stale++
+			case isVersionConflict(item) && i < len(docs) && ActionOf(docs[i]) == ActionCreate:
+				result.Duplicate++
+			default:
+				result.Failed = append(result.Failed, item)
+			}
+		}
+	}
+	return result
+}
+
+func isVersionConflict(item BulkResponseItem) bool {
+	return item.Status == http.StatusConflict && item.Error != nil && item.Error.Type == errorTypeVersionConflict
+}
+
+func isRejection(err *BulkItemError) bool {
+	return err != nil && (err.Type == errorTypeRejected || err.Type == errorTypeEsRejected)
+}
+
+// Rejected returns the number of documents which opensearch rejected since it was overloaded.
+func (r BulkResult) Rejected() int {
+	rejected := 0
+	for _, item := range r.Failed {
+		if item.Status == http.StatusTooManyRequests || isRejection(item.Error) {
+			rejected++
+		}
+	}
+	return rejected
+}
+
+// Retryable reports whether the item failed because opensearch was unavailable or overloaded.
+// Writing it again later can succeed.
+func (i BulkResponseItem) Retryable() bool {
+	return i.Status == 0 || i.Status == http.StatusTooManyRequests || i.Status >= http.StatusInternalServerError
+}
+
+// ErrorType returns the type of the error of the item or "unknown" if there is none.
+func (i BulkResponseItem) ErrorType() string {
+	if i.Error == nil || i.Error.Type == "" {
+		return "unknown"
+	}
+	return i.Error.Type
+}
+
+// add sums up the result of another bulk request.
+func (r *BulkResult) add(other BulkResult) {
+	r.Succeeded += other.Succeeded
+	r.Stale += other.Stale
+	r.Duplicate += other.Duplicate
+	r.Spilled += other.Spilled
+	r.Failed = append(r.Failed, other.Failed...)
+}
+
+// failedResult reports every document as failed for the same reason.
+func failedResult(docs []Document, status int, err error) BulkResult {
+	result := BulkResult{Failed: make([]BulkResponseItem, 0, len(docs))}
+	for _, doc := range docs {
+		result.Failed = append(result.Failed, BulkResponseItem{
+			Index:  doc.Index(),
+			ID:     doc.ID(),
+			Status: status,
+			Error:  &BulkItemError{Type: "bouncer_exception", Reason: err.Error()},
+		})
+	}
+	return result
+}
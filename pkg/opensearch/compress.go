@@ -0,0 +1,91 @@
+package opensearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Compression is the content encoding which is used for bulk request bodies.
+type Compression string
+
+const (
+	CompressionNone    Compression = ""
+	CompressionGzip    Compression = "gzip"
+	CompressionDeflate Compression = "deflate"
+)
+
+// compress encodes the body with the given compression and level.
+func compress(body []byte, compression Compression, level int) (*bytes.Buffer, error) {
+	buffer := bytes.NewBuffer(make([]byte, 0, len(body)/4))
+
+	var writer io.WriteCloser
+	var err error
+	switch compression {
+	case CompressionGzip:
+		writer, err = gzip.NewWriterLevel(buffer, level)
+	case CompressionDeflate:
+		// the deflate content coding is the zlib format of RFC 1950, not a raw deflate stream.
+		writer, err = zlib.NewWriterLevel(buffer, level)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// CompressionMetrics tracks how well bulk request bodies compress and how long it takes.
+// It is safe for concurrent use.
+type CompressionMetrics struct {
+	requests     atomic.Int64
+	uncompressed atomic.Int64
+	compressed   atomic.Int64
+	nanos        atomic.Int64
+}
+
+func (m *CompressionMetrics) record(uncompressed, compressed int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.Add(1)
+	m.uncompressed.Add(int64(uncompressed))
+	m.compressed.Add(int64(compressed))
+	m.nanos.Add(int64(duration))
+}
+
+// Requests returns the number of compressed request bodies.
+func (m *CompressionMetrics) Requests() int64 {
+	return m.requests.Load()
+}
+
+// Bytes returns the sum of all request body sizes before and after compression.
+func (m *CompressionMetrics) Bytes() (uncompressed, compressed int64) {
+	return m.uncompressed.Load(), m.compressed.Load()
+}
+
+// Ratio returns uncompressed divided by compressed bytes or 0 if nothing was compressed yet.
+func (m *CompressionMetrics) Ratio() float64 {
+	uncompressed, compressed := m.Bytes()
+	if compressed == 0 {
+		return 0
+	}
+	return float64(uncompressed) / float64(compressed)
+}
+
+// Duration returns the total time spent compressing.
+func (m *CompressionMetrics) Duration() time.Duration {
+	return time.Duration(m.nanos.Load())
+}
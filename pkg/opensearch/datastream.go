@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -31,6 +30,53 @@ type DataStream interface {
 	Name() string
 }
 
+// EntityDataStream can be implemented by a DataStream which additionally keeps
+// the latest state of every object in a separate index.
+type EntityDataStream interface {
+	DataStream
+
+	// EntityIndex returns the name of the index which holds one document per object.
+	EntityIndex() string
+}
+
+// Stream is a DataStream which is configured by its fields.
+type Stream struct {
+	StreamName string
+
+	// EntityIndexName enables an entity index for the stream when set.
+	EntityIndexName string
+
+	// IndexTemplate configures the index template of the stream.
+	IndexTemplate Template
+
+	// Retention configures the ISM policy of the backing indices of the stream.
+	Retention Lifecycle
+}
+
+func (s Stream) Name() string {
+	return s.StreamName
+}
+
+func (s Stream) EntityIndex() string {
+	return s.EntityIndexName
+}
+
+func (s Stream) Template() Template {
+	return s.IndexTemplate
+}
+
+func (s Stream) Lifecycle() Lifecycle {
+	return s.Retention
+}
+
+// EntityIndexOf returns the entity index of the stream or an empty string if there is none.
+func EntityIndexOf(stream DataStream) string {
+	if entityStream, ok := stream.(EntityDataStream); ok {
+		return entityStream.EntityIndex()
+	}
+	return ""
+}
+
 type EventPayload interface{}
 
 type timestampedPayload struct {
@@ -81,6 +127,12 @@ func IndexEvent(ctx context.Context, client Client, stream DataStream, event Eve
 	}
 	defer logClose(log, response.Body)
 
+	// a retry of an event which was already indexed is no failure.
+	if response.StatusCode == http.StatusConflict {
+		log.Info("event was already indexed")
+		return nil
+	}
+
 	if response.IsError() {
 		analyzeBody(log, response)
 		return ErrorNegativeStatusCode
@@ -101,60 +153,6 @@ func validateEvent(event Event) error {
 	return nil
 }
 
-// EnsureIndexTemplate makes sure that an Index Template is present and is configured in a given way.
-//
-// Note: If the configuration of an exisiting index template doesn't match the given configuration an error
-// will be returned. Currently there is no save way for us to update the index template.
-func EnsureIndexTemplate(ctx context.Context, client Client, config DataStream) error {
-	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").
-		WithValues(logFieldStream, config.Name())
-
-	streamName := config.Name()
-	exists := opensearchapi.IndicesExistsIndexTemplateRequest{
-		Name: streamName,
-	}
-	response, err := exists.Do(ctx, client)
-	if err != nil {
-		return err
-	}
-	defer logClose(log, response.Body)
-
-	if response.StatusCode == http.StatusOK {
-		log.Info("Datastream already present", "name", config.Name)
-		return nil
-	}
-
-	bJson, err := json.Marshal([]string{streamName})
-	if err != nil {
-		log.Error(err, "unexpected error when marshalling index patterns slice to json")
-		return err
-	}
-	indexTemplate := opensearchapi.IndicesPutIndexTemplateRequest{
-		Body: strings.NewReader(`{
-			"index_patterns": ` + string(bJson) + `,
-			"data_stream": {},
-			"priority": 100
-		}
-		`),
-		Name: streamName,
-	}
-
-	response, err = indexTemplate.Do(ctx, client)
-	if err != nil {
-		log.Error(err, "error when executing request")
-		return err
-	}
-	defer logClose(log, response.Body)
-
-	if response.IsError() {
-		analyzeBody(log, response)
-		log.Info("unexpected status code", "statusCode", response.StatusCode)
-		err = fmt.Errorf("unexpected response status code")
-	}
-
-	return nil
-}
-
 // analyzeBody dumps the reponse body to the log.
 // sometimes opensearch replies with helpful error messages which can be useful when debugging.
 func analyzeBody(log logr.Logger, response *opensearchapi.Response) {
@@ -163,7 +161,7 @@ func analyzeBody(log logr.Logger, response *opensearchapi.Response) {
 		if err != nil {
 			log.Error(err, "failed reading opensearch response")
 		}
-		fields := append([]string{"payload", string(bodyBytes)})
+		fields := []string{"payload", string(bodyBytes)}
 		log.Info("elastic error resposne dump", "payload", fields)
 	}
 }
@@ -0,0 +1,225 @@
+package fake
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// operation is a single write of a document.
+type operation struct {
+	action      string
+	index       string
+	id          string
+	version     int64
+	versionType string
+	source      map[string]interface{}
+	// upsert creates the document of an update which doesn't exist yet.
+	upsert bool
+}
+
+// result is the outcome of an operation, which is an item of the bulk response.
+type result struct {
+	Index   string       `json:"_index"`
+	ID      string       `json:"_id"`
+	Version int64        `json:"_version,omitempty"`
+	Result  string       `json:"result,omitempty"`
+	Status  int          `json:"status"`
+	Error   *resultError `json:"error,omitempty"`
+}
+
+type resultError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+func failed(op operation, status int, errorType, reason string) result {
+	return result{Index: op.index, ID: op.id, Status: status, Error: &resultError{Type: errorType, Reason: reason}}
+}
+
+func (s *Server) create(w http.ResponseWriter, body []byte, name, id string) {
+	op := operation{action: "create", index: name, id: id}
+	if err := json.Unmarshal(body, &op.source); err != nil || op.source == nil {
+		writeError(w, http.StatusBadRequest, "mapper_parsing_exception", "failed to parse the document")
+		return
+	}
+	item := s.write(op)
+	if item.Error != nil {
+		writeError(w, item.Status, item.Error.Type, item.Error.Reason)
+		return
+	}
+	writeJSON(w, item.Status, item)
+}
+
+func (s *Server) bulk(w http.ResponseWriter, body []byte, defaultIndex string) {
+	operations, err := parseBulk(body, defaultIndex)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	items := make([]interface{}, 0, len(operations))
+	failures := false
+	for _, op := range operations {
+		item, injected := s.itemFault(op)
+		if !injected {
+			item = s.write(op)
+		}
+		failures = failures || item.Error != nil
+		items = append(items, map[string]result{op.action: item})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"took": 1, "errors": failures, "items": items})
+}
+
+// parseBulk reads the action and source lines of a bulk body.
+func parseBulk(body []byte, defaultIndex string) ([]operation, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	var operations []operation
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var actions map[string]struct {
+			Index       string `json:"_index"`
+			ID          string `json:"_id"`
+			Version     int64  `json:"version"`
+			VersionType string `json:"version_type"`
+		}
+		if err := json.Unmarshal([]byte(line), &actions); err != nil || len(actions) != 1 {
+			return nil, fmt.Errorf("malformed action/metadata line [%s]", line)
+		}
+		for action, meta := range actions {
+			op := operation{action: action, index: meta.Index, id: meta.ID, version: meta.Version, versionType: meta.VersionType}
+			if op.index == "" {
+				op.index = defaultIndex
+			}
+			switch action {
+			case "create", "index", "update":
+			case "delete":
+				operations = append(operations, op)
+				continue
+			default:
+				return nil, fmt.Errorf("malformed action/metadata line [%s], unknown action [%s]", line, action)
+			}
+
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("the source of the %s action of [%s] is missing", action, op.id)
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &op.source); err != nil {
+				return nil, fmt.Errorf("malformed source of [%s]: %w", op.id, err)
+			}
+			if action == "update" {
+				op.upsert, _ = op.source["doc_as_upsert"].(bool)
+				op.source, _ = op.source["doc"].(map[string]interface{})
+			}
+			operations = append(operations, op)
+		}
+	}
+	return operations, scanner.Err()
+}
+
+// write applies the operation like opensearch. Data streams only accept created documents with an @timestamp.
+func (s *Server) write(op operation) result {
+	if op.index == "" || op.index != strings.ToLower(op.index) || strings.HasPrefix(op.index, "_") {
+		return failed(op, http.StatusBadRequest, "invalid_index_name_exception", fmt.Sprintf("Invalid index name [%s]", op.index))
+	}
+	target := s.target(op.index)
+	if target.dataStream {
+		if op.action != "create" {
+			return failed(op, http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("only write ops with an op_type of create are allowed in data streams, index [%s]", op.index))
+		}
+		if _, ok := op.source["@timestamp"]; !ok {
+			return failed(op, http.StatusBadRequest, "mapper_parsing_exception", "data stream timestamp field [@timestamp] is missing")
+		}
+	}
+	if op.id == "" && op.action != "create" && op.action != "index" {
+		return failed(op, http.StatusBadRequest, "action_request_validation_exception", "id is missing")
+	}
+	if op.id == "" {
+		s.nextID++
+		op.id = fmt.Sprintf("fake-%d", s.nextID)
+	}
+
+	outcome := result{Index: target.name(op.index), ID: op.id}
+	existing, exists := target.docs[op.id]
+	external := op.versionType == "external" && op.version > 0
+	if exists && external && existing.version >= op.version {
+		return conflict(outcome, fmt.Sprintf("[%s]: version conflict, current version [%d] is higher or equal to the one provided [%d]",
+			op.id, existing.version, op.version))
+	}
+	version := int64(1)
+	switch {
+	case external:
+		version = op.version
+	case exists:
+		version = existing.version + 1
+	}
+
+	switch op.action {
+	case "create":
+		if exists {
+			return conflict(outcome, fmt.Sprintf("[%s]: version conflict, document already exists (current version [%d])", op.id, existing.version))
+		}
+		target.docs[op.id] = &document{source: op.source, version: version}
+		outcome.Result, outcome.Status = "created", http.StatusCreated
+	case "index":
+		target.docs[op.id] = &document{source: op.source, version: version}
+		outcome.Result, outcome.Status = "created", http.StatusCreated
+		if exists {
+			outcome.Result, outcome.Status = "updated", http.StatusOK
+		}
+	case "update":
+		if !exists && !op.upsert {
+			return failed(op, http.StatusNotFound, "document_missing_exception", fmt.Sprintf("[%s]: document missing", op.id))
+		}
+		merged := map[string]interface{}{}
+		if exists {
+			for key, value := range existing.source {
+				merged[key] = value
+			}
+		}
+		for key, value := range op.source {
+			merged[key] = value
+		}
+		target.docs[op.id] = &document{source: merged, version: version}
+		outcome.Result, outcome.Status = "created", http.StatusCreated
+		if exists {
+			outcome.Result, outcome.Status = "updated", http.StatusOK
+		}
+	case "delete":
+		if !exists {
+			outcome.Result, outcome.Status = "not_found", http.StatusNotFound
+			return outcome
+		}
+		delete(target.docs, op.id)
+		outcome.Result, outcome.Status = "deleted", http.StatusOK
+	}
+	outcome.Version = version
+	return outcome
+}
+
+func conflict(outcome result, reason string) result {
+	outcome.Status = http.StatusConflict
+	outcome.Error = &resultError{Type: "version_conflict_engine_exception", Reason: reason}
+	return outcome
+}
+
+// target returns the index or data stream with the given name, which is created if it doesn't exist yet.
+// A data stream is created when a data stream template matches the name.
+func (s *Server) target(name string) *index {
+	if existing, ok := s.indices[name]; ok {
+		return existing
+	}
+	created := &index{docs: map[string]*document{}}
+	if template, ok := s.streamTemplate(name); ok {
+		created.dataStream, created.template, created.generation = true, template, 1
+	}
+	s.indices[name] = created
+	return created
+}
@@ -0,0 +1,259 @@
+// Package fake is an in-process opensearch for tests. It implements the endpoints the bouncer uses,
+// which are index templates, data streams, _create and _bulk, and keeps their state in memory.
+// Failures and latency can be injected to test how clients cope with them.
+package fake
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Option which can be applied to Options.
+type Option = func(options *Options)
+
+// WithVersion configures the distribution, opensearch or elasticsearch, and version the server reports.
+func WithVersion(distribution, version string) Option {
+	return func(options *Options) {
+		options.Distribution = distribution
+		options.Version = version
+	}
+}
+
+type Options struct {
+	Distribution string
+	Version      string
+}
+
+// InitWithDefaults initialises Options with default values for each setting.
+func (o *Options) InitWithDefaults() {
+	o.Distribution = "opensearch"
+	o.Version = "2.11.0"
+}
+
+// ApplyOptions iterates over []Option and applies every single one of them.
+func (o *Options) ApplyOptions(options []Option) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// Server is a fake opensearch cluster. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	options Options
+
+	mu        sync.Mutex
+	templates map[string]map[string]interface{}
+	indices   map[string]*index
+	faults    []*faultState
+	items     []*itemFaultState
+	requests  map[string]int
+	nextID    int
+}
+
+// index is a regular index or a data stream.
+type index struct {
+	dataStream bool
+	template   string
+	generation int
+	docs       map[string]*document
+}
+
+// name is the name of the index items are written to, which is the current backing index of data streams.
+func (i *index) name(name string) string {
+	if !i.dataStream {
+		return name
+	}
+	return backingIndex(name, i.generation)
+}
+
+type document struct {
+	source  map[string]interface{}
+	version int64
+}
+
+// New starts a Server, which has to be closed by the caller.
+func New(options ...Option) *Server {
+	o := Options{}
+	o.InitWithDefaults()
+	o.ApplyOptions(options)
+
+	s := &Server{
+		options:   o,
+		templates: map[string]map[string]interface{}{},
+		indices:   map[string]*index{},
+		requests:  map[string]int{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.Method+" "+r.URL.Path]++
+	fault := s.fault(r)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(fault.Latency):
+			}
+		}
+		if fault.Status != 0 {
+			fault.write(w)
+			return
+		}
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/":
+		s.info(w)
+	case r.URL.Path == "/_cluster/health":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"cluster_name": "fake", "status": "green"})
+	case segments[0] == "_index_template" && len(segments) <= 2:
+		s.indexTemplate(w, r, body, strings.Join(segments[1:], ""))
+	case segments[0] == "_data_stream" && len(segments) <= 2:
+		s.dataStream(w, r, strings.Join(segments[1:], ""))
+	case r.URL.Path == "/_bulk" && isWrite(r):
+		s.bulk(w, body, "")
+	case len(segments) == 2 && segments[1] == "_bulk" && isWrite(r):
+		s.bulk(w, body, segments[0])
+	case len(segments) == 3 && segments[1] == "_create" && isWrite(r):
+		s.create(w, body, segments[0], segments[2])
+	case len(segments) == 2 && segments[1] == "_rollover" && r.Method == http.MethodPost:
+		s.rollover(w, segments[0])
+	default:
+		writeError(w, http.StatusNotImplemented, "unsupported_operation_exception",
+			"the fake doesn't implement "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) info(w http.ResponseWriter) {
+	version := map[string]interface{}{"number": s.options.Version}
+	if s.options.Distribution == "opensearch" {
+		version["distribution"] = "opensearch"
+	} else {
+		version["build_flavor"] = "default"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"name": "fake", "cluster_name": "fake", "version": version})
+}
+
+// Requests returns how often the path was requested with the method, failed requests included.
+func (s *Server) Requests(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method+" "+path]
+}
+
+// Template returns the stored index template with the given name.
+func (s *Server) Template(name string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	template, ok := s.templates[name]
+	if !ok {
+		return nil, false
+	}
+	return copyJSON(template), true
+}
+
+// DataStreams returns the names of the data streams, ordered by name.
+func (s *Server) DataStreams() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dataStreamNames()
+}
+
+// Document returns the source and version of a document of an index or data stream.
+func (s *Server) Document(name, id string) (map[string]interface{}, int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, ok := s.indices[name]
+	if !ok {
+		return nil, 0, false
+	}
+	doc, ok := index.docs[id]
+	if !ok {
+		return nil, 0, false
+	}
+	return copyJSON(doc.source), doc.version, true
+}
+
+// Documents returns the number of documents of an index or data stream.
+func (s *Server) Documents(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index, ok := s.indices[name]; ok {
+		return len(index.docs)
+	}
+	return 0
+}
+
+func isWrite(r *http.Request) bool {
+	return r.Method == http.MethodPost || r.Method == http.MethodPut
+}
+
+// readBody returns the body of the request, which is decoded when it is compressed.
+func readBody(r *http.Request) ([]byte, error) {
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case "deflate":
+		zl, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zl.Close()
+		reader = zl
+	}
+	return io.ReadAll(reader)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error the way opensearch does.
+func writeError(w http.ResponseWriter, status int, errorType, reason string) {
+	cause := map[string]interface{}{"type": errorType, "reason": reason}
+	writeJSON(w, status, map[string]interface{}{
+		"error":  map[string]interface{}{"root_cause": []interface{}{cause}, "type": errorType, "reason": reason},
+		"status": status,
+	})
+}
+
+// copyJSON deep copies a decoded JSON object.
+func copyJSON(value map[string]interface{}) map[string]interface{} {
+	encoded, _ := json.Marshal(value)
+	var copied map[string]interface{}
+	_ = json.Unmarshal(encoded, &copied)
+	return copied
+}
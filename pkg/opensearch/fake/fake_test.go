@@ -0,0 +1,315 @@
+package fake_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch/fake"
+	opensearchgo "github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	stream := opensearch.Stream{StreamName: "events", IndexTemplate: opensearch.Template{
+		Mappings: map[string]interface{}{
+			"properties": map[string]interface{}{"message": map[string]interface{}{"type": "text"}},
+		},
+		Settings: map[string]interface{}{"number_of_shards": 1},
+	}}
+
+	t.Run("Index Template", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := newServer(t)
+		assert.NoError(t, opensearch.EnsureIndexTemplate(context.Background(), client, stream))
+		assert.NoError(t, opensearch.EnsureIndexTemplate(context.Background(), client, stream))
+		assert.Equal(t, 1, server.Requests(http.MethodPut, "/_index_template/events"))
+
+		diff, err := opensearch.DiffIndexTemplate(context.Background(), client, stream)
+		assert.NoError(t, err)
+		assert.Empty(t, diff)
+		template, ok := server.Template("events")
+		assert.True(t, ok)
+		assert.Equal(t, []interface{}{"events"}, template["index_patterns"])
+
+		// templates of data streams can't be deleted.
+		assert.NoError(t, opensearch.IndexEvent(context.Background(), client, stream, event("1")))
+		_, err = opensearch.DeleteIndexTemplate(context.Background(), client, "events")
+		assert.Error(t, err)
+
+		assert.Equal(t, http.StatusOK, do(t, client, http.MethodDelete, "/_data_stream/events", nil))
+		deleted, err := opensearch.DeleteIndexTemplate(context.Background(), client, "events")
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		_, exists, err := opensearch.GetIndexTemplate(context.Background(), client, "events")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Index Event", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := newServer(t)
+		assert.NoError(t, opensearch.EnsureIndexTemplate(context.Background(), client, stream))
+		assert.NoError(t, opensearch.IndexEvent(context.Background(), client, stream, event("1")))
+		// a retry of an indexed event is no failure.
+		assert.NoError(t, opensearch.IndexEvent(context.Background(), client, stream, event("1")))
+
+		assert.Equal(t, []string{"events"}, server.DataStreams())
+		assert.Equal(t, 1, server.Documents("events"))
+		doc, version, ok := server.Document("events", "1")
+		assert.True(t, ok)
+		assert.Equal(t, int64(1), version)
+		assert.Contains(t, doc, "@timestamp")
+
+		server.Fail(fake.Fault{Path: "/events/_create/*", Status: http.StatusTooManyRequests, Times: 1})
+		assert.ErrorIs(t, opensearch.IndexEvent(context.Background(), client, stream, event("2")), opensearch.ErrorNegativeStatusCode)
+		assert.NoError(t, opensearch.IndexEvent(context.Background(), client, stream, event("2")))
+		assert.Equal(t, 2, server.Requests(http.MethodPut, "/events/_create/2"))
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := newServer(t)
+		server.FailItems(fake.ItemFault{ID: "2", Times: 1})
+
+		docs := []opensearch.Document{
+			doc{id: "1", version: 2, message: "first"},
+			doc{id: "2", version: 1, message: "second"},
+			doc{id: "1", version: 1, message: "stale"},
+		}
+		result, err := client.BulkIndex(context.Background(), docs)
+		assert.ErrorIs(t, err, opensearch.ErrorBulkItemsFailed)
+		assert.Equal(t, 1, result.Succeeded)
+		assert.Equal(t, 1, result.Stale)
+		assert.Equal(t, 1, result.Rejected())
+		assert.True(t, result.Failed[0].Retryable())
+
+		result, err = client.BulkIndex(context.Background(), docs[1:2])
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Succeeded)
+
+		source, version, _ := server.Document("entities", "1")
+		assert.Equal(t, "first", source["message"])
+		assert.Equal(t, int64(2), version)
+		assert.Equal(t, 2, server.Documents("entities"))
+		assert.Empty(t, server.DataStreams())
+	})
+
+	t.Run("Compressed Bulk", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := newServer(t, opensearch.WithCompression(opensearch.CompressionGzip, 0, 0))
+		result, err := client.BulkIndex(context.Background(), []opensearch.Document{doc{id: "1", message: "compressed"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Succeeded)
+		assert.Equal(t, 1, server.Documents("entities"))
+	})
+
+	t.Run("Ordered Chunks", func(t *testing.T) {
+		t.Parallel()
+
+		server := fake.New()
+		t.Cleanup(server.Close)
+		// the request of the first write is delayed, the later write of the same document mustn't overtake it.
+		client, err := opensearch.NewClient(opensearchgo.Config{
+			Addresses: []string{server.URL},
+			Transport: delayingTransport{match: "first", latency: 100 * time.Millisecond},
+		}, opensearch.WithMaxRequestDocuments(1), opensearch.WithBulkConcurrency(2))
+		assert.NoError(t, err)
+
+		result, err := client.BulkIndex(context.Background(), []opensearch.Document{
+			doc{id: "1", message: "first"},
+			doc{id: "1", message: "second"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, result.Succeeded)
+		assert.Equal(t, 2, server.Requests(http.MethodPost, "/_bulk"))
+		source, _, _ := server.Document("entities", "1")
+		assert.Equal(t, "second", source["message"])
+	})
+
+	t.Run("Cancelled Chunks", func(t *testing.T) {
+		t.Parallel()
+
+		server := fake.New()
+		t.Cleanup(server.Close)
+		client, err := opensearch.NewClient(opensearchgo.Config{
+			Addresses: []string{server.URL},
+			Transport: delayingTransport{match: "slow", latency: 200 * time.Millisecond},
+		}, opensearch.WithMaxRequestDocuments(1), opensearch.WithBulkConcurrency(1))
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		// the chunks waiting for the slow one fail once the context is done instead of being sent one by one.
+		result, err := client.BulkIndex(ctx, []opensearch.Document{
+			doc{id: "1", message: "slow"},
+			doc{id: "2", message: "slow"},
+			doc{id: "3", message: "slow"},
+		})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Len(t, result.Failed, 3)
+		assert.Less(t, time.Since(started), 400*time.Millisecond)
+	})
+
+	t.Run("Request Faults", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := newServer(t)
+		server.Fail(fake.Fault{Method: http.MethodPost, Path: "/_bulk", Status: http.StatusTooManyRequests, Times: 1})
+
+		docs := []opensearch.Document{doc{id: "1", message: "first"}}
+		result, err := client.BulkIndex(context.Background(), docs)
+		assert.ErrorIs(t, err, opensearch.ErrorNegativeStatusCode)
+		assert.Equal(t, 1, result.Rejected())
+
+		_, err = client.BulkIndex(context.Background(), docs)
+		assert.NoError(t, err)
+
+		server.Fail(fake.Fault{Path: "/_bulk", Latency: 200 * time.Millisecond})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = client.BulkIndex(ctx, docs)
+		assert.Error(t, err)
+
+		server.Heal()
+		_, err = client.BulkIndex(context.Background(), docs)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, server.Requests(http.MethodPost, "/_bulk"))
+	})
+
+	t.Run("Data Streams", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := newServer(t)
+		assert.Equal(t, http.StatusBadRequest, do(t, client, http.MethodPut, "/_data_stream/events", nil))
+
+		assert.NoError(t, opensearch.EnsureIndexTemplate(context.Background(), client, stream))
+		assert.Equal(t, http.StatusOK, do(t, client, http.MethodPut, "/_data_stream/events", nil))
+		assert.NoError(t, opensearch.RolloverDataStream(context.Background(), client, "events"))
+
+		var streams struct {
+			DataStreams []struct {
+				Name       string `json:"name"`
+				Generation int    `json:"generation"`
+				Template   string `json:"template"`
+			} `json:"data_streams"`
+		}
+		assert.Equal(t, http.StatusOK, do(t, client, http.MethodGet, "/_data_stream/ev*", &streams))
+		assert.Len(t, streams.DataStreams, 1)
+		assert.Equal(t, 2, streams.DataStreams[0].Generation)
+		assert.Equal(t, "events", streams.DataStreams[0].Template)
+
+		// data streams only accept created documents.
+		result, err := client.BulkIndex(context.Background(), []opensearch.Document{
+			doc{id: "1", index: "events", message: "versioned", version: 1},
+		})
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, result.Failed[0].Status)
+
+		assert.Equal(t, http.StatusOK, do(t, client, http.MethodDelete, "/_data_stream/events", nil))
+		assert.Empty(t, server.DataStreams())
+		assert.Equal(t, http.StatusNotFound, do(t, client, http.MethodGet, "/_data_stream/events", nil))
+	})
+
+	t.Run("Elasticsearch", func(t *testing.T) {
+		t.Parallel()
+
+		server := fake.New(fake.WithVersion("elasticsearch", "8.11.0"))
+		t.Cleanup(server.Close)
+		client, err := opensearch.NewClient(opensearchgo.Config{Addresses: []string{server.URL}})
+		assert.NoError(t, err)
+
+		backend, err := client.Detect(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, opensearch.FlavorElasticsearch, backend.Flavor)
+
+		health, err := client.ClusterHealth(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, opensearch.ClusterGreen, health)
+	})
+}
+
+func newServer(t *testing.T, options ...opensearch.BulkOption) (*fake.Server, opensearch.Client) {
+	t.Helper()
+
+	server := fake.New()
+	t.Cleanup(server.Close)
+	client, err := opensearch.NewClient(opensearchgo.Config{Addresses: []string{server.URL}}, options...)
+	assert.NoError(t, err)
+	return server, client
+}
+
+func event(id string) opensearch.Event {
+	return opensearch.Event{ID: id, Payload: map[string]interface{}{"message": "hello"}}
+}
+
+// do sends a request the client has no API for and decodes the response into target when it is set.
+func do(t *testing.T, client opensearch.Client, method, path string, target interface{}) int {
+	t.Helper()
+
+	request, err := http.NewRequest(method, path, nil)
+	assert.NoError(t, err)
+	response, err := client.Perform(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	if target != nil {
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(target))
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	return response.StatusCode
+}
+
+// delayingTransport delays the requests whose body contains match.
+type delayingTransport struct {
+	match   string
+	latency time.Duration
+}
+
+func (d delayingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		if bytes.Contains(body, []byte(d.match)) {
+			time.Sleep(d.latency)
+		}
+	}
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// doc is written to the entities index unless another one is set.
+type doc struct {
+	id      string
+	index   string
+	version int64
+	message string
+}
+
+func (d doc) ID() string { return d.id }
+
+func (d doc) Index() string {
+	if d.index == "" {
+		return "entities"
+	}
+	return d.index
+}
+
+func (d doc) Data() interface{} { return map[string]interface{}{"message": d.message} }
+
+func (d doc) Action() opensearch.Action { return opensearch.ActionIndex }
+
+func (d doc) Version() int64 { return d.version }
@@ -0,0 +1,152 @@
+package fake
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+// Fault fails or delays the requests it matches. opensearch-go retries requests failing with 502, 503
+// and 504 on its own, so every retry is matched again.
+type Fault struct {
+	// Method and Path select the requests, empty ones match every request.
+	// Path is a pattern of path.Match, e.g. "/*/_create/*".
+	Method string
+	Path   string
+
+	// Latency delays the matched requests.
+	Latency time.Duration
+
+	// Status is returned instead of handling the request, the request is handled when it is 0.
+	Status int
+	// Body is returned with the Status. An error of the Status is returned when it is empty.
+	Body string
+
+	// Times is the number of requests which are matched, every request is matched when it is 0.
+	Times int
+}
+
+// ItemFault fails the items of bulk requests it matches. The other items are written.
+type ItemFault struct {
+	// Index and ID select the items, empty ones match every item. Index is a pattern of path.Match.
+	Index string
+	ID    string
+
+	// Status of the failed items, which is 429 when it is 0.
+	Status int
+	// Type and Reason of the error of the failed items. Type defaults to the one opensearch uses for the Status.
+	Type   string
+	Reason string
+
+	// Times is the number of items which are matched, every item is matched when it is 0.
+	Times int
+}
+
+type faultState struct {
+	Fault
+	remaining int
+}
+
+type itemFaultState struct {
+	ItemFault
+	remaining int
+}
+
+// Fail injects the fault. Faults are matched in the order they were injected, only the first one applies.
+func (s *Server) Fail(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &faultState{Fault: fault, remaining: fault.Times})
+}
+
+// FailItems injects the fault of bulk items. Faults are matched in the order they were injected,
+// only the first one applies.
+func (s *Server) FailItems(fault ItemFault) {
+	if fault.Status == 0 {
+		fault.Status = http.StatusTooManyRequests
+	}
+	if fault.Type == "" {
+		fault.Type = errorType(fault.Status)
+	}
+	if fault.Reason == "" {
+		fault.Reason = "injected failure"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append(s.items, &itemFaultState{ItemFault: fault, remaining: fault.Times})
+}
+
+// Heal removes every injected fault.
+func (s *Server) Heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults, s.items = nil, nil
+}
+
+// fault returns the fault of the request and uses it up.
+func (s *Server) fault(r *http.Request) *Fault {
+	for i, fault := range s.faults {
+		if fault.Method != "" && fault.Method != r.Method {
+			continue
+		}
+		if matched, _ := path.Match(fault.Path, r.URL.Path); fault.Path != "" && !matched {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.remaining--
+			if fault.remaining == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		matched := fault.Fault
+		return &matched
+	}
+	return nil
+}
+
+// itemFault returns the failed item of the operation when an ItemFault matches it and uses the fault up.
+func (s *Server) itemFault(op operation) (result, bool) {
+	for i, fault := range s.items {
+		if fault.ID != "" && fault.ID != op.id {
+			continue
+		}
+		if matched, _ := path.Match(fault.Index, op.index); fault.Index != "" && !matched {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.remaining--
+			if fault.remaining == 0 {
+				s.items = append(s.items[:i:i], s.items[i+1:]...)
+			}
+		}
+		return failed(op, fault.Status, fault.Type, fault.Reason), true
+	}
+	return result{}, false
+}
+
+func (f Fault) write(w http.ResponseWriter) {
+	if f.Body == "" {
+		writeError(w, f.Status, errorType(f.Status), "injected failure")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.Status)
+	_, _ = io.WriteString(w, f.Body)
+}
+
+// errorType returns the type of error opensearch answers with the status.
+func errorType(status int) string {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "rejected_execution_exception"
+	case http.StatusConflict:
+		return "version_conflict_engine_exception"
+	case http.StatusBadRequest:
+		return "mapper_parsing_exception"
+	case http.StatusServiceUnavailable:
+		return "cluster_block_exception"
+	}
+	return "exception"
+}
@@ -0,0 +1,247 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+func (s *Server) indexTemplate(w http.ResponseWriter, r *http.Request, body []byte, name string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		names := s.matching(name, func(name string) bool { _, ok := s.templates[name]; return ok }, s.templateNames())
+		if len(names) == 0 && name != "" {
+			writeError(w, http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("index template matching [%s] not found", name))
+			return
+		}
+		flat := r.URL.Query().Get("flat_settings") == "true"
+		templates := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			templates = append(templates, map[string]interface{}{"name": name, "index_template": liveTemplate(s.templates[name], flat)})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"index_templates": templates})
+	case http.MethodPut, http.MethodPost:
+		var template map[string]interface{}
+		if err := json.Unmarshal(body, &template); err != nil || name == "" {
+			writeError(w, http.StatusBadRequest, "parse_exception", "index template has to be a JSON object")
+			return
+		}
+		if patterns, _ := template["index_patterns"].([]interface{}); len(patterns) == 0 {
+			writeError(w, http.StatusBadRequest, "action_request_validation_exception", "index patterns are missing")
+			return
+		}
+		s.templates[name] = template
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case http.MethodDelete:
+		if _, ok := s.templates[name]; !ok {
+			writeError(w, http.StatusNotFound, "index_template_missing_exception", fmt.Sprintf("index_template [%s] missing", name))
+			return
+		}
+		for stream, index := range s.indices {
+			if index.dataStream && index.template == name {
+				writeError(w, http.StatusBadRequest, "illegal_argument_exception",
+					fmt.Sprintf("unable to remove composable templates [%s] as they are in use by a data streams [%s]", name, stream))
+				return
+			}
+		}
+		delete(s.templates, name)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", r.Method+" isn't allowed")
+	}
+}
+
+func (s *Server) dataStream(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		names := s.matching(name, func(name string) bool {
+			index, ok := s.indices[name]
+			return ok && index.dataStream
+		}, s.dataStreamNames())
+		if len(names) == 0 && name != "" {
+			writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+			return
+		}
+		streams := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			index := s.indices[name]
+			indices := make([]interface{}, 0, index.generation)
+			for generation := 1; generation <= index.generation; generation++ {
+				indices = append(indices, map[string]interface{}{"index_name": backingIndex(name, generation)})
+			}
+			streams = append(streams, map[string]interface{}{
+				"name":            name,
+				"timestamp_field": map[string]interface{}{"name": "@timestamp"},
+				"indices":         indices,
+				"generation":      index.generation,
+				"status":          "GREEN",
+				"template":        index.template,
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data_streams": streams})
+	case http.MethodPut:
+		if _, ok := s.indices[name]; ok {
+			writeError(w, http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("data_stream [%s] already exists", name))
+			return
+		}
+		template, ok := s.streamTemplate(name)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("no matching index template found for data stream [%s]", name))
+			return
+		}
+		s.indices[name] = &index{dataStream: true, template: template, generation: 1, docs: map[string]*document{}}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case http.MethodDelete:
+		if index, ok := s.indices[name]; !ok || !index.dataStream {
+			writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+			return
+		}
+		delete(s.indices, name)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", r.Method+" isn't allowed")
+	}
+}
+
+// rollover starts a new backing index of a data stream.
+func (s *Server) rollover(w http.ResponseWriter, name string) {
+	index, ok := s.indices[name]
+	if !ok || !index.dataStream {
+		writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+		return
+	}
+	index.generation++
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"acknowledged":        true,
+		"rolled_over":         true,
+		"old_index":           backingIndex(name, index.generation-1),
+		"new_index":           backingIndex(name, index.generation),
+		"shards_acknowledged": true,
+	})
+}
+
+// streamTemplate returns the data stream template of the highest priority which matches the name.
+func (s *Server) streamTemplate(name string) (string, bool) {
+	found, priority := "", -1
+	for templateName, template := range s.templates {
+		if _, ok := template["data_stream"]; !ok {
+			continue
+		}
+		patterns, _ := template["index_patterns"].([]interface{})
+		for _, pattern := range patterns {
+			pattern, _ := pattern.(string)
+			if matched, _ := path.Match(pattern, name); !matched {
+				continue
+			}
+			p, _ := template["priority"].(float64)
+			if int(p) > priority {
+				found, priority = templateName, int(p)
+			}
+		}
+	}
+	return found, priority >= 0
+}
+
+// matching returns the names matching the wildcard pattern, all names when it is empty.
+func (s *Server) matching(pattern string, exists func(string) bool, names []string) []string {
+	if pattern == "" || pattern == "*" {
+		return names
+	}
+	if !strings.Contains(pattern, "*") {
+		if exists(pattern) {
+			return []string{pattern}
+		}
+		return nil
+	}
+	var matched []string
+	for _, name := range names {
+		if ok, _ := path.Match(pattern, name); ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+func (s *Server) templateNames() []string {
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) dataStreamNames() []string {
+	var names []string
+	for name, index := range s.indices {
+		if index.dataStream {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// liveTemplate returns the template the way opensearch reports it. The timestamp field of data streams
+// is added and settings are prefixed with "index." and turned into strings.
+func liveTemplate(stored map[string]interface{}, flat bool) map[string]interface{} {
+	template := copyJSON(stored)
+	if _, ok := template["data_stream"]; ok {
+		template["data_stream"] = map[string]interface{}{"timestamp_field": map[string]interface{}{"name": "@timestamp"}}
+	}
+	if _, ok := template["composed_of"]; !ok {
+		template["composed_of"] = []interface{}{}
+	}
+	inner, _ := template["template"].(map[string]interface{})
+	settings, _ := inner["settings"].(map[string]interface{})
+	if settings == nil {
+		return template
+	}
+	flattened := map[string]interface{}{}
+	flattenSettings(flattened, "", settings)
+	if flat {
+		inner["settings"] = flattened
+		return template
+	}
+	nested := map[string]interface{}{}
+	for key, value := range flattened {
+		parts := strings.Split(key, ".")
+		parent := nested
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := parent[part].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				parent[part] = child
+			}
+			parent = child
+		}
+		parent[parts[len(parts)-1]] = value
+	}
+	inner["settings"] = nested
+	return template
+}
+
+func flattenSettings(flat map[string]interface{}, prefix string, settings map[string]interface{}) {
+	for key, value := range settings {
+		key = prefix + key
+		if prefix == "" && !strings.HasPrefix(key, "index.") && key != "index" {
+			key = "index." + key
+		}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flattenSettings(flat, key+".", value)
+		case string:
+			flat[key] = value
+		default:
+			encoded, _ := json.Marshal(value)
+			flat[key] = string(encoded)
+		}
+	}
+}
+
+func backingIndex(name string, generation int) string {
+	return fmt.Sprintf(".ds-%s-%06d", name, generation)
+}
@@ -0,0 +1,53 @@
+package opensearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// ClusterStatus is the health of an opensearch cluster.
+type ClusterStatus string
+
+const (
+	// ClusterGreen means that all shards are allocated.
+	ClusterGreen ClusterStatus = "green"
+	// ClusterYellow means that all primary shards but not all replicas are allocated.
+	ClusterYellow ClusterStatus = "yellow"
+	// ClusterRed means that some primary shards are not allocated.
+	ClusterRed ClusterStatus = "red"
+)
+
+// Writable reports whether documents can be written to a cluster with this status.
+func (s ClusterStatus) Writable() bool {
+	return s == ClusterGreen || s == ClusterYellow
+}
+
+// ClusterHealth returns the health of the opensearch cluster.
+// An error is returned when the cluster is not reachable.
+func (client Client) ClusterHealth(ctx context.Context) (ClusterStatus, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client")
+
+	request := opensearchapi.ClusterHealthRequest{}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("executing cluster health request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.IsError() {
+		analyzeBody(log, response)
+		return "", fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+
+	var health struct {
+		Status ClusterStatus `json:"status"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&health); err != nil {
+		return "", fmt.Errorf("unable to decode cluster health: %w", err)
+	}
+	return health.Status, nil
+}
@@ -0,0 +1,344 @@
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/go-logr/logr"
+)
+
+var ErrInvalidLifecycle = errors.New("invalid lifecycle")
+
+var (
+	timeUnitPattern = regexp.MustCompile(`^[0-9]+(d|h|m|s|ms)$`)
+	sizePattern     = regexp.MustCompile(`^[0-9]+(b|kb|mb|gb|tb|pb)$`)
+)
+
+// Lifecycle is the rollover and retention of the backing indices of a stream.
+// Ages use the time units of opensearch, e.g. "7d", sizes its byte units, e.g. "50gb".
+// The zero Lifecycle doesn't manage the indices at all.
+type Lifecycle struct {
+	// RolloverSize and RolloverAge start a new backing index once the current one reaches either of them.
+	RolloverSize string `json:"rollover_size,omitempty"`
+	RolloverAge  string `json:"rollover_age,omitempty"`
+
+	// WarmAfter is the age after which indices are made read only and moved to the nodes of WarmAllocation.
+	WarmAfter      string            `json:"warm_after,omitempty"`
+	WarmAllocation map[string]string `json:"warm_allocation,omitempty"`
+
+	// DeleteAfter is the age after which indices are deleted.
+	DeleteAfter string `json:"delete_after,omitempty"`
+
+	// Priority of the ism_template of the policy, which decides between policies matching the same indices.
+	// defaultISMPriority is used when it is 0.
+	Priority int `json:"priority,omitempty"`
+}
+
+// defaultISMPriority is the priority of the ism_template when the Lifecycle doesn't configure one.
+const defaultISMPriority = 100
+
+// Empty reports whether the Lifecycle doesn't configure anything.
+func (l Lifecycle) Empty() bool {
+	return l.RolloverSize == "" && l.RolloverAge == "" && l.WarmAfter == "" && l.DeleteAfter == ""
+}
+
+// Validate checks the units of the ages and sizes.
+func (l Lifecycle) Validate() error {
+	for _, age := range []string{l.RolloverAge, l.WarmAfter, l.DeleteAfter} {
+		if age != "" && !timeUnitPattern.MatchString(age) {
+			return fmt.Errorf("%w: %q is no age like 7d", ErrInvalidLifecycle, age)
+		}
+	}
+	if l.RolloverSize != "" && !sizePattern.MatchString(l.RolloverSize) {
+		return fmt.Errorf("%w: %q is no size like 50gb", ErrInvalidLifecycle, l.RolloverSize)
+	}
+	if l.Priority < 0 {
+		return fmt.Errorf("%w: priority %d is negative", ErrInvalidLifecycle, l.Priority)
+	}
+	return nil
+}
+
+// LifecycleDataStream can be implemented by a DataStream whose backing indices are managed by an ISM policy.
+type LifecycleDataStream interface {
+	DataStream
+
+	// Lifecycle returns the rollover and retention of the backing indices.
+	Lifecycle() Lifecycle
+}
+
+// LifecycleOf returns the lifecycle of the stream or the zero Lifecycle if there is none.
+func LifecycleOf(stream DataStream) Lifecycle {
+	if lifecycleStream, ok := stream.(LifecycleDataStream); ok {
+		return lifecycleStream.Lifecycle()
+	}
+	return Lifecycle{}
+}
+
+// PolicyID returns the ID of the ISM policy which manages the backing indices of the stream.
+func PolicyID(stream DataStream) string {
+	return stream.Name() + "-lifecycle"
+}
+
+// ISMPolicy returns the body of the ISM policy of the stream. It applies itself to new backing indices
+// of the stream through its ism_template, EnsureISMPolicy attaches it to the existing ones.
+func ISMPolicy(stream DataStream) (map[string]interface{}, error) {
+	lifecycle := LifecycleOf(stream)
+	if err := lifecycle.Validate(); err != nil {
+		return nil, err
+	}
+
+	var states []map[string]interface{}
+	hot := state("hot")
+	rollover := map[string]interface{}{}
+	if lifecycle.RolloverSize != "" {
+		rollover["min_size"] = lifecycle.RolloverSize
+	}
+	if lifecycle.RolloverAge != "" {
+		rollover["min_index_age"] = lifecycle.RolloverAge
+	}
+	if len(rollover) > 0 {
+		hot["actions"] = []interface{}{map[string]interface{}{"rollover": rollover}}
+	}
+	states = append(states, hot)
+
+	if lifecycle.WarmAfter != "" {
+		transition(hot, "warm", lifecycle.WarmAfter)
+		warm := state("warm")
+		actions := []interface{}{map[string]interface{}{"read_only": map[string]interface{}{}}}
+		if len(lifecycle.WarmAllocation) > 0 {
+			actions = append(actions, map[string]interface{}{
+				"allocation": map[string]interface{}{"require": lifecycle.WarmAllocation, "wait_for": false},
+			})
+		}
+		warm["actions"] = actions
+		states = append(states, warm)
+	}
+
+	if lifecycle.DeleteAfter != "" {
+		transition(states[len(states)-1], "delete", lifecycle.DeleteAfter)
+		deleted := state("delete")
+		deleted["actions"] = []interface{}{map[string]interface{}{"delete": map[string]interface{}{}}}
+		states = append(states, deleted)
+	}
+
+	priority := lifecycle.Priority
+	if priority == 0 {
+		priority = defaultISMPriority
+	}
+	policy := map[string]interface{}{
+		"policy": map[string]interface{}{
+			"description":   fmt.Sprintf("lifecycle of the data stream %s, managed by index-bouncer", stream.Name()),
+			"default_state": "hot",
+			"states":        states,
+			"ism_template": []interface{}{map[string]interface{}{
+				"index_patterns": []string{".ds-" + stream.Name() + "-*"},
+				"priority":       priority,
+			}},
+		},
+	}
+
+	// the policy is decoded the same way as the live one to compare them.
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode ism policy: %w", err)
+	}
+	return decodeJSON(bytes.NewReader(encoded))
+}
+
+func state(name string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "actions": []interface{}{}, "transitions": []interface{}{}}
+}
+
+func transition(from map[string]interface{}, to, minIndexAge string) {
+	from["transitions"] = []interface{}{map[string]interface{}{
+		"state_name": to,
+		"conditions": map[string]interface{}{"min_index_age": minIndexAge},
+	}}
+}
+
+// EnsureISMPolicy creates or updates the ISM policy of the stream when it has a Lifecycle.
+// A created policy is attached to the existing backing indices of the stream, since its ism_template
+// only applies to new ones. Updates of a policy don't need that, opensearch applies them to the managed indices.
+// Elasticsearch has no ISM, so nothing happens there.
+func EnsureISMPolicy(ctx context.Context, client Client, stream DataStream) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").
+		WithValues(logFieldStream, stream.Name())
+
+	if LifecycleOf(stream).Empty() {
+		return nil
+	}
+	if client.Backend().Flavor == FlavorElasticsearch {
+		log.Info("the lifecycle of the stream is ignored since elasticsearch has no ISM")
+		return nil
+	}
+
+	desired, err := ISMPolicy(stream)
+	if err != nil {
+		return err
+	}
+
+	path := "/_plugins/_ism/policies/" + url.PathEscape(PolicyID(stream))
+	response, err := ismRequest(ctx, client, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	var live struct {
+		SeqNo       int64                  `json:"_seq_no"`
+		PrimaryTerm int64                  `json:"_primary_term"`
+		Policy      map[string]interface{} `json:"policy"`
+	}
+	exists := response.StatusCode != http.StatusNotFound
+	err = decodeISMResponse(log, response, &live)
+	if exists && err != nil {
+		return err
+	}
+
+	if exists {
+		if containsJSON(live.Policy, desired["policy"]) {
+			log.Info("ism policy is up to date")
+			return nil
+		}
+		path += "?" + url.Values{
+			"if_seq_no":       []string{strconv.FormatInt(live.SeqNo, 10)},
+			"if_primary_term": []string{strconv.FormatInt(live.PrimaryTerm, 10)},
+		}.Encode()
+	}
+
+	body, err := json.Marshal(desired)
+	if err != nil {
+		return fmt.Errorf("unable to encode ism policy: %w", err)
+	}
+	response, err = ismRequest(ctx, client, http.MethodPut, path, body)
+	if err != nil {
+		return err
+	}
+	if err := decodeISMResponse(log, response, nil); err != nil {
+		return err
+	}
+	log.Info("ism policy was written", "policy", PolicyID(stream), "updated", exists)
+	if exists {
+		return nil
+	}
+	return attachISMPolicy(ctx, log, client, stream)
+}
+
+// attachISMPolicy makes the ISM policy manage the existing backing indices of the stream.
+// Indices which are managed by another policy already keep it.
+func attachISMPolicy(ctx context.Context, log logr.Logger, client Client, stream DataStream) error {
+	body, err := json.Marshal(map[string]string{"policy_id": PolicyID(stream)})
+	if err != nil {
+		return fmt.Errorf("unable to encode ism request: %w", err)
+	}
+	path := "/_plugins/_ism/add/" + url.PathEscape(".ds-"+stream.Name()+"-*")
+	response, err := ismRequest(ctx, client, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	// the stream has no backing indices yet.
+	if response.StatusCode == http.StatusNotFound {
+		logClose(log, response.Body)
+		return nil
+	}
+	var added struct {
+		UpdatedIndices int `json:"updated_indices"`
+		FailedIndices  []struct {
+			IndexName string `json:"index_name"`
+			Reason    string `json:"reason"`
+		} `json:"failed_indices"`
+	}
+	if err := decodeISMResponse(log, response, &added); err != nil {
+		return err
+	}
+	for _, failed := range added.FailedIndices {
+		log.Info("ism policy wasn't attached to backing index", "index", failed.IndexName, "reason", failed.Reason)
+	}
+	log.Info("ism policy was attached to the existing backing indices", "indices", added.UpdatedIndices)
+	return nil
+}
+
+// EnsureStream makes sure that the ISM policy and the index template of the stream match their configuration.
+func EnsureStream(ctx context.Context, client Client, stream DataStream, options ...TemplateOption) error {
+	if err := EnsureISMPolicy(ctx, client, stream); err != nil {
+		return err
+	}
+	return EnsureIndexTemplate(ctx, client, stream, options...)
+}
+
+// ismRequest sends a request to the ISM plugin, which opensearch-go has no API for.
+func ismRequest(ctx context.Context, client Client, method, path string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := client.Perform(request)
+	if err != nil {
+		return nil, fmt.Errorf("executing ism request failed: %w", err)
+	}
+	return response, nil
+}
+
+// decodeISMResponse decodes the body of a successful response into target unless it is nil.
+func decodeISMResponse(log logr.Logger, response *http.Response, target interface{}) error {
+	defer logClose(log, response.Body)
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(response.Body)
+		log.Info("unexpected status code", "statusCode", response.StatusCode, "payload", string(body))
+		return fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+	if target == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("unable to decode ism response: %w", err)
+	}
+	return nil
+}
+
+// containsJSON reports whether live contains every field of desired. Arrays have to match element by element.
+// opensearch adds fields to stored policies, e.g. the retry settings of every action.
+func containsJSON(live, desired interface{}) bool {
+	switch typed := desired.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range typed {
+			if !containsJSON(liveMap[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		liveSlice, ok := live.([]interface{})
+		if !ok || len(liveSlice) != len(typed) {
+			return false
+		}
+		for i := range typed {
+			if !containsJSON(liveSlice[i], typed[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	fields := map[string]string{}
+	flattenFields(fields, "value", desired)
+	liveFields := map[string]string{}
+	flattenFields(liveFields, "value", live)
+	return fields["value"] == liveFields["value"]
+}
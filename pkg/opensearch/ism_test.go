@@ -0,0 +1,185 @@
+package opensearch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestISMPolicy(t *testing.T) {
+	t.Parallel()
+
+	stream := Stream{StreamName: "events", Retention: Lifecycle{
+		RolloverSize:   "50gb",
+		RolloverAge:    "1d",
+		WarmAfter:      "7d",
+		WarmAllocation: map[string]string{"temp": "warm"},
+		DeleteAfter:    "30d",
+	}}
+
+	t.Run("Policy", func(t *testing.T) {
+		t.Parallel()
+
+		policy, err := ISMPolicy(stream)
+		assert.NoError(t, err)
+		encoded, _ := json.Marshal(policy)
+		assert.JSONEq(t, `{"policy": {
+			"description": "lifecycle of the data stream events, managed by index-bouncer",
+			"default_state": "hot",
+			"states": [
+				{
+					"name": "hot",
+					"actions": [{"rollover": {"min_size": "50gb", "min_index_age": "1d"}}],
+					"transitions": [{"state_name": "warm", "conditions": {"min_index_age": "7d"}}]
+				},
+				{
+					"name": "warm",
+					"actions": [{"read_only": {}}, {"allocation": {"require": {"temp": "warm"}, "wait_for": false}}],
+					"transitions": [{"state_name": "delete", "conditions": {"min_index_age": "30d"}}]
+				},
+				{"name": "delete", "actions": [{"delete": {}}], "transitions": []}
+			],
+			"ism_template": [{"index_patterns": [".ds-events-*"], "priority": 100}]
+		}}`, string(encoded))
+
+		_, err = ISMPolicy(Stream{StreamName: "events", Retention: Lifecycle{DeleteAfter: "30 days"}})
+		assert.ErrorIs(t, err, ErrInvalidLifecycle)
+
+		policy, err = ISMPolicy(Stream{StreamName: "events", Retention: Lifecycle{DeleteAfter: "30d", Priority: 200}})
+		assert.NoError(t, err)
+		template := policy["policy"].(map[string]interface{})["ism_template"].([]interface{})[0]
+		assert.Equal(t, json.Number("200"), template.(map[string]interface{})["priority"])
+	})
+
+	t.Run("Create And Update", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newISMCluster(t)
+		assert.NoError(t, EnsureISMPolicy(context.Background(), cluster.client, stream))
+		assert.Equal(t, 1, cluster.puts)
+		assert.Empty(t, cluster.lastQuery)
+		// the created policy manages the existing backing indices as well.
+		assert.Equal(t, 1, cluster.adds)
+
+		// opensearch adds fields to the stored policy which mustn't cause updates.
+		assert.NoError(t, EnsureISMPolicy(context.Background(), cluster.client, stream))
+		assert.Equal(t, 1, cluster.puts)
+
+		changed := stream
+		changed.Retention.DeleteAfter = "90d"
+		assert.NoError(t, EnsureISMPolicy(context.Background(), cluster.client, changed))
+		assert.Equal(t, 2, cluster.puts)
+		assert.Equal(t, "if_primary_term=1&if_seq_no=0", cluster.lastQuery)
+		assert.Equal(t, 1, cluster.adds)
+	})
+
+	t.Run("Without Lifecycle", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newISMCluster(t)
+		assert.NoError(t, EnsureISMPolicy(context.Background(), cluster.client, Stream{StreamName: "events"}))
+		assert.Equal(t, 0, cluster.requests)
+	})
+
+	t.Run("Elasticsearch", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newISMCluster(t)
+		cluster.client.backend.set(Backend{Flavor: FlavorElasticsearch, Version: "8.5.3", Major: 8, Minor: 5})
+		assert.NoError(t, EnsureISMPolicy(context.Background(), cluster.client, stream))
+		assert.Equal(t, 0, cluster.requests)
+	})
+}
+
+// ismCluster stores the policy events-lifecycle and returns it with the fields opensearch adds.
+type ismCluster struct {
+	client Client
+
+	mu        sync.Mutex
+	policy    map[string]interface{}
+	seqNo     int
+	puts      int
+	adds      int
+	requests  int
+	lastQuery string
+}
+
+func newISMCluster(t *testing.T) *ismCluster {
+	t.Helper()
+
+	cluster := &ismCluster{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cluster.mu.Lock()
+		defer cluster.mu.Unlock()
+		cluster.requests++
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/_plugins/_ism/add/.ds-events-*" && r.Method == http.MethodPost {
+			cluster.adds++
+			_, _ = io.WriteString(w, `{"updated_indices": 2, "failures": false, "failed_indices": []}`)
+			return
+		}
+		if r.URL.Path != "/_plugins/_ism/policies/events-lifecycle" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if cluster.policy == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"_id": "events-lifecycle", "_seq_no": cluster.seqNo, "_primary_term": 1, "policy": cluster.policy,
+			})
+		case http.MethodPut:
+			if cluster.policy != nil && r.URL.Query().Get("if_seq_no") != strconv.Itoa(cluster.seqNo) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			var body struct {
+				Policy map[string]interface{} `json:"policy"`
+			}
+			raw, _ := io.ReadAll(r.Body)
+			assert.NoError(t, json.Unmarshal(raw, &body))
+			if cluster.policy != nil {
+				cluster.seqNo++
+			}
+			cluster.policy = withStoredFields(body.Policy)
+			cluster.puts++
+			cluster.lastQuery = r.URL.RawQuery
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"_id": "events-lifecycle"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(opensearch.Config{Addresses: []string{server.URL}})
+	assert.NoError(t, err)
+	cluster.client = client
+	return cluster
+}
+
+// withStoredFields adds the retry settings of actions and the timestamps opensearch stores with a policy.
+func withStoredFields(policy map[string]interface{}) map[string]interface{} {
+	policy["policy_id"] = "events-lifecycle"
+	policy["last_updated_time"] = 1670000000000
+	policy["error_notification"] = nil
+	for _, state := range policy["states"].([]interface{}) {
+		for _, action := range state.(map[string]interface{})["actions"].([]interface{}) {
+			action.(map[string]interface{})["retry"] = map[string]interface{}{"count": 3, "backoff": "exponential", "delay": "1m"}
+		}
+	}
+	for _, template := range policy["ism_template"].([]interface{}) {
+		template.(map[string]interface{})["last_updated_time"] = 1670000000000
+	}
+	return policy
+}
@@ -0,0 +1,67 @@
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// GetMappings returns the mappings of every index behind name, e.g. of the backing indices of a data stream.
+// It is empty when name doesn't exist.
+func GetMappings(ctx context.Context, client Client, name string) (map[string]map[string]interface{}, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").WithValues("index", name)
+
+	request := opensearchapi.IndicesGetMappingRequest{Index: []string{name}}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("executing get mapping request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.StatusCode == http.StatusNotFound {
+		return map[string]map[string]interface{}{}, nil
+	}
+	if response.IsError() {
+		analyzeBody(log, response)
+		return nil, fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+
+	var indices map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&indices); err != nil {
+		return nil, fmt.Errorf("unable to decode mappings: %w", err)
+	}
+	mappings := make(map[string]map[string]interface{}, len(indices))
+	for index, mapping := range indices {
+		mappings[index] = mapping.Mappings
+	}
+	return mappings, nil
+}
+
+// PutMapping adds the fields of mappings to every index behind name. Fields can't be changed once they are mapped.
+func PutMapping(ctx context.Context, client Client, name string, mappings map[string]interface{}) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").WithValues("index", name)
+
+	body, err := json.Marshal(mappings)
+	if err != nil {
+		return fmt.Errorf("unable to encode mappings: %w", err)
+	}
+	request := opensearchapi.IndicesPutMappingRequest{Index: []string{name}, Body: bytes.NewReader(body)}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("executing put mapping request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.IsError() {
+		analyzeBody(log, response)
+		return fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+	return nil
+}
@@ -6,8 +6,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/tracing"
 	"github.com/opensearch-project/opensearch-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrOptNoAddress = errors.New("no address was specified")
@@ -15,19 +27,78 @@ var ErrOptNoAddress = errors.New("no address was specified")
 // Client is the way to communicate with a configured opensearch.
 type Client struct {
 	*opensearch.Client
+
+	// BulkOptions configure how BulkIndex splits documents into requests.
+	// Settings which are not set fall back to their defaults.
+	BulkOptions BulkOptions
+
+	// CompressionMetrics records the compression of bulk request bodies when set.
+	CompressionMetrics *CompressionMetrics
+
+	// Metrics records size, latency and item errors of bulk requests when set.
+	Metrics *metrics.Metrics
+
+	// TracerProvider records a span per bulk request when set.
+	// The span is linked to the spans of the TracedDocuments it carries.
+	TracerProvider trace.TracerProvider
+
+	// inFlight limits the bulk requests which are sent at the same time when set.
+	inFlight chan struct{}
+
+	// active counts the bulk requests which are sent right now when set.
+	active *atomic.Int64
+
+	// backend is the cluster found by Detect when set.
+	backend *backendState
 }
 
-// NewWithDefaultClient creates a Client based on the given http.Client.
-func NewWithDefaultClient() (Client, error) {
-	client, err := opensearch.NewDefaultClient()
+// NewWithDefaultClient creates a Client for the cluster at OPENSEARCH_URL.
+func NewWithDefaultClient(options ...BulkOption) (Client, error) {
+	return NewClient(opensearch.Config{}, options...)
+}
+
+// NewClient creates a Client from the config. The product check of opensearch-go is disabled
+// since it refuses Elasticsearch 8, Detect checks the cluster instead.
+func NewClient(config opensearch.Config, options ...BulkOption) (Client, error) {
+	backend := &backendState{}
+	next := config.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	config.Transport = compatibilityTransport{next: next, backend: backend}
+	config.UseResponseCheckOnly = true
+
+	client, err := opensearch.NewClient(config)
 	if err != nil {
 		return Client{}, err
 	}
+
+	bulkOptions := BulkOptions{}
+	bulkOptions.InitWithDefaults()
+	bulkOptions.ApplyOptions(options)
+	var inFlight chan struct{}
+	if bulkOptions.MaxInFlight > 0 {
+		inFlight = make(chan struct{}, bulkOptions.MaxInFlight)
+	}
+
 	return Client{
-		client,
+		Client:             client,
+		BulkOptions:        bulkOptions,
+		CompressionMetrics: &CompressionMetrics{},
+		inFlight:           inFlight,
+		active:             &atomic.Int64{},
+		backend:            backend,
 	}, nil
 }
 
+// InFlight returns the number of bulk requests which are sent right now.
+func (client Client) InFlight() int64 {
+	if client.active == nil {
+		return 0
+	}
+	return client.active.Load()
+}
+
 // Document describes an indexable set of data.
 type Document interface {
 	// ID should return a unique ID for this document.
@@ -40,20 +111,238 @@ type Document interface {
 	Data() interface{}
 }
 
-// BulkIndex send multiple docuemnts to opensearch
-func (client Client) BulkIndex(ctx context.Context, docs []Document) error {
-	dataBytes, err := Bulk(docs).MarshalJSONToBuffer()
+// Action is the bulk action which is used to write a Document.
+type Action string
+
+const (
+	// ActionIndex adds the document or replaces an existing one with the same ID.
+	ActionIndex Action = "index"
+	// ActionCreate adds the document and fails if one with the same ID already exists.
+	ActionCreate Action = "create"
+	// ActionDelete removes the document with the given ID. Data is ignored.
+	ActionDelete Action = "delete"
+	// ActionUpdate merges Data into the existing document or creates it if it doesn't exist yet.
+	ActionUpdate Action = "update"
+)
+
+// ActionDocument can be implemented by a Document which should not be written with ActionIndex.
+type ActionDocument interface {
+	Document
+
+	// Action should return the bulk action for this document.
+	Action() Action
+}
+
+// ActionOf returns the bulk action which is used for the given document.
+func ActionOf(doc Document) Action {
+	if actionDoc, ok := doc.(ActionDocument); ok && actionDoc.Action() != "" {
+		return actionDoc.Action()
+	}
+	return ActionIndex
+}
+
+// VersionedDocument can be implemented by a Document which carries an external version.
+// Index and delete actions of a versioned document are rejected by opensearch when
+// a document with a higher or equal version was written before.
+type VersionedDocument interface {
+	Document
+
+	// Version should return the external version or 0 if the document is not versioned.
+	Version() int64
+}
+
+// VersionOf returns the external version which is sent for the given document.
+// Only index and delete actions support external versions, so 0 is returned for every other action.
+func VersionOf(doc Document) int64 {
+	versionDoc, ok := doc.(VersionedDocument)
+	if !ok {
+		return 0
+	}
+
+	switch ActionOf(doc) {
+	case ActionIndex, ActionDelete:
+		return versionDoc.Version()
+	}
+	return 0
+}
+
+// TracedDocument can be implemented by a Document which was received within a trace.
+type TracedDocument interface {
+	Document
+
+	// SpanContext should return the span the document was received in.
+	SpanContext() trace.SpanContext
+}
+
+// SpanContextOf returns the span the document was received in or an invalid span context.
+func SpanContextOf(doc Document) trace.SpanContext {
+	if tracedDoc, ok := doc.(TracedDocument); ok {
+		return tracedDoc.SpanContext()
+	}
+	return trace.SpanContext{}
+}
+
+// BulkIndex send multiple docuemnts to opensearch.
+// The documents are split into multiple requests which respect the BulkOptions of the client.
+// These requests are sent concurrently, unless they write the same document. Then they are sent in order.
+func (client Client) BulkIndex(ctx context.Context, docs []Document) (BulkResult, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client")
+	options := client.BulkOptions.withDefaults()
+
+	chunks, err := Bulk(docs).Split(options.MaxRequestBytes, options.MaxRequestDocuments)
 	if err != nil {
-		return fmt.Errorf("unable to encode bulk %w", err)
+		return BulkResult{}, fmt.Errorf("unable to encode bulk %w", err)
+	}
+
+	results := make([]BulkResult, len(chunks))
+	errs := make([]error, len(chunks))
+	deps := dependencies(chunks)
+	done := make([]chan struct{}, len(chunks))
+	semaphore := make(chan struct{}, options.Concurrency)
+	wg := sync.WaitGroup{}
+	for i, chunk := range chunks {
+		done[i] = make(chan struct{})
+		if chunk.Oversized && options.RejectOversized {
+			log.Info("rejected document exceeding the maximum request size",
+				"index", chunk.Docs[0].Index(), "id", chunk.Docs[0].ID(), "bytes", chunk.Body.Len())
+			results[i] = failedResult(chunk.Docs, http.StatusRequestEntityTooLarge, ErrorDocumentTooLarge)
+			close(done[i])
+			continue
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			// the chunk isn't sent, so all of its documents failed.
+			results[i], errs[i] = failedResult(chunk.Docs, 0, ctx.Err()), ctx.Err()
+			close(done[i])
+			continue
+		}
+		wg.Add(1)
+		go func(i int, chunk BulkChunk) {
+			defer wg.Done()
+			defer close(done[i])
+			defer func() { <-semaphore }()
+			// a chunk which writes a document of an earlier chunk waits for it, so that its writes stay in order.
+			for _, dep := range deps[i] {
+				<-done[dep]
+			}
+			results[i], errs[i] = client.bulkRequest(ctx, log, options, chunk)
+		}(i, chunk)
 	}
+	wg.Wait()
 
-	// TODO: check response
-	_, err = client.Bulk(dataBytes)
+	result := BulkResult{}
+	for i := range chunks {
+		result.add(results[i])
+		if errs[i] != nil && err == nil {
+			err = errs[i]
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("error during bulk index request to opensearch")
+		return result, err
 	}
 
-	return nil
+	if len(result.Failed) > 0 {
+		log.Info("bulk request contained failed items", "failed", len(result.Failed),
+			"firstError", result.Failed[0].Error)
+		return result, fmt.Errorf("%w: %d of %d", ErrorBulkItemsFailed, len(result.Failed), len(docs))
+	}
+	return result, nil
+}
+
+// bulkRequest sends a single chunk to opensearch.
+// When the request fails as a whole, all documents of the chunk are reported as failed.
+func (client Client) bulkRequest(ctx context.Context, log logr.Logger, options BulkOptions, chunk BulkChunk) (BulkResult, error) {
+	body, headers, err := client.encodeBody(options, chunk.Body)
+	if err != nil {
+		err = fmt.Errorf("unable to compress bulk: %w", err)
+		return failedResult(chunk.Docs, 0, err), err
+	}
+
+	if client.inFlight != nil {
+		select {
+		case client.inFlight <- struct{}{}:
+			defer func() { <-client.inFlight }()
+		case <-ctx.Done():
+			return failedResult(chunk.Docs, 0, ctx.Err()), ctx.Err()
+		}
+	}
+
+	spans := make([]trace.SpanContext, 0, len(chunk.Docs))
+	for _, doc := range chunk.Docs {
+		spans = append(spans, SpanContextOf(doc))
+	}
+	ctx, span := tracing.Tracer(client.TracerProvider).Start(ctx, "opensearch.bulk",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(tracing.Links(spans)...),
+		trace.WithAttributes(
+			attribute.Int("bulk.documents", len(chunk.Docs)),
+			attribute.Int("bulk.bytes", chunk.Body.Len()),
+		))
+	defer span.End()
+
+	if client.active != nil {
+		client.active.Add(1)
+		defer client.active.Add(-1)
+	}
+
+	start := time.Now()
+	result, err := client.sendBulk(ctx, log, body, headers, chunk.Docs)
+	client.Metrics.ObserveBulk(len(chunk.Docs), chunk.Body.Len(), time.Since(start), err)
+
+	span.SetAttributes(attribute.Int("bulk.failed", len(result.Failed)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
+}
+
+// sendBulk sends the encoded body of a chunk and evaluates the response.
+func (client Client) sendBulk(ctx context.Context, log logr.Logger, body io.Reader, headers map[string]string, docs []Document) (BulkResult, error) {
+	response, err := client.Bulk(body, client.Bulk.WithContext(ctx), client.Bulk.WithHeader(headers))
+	if err != nil {
+		err = fmt.Errorf("error during bulk index request to opensearch: %w", err)
+		return failedResult(docs, 0, err), err
+	}
+	defer logClose(log, response.Body)
+
+	if response.IsError() {
+		analyzeBody(log, response)
+		return failedResult(docs, response.StatusCode, ErrorNegativeStatusCode), ErrorNegativeStatusCode
+	}
+
+	var bulkResponse BulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&bulkResponse); err != nil {
+		err = fmt.Errorf("unable to decode bulk response: %w", err)
+		return failedResult(docs, response.StatusCode, err), err
+	}
+
+	for _, actionItem := range bulkResponse.Items {
+		for _, item := range actionItem {
+			if item.Error != nil {
+				client.Metrics.ItemError(item.Error.Type)
+			}
+		}
+	}
+	return bulkResponse.Result(docs), nil
+}
+
+// encodeBody compresses the body if configured and returns the headers which describe the encoding.
+func (client Client) encodeBody(options BulkOptions, body *bytes.Buffer) (io.Reader, map[string]string, error) {
+	if options.Compression == CompressionNone || body.Len() < options.CompressionMinBytes {
+		return body, nil, nil
+	}
+
+	start := time.Now()
+	compressed, err := compress(body.Bytes(), options.Compression, options.CompressionLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	client.CompressionMetrics.record(body.Len(), compressed.Len(), time.Since(start))
+
+	return compressed, map[string]string{"Content-Encoding": string(options.Compression)}, nil
 }
 
 type Bulk []Document
@@ -61,19 +350,63 @@ type Bulk []Document
 func (b Bulk) MarshalJSONToBuffer() (*bytes.Buffer, error) {
 	buffer := bytes.NewBuffer(make([]byte, 0, 512))
 	for _, doc := range b {
-		buffer.WriteString(`{"index": {"_index":"`)
-		buffer.WriteString(doc.Index())
-		buffer.WriteString(`", "_id": "`)
-		buffer.WriteString(doc.ID())
-		buffer.WriteString(`"}`)
-		buffer.WriteRune('\n')
-
-		encoder := json.NewEncoder(buffer)
-		err := encoder.Encode(doc.Data())
-		if err != nil {
+		if err := writeDocument(buffer, doc); err != nil {
 			return nil, err
 		}
 	}
 
 	return buffer, nil
 }
+
+// writeString writes the value as JSON string. Index and id are given by clients, so quotes and newlines
+// have to be escaped to keep them from adding actions of their own to the bulk body.
+func writeString(buffer *bytes.Buffer, value string) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buffer.Write(encoded)
+	return nil
+}
+
+// writeDocument writes the action line and, if required, the source line of a document.
+func writeDocument(buffer *bytes.Buffer, doc Document) error {
+	action := ActionOf(doc)
+	buffer.WriteString(`{"`)
+	buffer.WriteString(string(action))
+	buffer.WriteString(`": {"_index":`)
+	if err := writeString(buffer, doc.Index()); err != nil {
+		return err
+	}
+	buffer.WriteString(`, "_id": `)
+	if err := writeString(buffer, doc.ID()); err != nil {
+		return err
+	}
+	if version := VersionOf(doc); version > 0 {
+		buffer.WriteString(`, "version": `)
+		buffer.WriteString(strconv.FormatInt(version, 10))
+		buffer.WriteString(`, "version_type": "external"`)
+	}
+	buffer.WriteString(`}}`)
+	buffer.WriteRune('\n')
+
+	// delete actions don't have a source line
+	if action == ActionDelete {
+		return nil
+	}
+
+	if action == ActionUpdate {
+		data, err := json.Marshal(doc.Data())
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(`{"doc": `)
+		buffer.Write(data)
+		buffer.WriteString(`, "doc_as_upsert": true}`)
+		buffer.WriteRune('\n')
+		return nil
+	}
+
+	encoder := json.NewEncoder(buffer)
+	return encoder.Encode(doc.Data())
+}
@@ -1,10 +1,23 @@
 package opensearch
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/opensearch-project/opensearch-go/v2"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestOpenSearch(t *testing.T) {
@@ -26,7 +39,7 @@ func TestOpenSearch(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(
 			t,
-			[]byte("{\"index\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}\n{\"foo\":\"bar\"}\n"),
+			[]byte("{\"index\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}}\n{\"foo\":\"bar\"}\n"),
 			b.Bytes(),
 		)
 		fmt.Print(b)
@@ -43,13 +56,87 @@ func TestOpenSearch(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(
 			t,
-			[]byte("{\"index\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}\n{\"foo\":\"bar\"}\n"+
-				"{\"index\": {\"_index\":\"testIndex\", \"_id\": \"second\"}\n{\"foo\":\"bar\"}\n"),
+			[]byte("{\"index\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}}\n{\"foo\":\"bar\"}\n"+
+				"{\"index\": {\"_index\":\"testIndex\", \"_id\": \"second\"}}\n{\"foo\":\"bar\"}\n"),
 			b.Bytes(),
 		)
 		fmt.Print(b)
 	})
 
+	t.Run("Delete And Create", func(t *testing.T) {
+		t.Parallel()
+
+		deleteDoc := testingDoc
+		deleteDoc.action = ActionDelete
+		createDoc := testingDoc
+		createDoc.id = "second"
+		createDoc.action = ActionCreate
+
+		b, err := Bulk([]Document{deleteDoc, createDoc}).MarshalJSONToBuffer()
+
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			[]byte("{\"delete\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}}\n"+
+				"{\"create\": {\"_index\":\"testIndex\", \"_id\": \"second\"}}\n{\"foo\":\"bar\"}\n"),
+			b.Bytes(),
+		)
+	})
+
+	t.Run("Versioned", func(t *testing.T) {
+		t.Parallel()
+
+		indexDoc := testingDoc
+		indexDoc.version = 7
+		createDoc := indexDoc
+		createDoc.action = ActionCreate
+
+		b, err := Bulk([]Document{indexDoc, createDoc}).MarshalJSONToBuffer()
+
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			[]byte("{\"index\": {\"_index\":\"testIndex\", \"_id\": \"testingID\", \"version\": 7, \"version_type\": \"external\"}}\n{\"foo\":\"bar\"}\n"+
+				"{\"create\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}}\n{\"foo\":\"bar\"}\n"),
+			b.Bytes(),
+		)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		t.Parallel()
+
+		updateDoc := testingDoc
+		updateDoc.action = ActionUpdate
+
+		b, err := Bulk([]Document{updateDoc}).MarshalJSONToBuffer()
+
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			[]byte("{\"update\": {\"_index\":\"testIndex\", \"_id\": \"testingID\"}}\n"+
+				"{\"doc\": {\"foo\":\"bar\"}, \"doc_as_upsert\": true}\n"),
+			b.Bytes(),
+		)
+	})
+
+	t.Run("Escaped Metadata", func(t *testing.T) {
+		t.Parallel()
+
+		escapedDoc := testingDoc
+		escapedDoc.id = "x\"}}\n{\"delete\":{\"_index\":\"secret\",\"_id\":\"y"
+		escapedDoc.action = ActionDelete
+
+		b, err := Bulk([]Document{escapedDoc}).MarshalJSONToBuffer()
+		assert.NoError(t, err)
+
+		// the id stays part of a single action line.
+		lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		assert.Len(t, lines, 1)
+		var action map[Action]BulkResponseItem
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &action))
+		assert.Equal(t, map[Action]BulkResponseItem{ActionDelete: {Index: "testIndex", ID: escapedDoc.id}}, action)
+	})
+
 	t.Run("Test Broken Data", func(t *testing.T) {
 		t.Parallel()
 
@@ -68,6 +155,9 @@ type testingDoc struct {
 	targetIndex string
 	id          string
 	data        map[string]interface{}
+	action      Action
+	version     int64
+	span        trace.SpanContext
 }
 
 func (t testingDoc) ID() string {
@@ -81,3 +171,211 @@ func (t testingDoc) Index() string {
 func (t testingDoc) Data() interface{} {
 	return t.data
 }
+
+func (t testingDoc) Action() Action {
+	return t.action
+}
+
+func (t testingDoc) Version() int64 {
+	return t.version
+}
+
+func (t testingDoc) SpanContext() trace.SpanContext {
+	return t.span
+}
+
+func TestBulkResponse(t *testing.T) {
+	t.Parallel()
+
+	versioned := testingDoc{id: "versioned", targetIndex: "testIndex", version: 3}
+	unversioned := testingDoc{id: "unversioned", targetIndex: "testIndex", action: ActionCreate}
+	failed := testingDoc{id: "failed", targetIndex: "testIndex"}
+	conflict := &BulkItemError{Type: "version_conflict_engine_exception", Reason: "conflict"}
+	parsing := &BulkItemError{Type: "mapper_parsing_exception", Reason: "failed to parse"}
+
+	response := BulkResponse{
+		Errors: true,
+		Items: []map[Action]BulkResponseItem{
+			{ActionIndex: {ID: "versioned", Status: 201}},
+			{ActionIndex: {ID: "versioned", Status: 409, Error: conflict}},
+			{ActionCreate: {ID: "unversioned", Status: 409, Error: conflict}},
+			{ActionIndex: {ID: "failed", Status: 400, Error: parsing}},
+		},
+	}
+
+	result := response.Result([]Document{versioned, versioned, unversioned, failed})
+
+	assert.Equal(t, 1, result.Succeeded)
+	assert.Equal(t, 1, result.Stale)
+	assert.Equal(t, 1, result.Duplicate)
+	assert.Equal(t, []BulkResponseItem{{ID: "failed", Status: 400, Error: parsing}}, result.Failed)
+	assert.Equal(t, 0, result.Rejected())
+
+	rejected := BulkResponse{Items: []map[Action]BulkResponseItem{
+		{ActionIndex: {ID: "failed", Status: 429, Error: &BulkItemError{Type: "es_rejected_execution_exception"}}},
+	}}
+	assert.Equal(t, 1, rejected.Result([]Document{failed}).Rejected())
+}
+
+func TestSplit(t *testing.T) {
+	t.Parallel()
+
+	doc := func(id string, size int) Document {
+		return testingDoc{id: id, targetIndex: "testIndex", data: map[string]interface{}{
+			"foo": strings.Repeat("x", size),
+		}}
+	}
+
+	t.Run("Max Documents", func(t *testing.T) {
+		t.Parallel()
+
+		chunks, err := Bulk([]Document{doc("1", 1), doc("2", 1), doc("3", 1)}).Split(1<<20, 2)
+
+		assert.NoError(t, err)
+		assert.Len(t, chunks, 2)
+		assert.Len(t, chunks[0].Docs, 2)
+		assert.Len(t, chunks[1].Docs, 1)
+	})
+
+	t.Run("Max Bytes", func(t *testing.T) {
+		t.Parallel()
+
+		single, err := Bulk([]Document{doc("1", 100)}).MarshalJSONToBuffer()
+		assert.NoError(t, err)
+
+		chunks, err := Bulk([]Document{doc("1", 100), doc("2", 100), doc("3", 100)}).Split(2*single.Len(), 100)
+
+		assert.NoError(t, err)
+		assert.Len(t, chunks, 2)
+		assert.Len(t, chunks[0].Docs, 2)
+		assert.Equal(t, 2*single.Len(), chunks[0].Body.Len())
+		assert.False(t, chunks[0].Oversized)
+	})
+
+	t.Run("Oversized Is Isolated", func(t *testing.T) {
+		t.Parallel()
+
+		chunks, err := Bulk([]Document{doc("1", 1), doc("2", 1000), doc("3", 1)}).Split(500, 100)
+
+		assert.NoError(t, err)
+		assert.Len(t, chunks, 3)
+		assert.True(t, chunks[1].Oversized)
+		assert.Equal(t, "2", chunks[1].Docs[0].ID())
+		// the documents stay in order.
+		assert.Equal(t, "1", chunks[0].Docs[0].ID())
+		assert.Equal(t, "3", chunks[2].Docs[0].ID())
+		assert.False(t, chunks[2].Oversized)
+	})
+
+	t.Run("Dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		chunks, err := Bulk([]Document{doc("1", 1), doc("2", 1), doc("3", 1), doc("1", 1), doc("2", 1)}).Split(1<<20, 2)
+
+		assert.NoError(t, err)
+		assert.Len(t, chunks, 3)
+		assert.Equal(t, [][]int{nil, {0}, {0}}, dependencies(chunks))
+	})
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat(`{"index": {"_index":"testIndex", "_id": "testingID"}}`+"\n"+`{"foo":"bar"}`+"\n", 100)
+	options := BulkOptions{}
+	options.InitWithDefaults()
+
+	t.Run("Gzip", func(t *testing.T) {
+		t.Parallel()
+
+		client := Client{CompressionMetrics: &CompressionMetrics{}}
+		options := options
+		options.Compression = CompressionGzip
+
+		encoded, headers, err := client.encodeBody(options, bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"Content-Encoding": "gzip"}, headers)
+
+		reader, err := gzip.NewReader(encoded)
+		assert.NoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+
+		assert.Equal(t, int64(1), client.CompressionMetrics.Requests())
+		assert.Greater(t, client.CompressionMetrics.Ratio(), 10.0)
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		t.Parallel()
+
+		client := Client{}
+		options := options
+		options.Compression = CompressionDeflate
+
+		encoded, headers, err := client.encodeBody(options, bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"Content-Encoding": "deflate"}, headers)
+
+		reader, err := zlib.NewReader(encoded)
+		assert.NoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+	})
+
+	t.Run("Below Threshold", func(t *testing.T) {
+		t.Parallel()
+
+		client := Client{}
+		options := options
+		options.Compression = CompressionGzip
+		options.CompressionMinBytes = len(body) + 1
+
+		encoded, headers, err := client.encodeBody(options, bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.Nil(t, headers)
+
+		decoded, err := io.ReadAll(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+	})
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"took": 1, "errors": false, "items": [`+
+			`{"index": {"_index": "testIndex", "_id": "1", "status": 201}},`+
+			`{"index": {"_index": "testIndex", "_id": "2", "status": 201}}]}`)
+	}))
+	defer server.Close()
+
+	osClient, err := opensearch.NewClient(opensearch.Config{Addresses: []string{server.URL}})
+	assert.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	client := Client{Client: osClient, TracerProvider: provider}
+
+	_, received := provider.Tracer("test").Start(context.Background(), "received")
+	received.End()
+	docs := []Document{
+		testingDoc{targetIndex: "testIndex", id: "1", span: received.SpanContext()},
+		testingDoc{targetIndex: "testIndex", id: "2", span: received.SpanContext()},
+	}
+
+	result, err := client.BulkIndex(context.Background(), docs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Succeeded)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	bulk := spans[1]
+	assert.Equal(t, "opensearch.bulk", bulk.Name)
+	// both documents were received in the same span, so it is linked once.
+	assert.Len(t, bulk.Links, 1)
+	assert.Equal(t, received.SpanContext(), bulk.Links[0].SpanContext)
+}
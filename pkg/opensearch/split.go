@@ -0,0 +1,211 @@
+package opensearch
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+)
+
+var ErrorDocumentTooLarge = errors.New("document exceeds the maximum bulk request size")
+
+// A BulkOption which can be applied to BulkOptions.
+type BulkOption = func(options *BulkOptions)
+
+// WithMaxRequestBytes configures the maximum size of a single bulk request body.
+func WithMaxRequestBytes(maxBytes int) BulkOption {
+	return func(options *BulkOptions) {
+		options.MaxRequestBytes = maxBytes
+	}
+}
+
+// WithMaxRequestDocuments configures the maximum number of documents of a single bulk request.
+func WithMaxRequestDocuments(maxDocuments int) BulkOption {
+	return func(options *BulkOptions) {
+		options.MaxRequestDocuments = maxDocuments
+	}
+}
+
+// WithBulkConcurrency configures how many bulk requests a single BulkIndex call sends at the same time.
+func WithBulkConcurrency(concurrency int) BulkOption {
+	return func(options *BulkOptions) {
+		options.Concurrency = concurrency
+	}
+}
+
+// WithMaxInFlight configures how many bulk requests the client sends at the same time across all BulkIndex calls.
+func WithMaxInFlight(maxInFlight int) BulkOption {
+	return func(options *BulkOptions) {
+		options.MaxInFlight = maxInFlight
+	}
+}
+
+// WithRejectOversized configures whether a document which exceeds the maximum request size
+// is rejected instead of sent alone.
+func WithRejectOversized(reject bool) BulkOption {
+	return func(options *BulkOptions) {
+		options.RejectOversized = reject
+	}
+}
+
+// WithCompression configures the content encoding of bulk request bodies.
+// Bodies smaller than minBytes are sent uncompressed.
+func WithCompression(compression Compression, level, minBytes int) BulkOption {
+	return func(options *BulkOptions) {
+		options.Compression = compression
+		options.CompressionLevel = level
+		options.CompressionMinBytes = minBytes
+	}
+}
+
+type BulkOptions struct {
+	// MaxRequestBytes is the maximum size of a bulk request body. It should be
+	// below the http.max_content_length of the opensearch cluster.
+	MaxRequestBytes int
+
+	// MaxRequestDocuments is the maximum number of documents in a single bulk request.
+	MaxRequestDocuments int
+
+	// Concurrency is the number of bulk requests which a single BulkIndex call sends at the same time.
+	// Since every debounce worker has one BulkIndex call in flight, this is the limit per worker.
+	Concurrency int
+
+	// MaxInFlight is the number of bulk requests the client, including its copies, sends at the same time
+	// across all BulkIndex calls. The limit is set up by NewClient, changing it afterwards has no effect.
+	// 0 disables the limit.
+	MaxInFlight int
+
+	// RejectOversized rejects documents which alone exceed MaxRequestBytes.
+	// Otherwise they are sent in a request of their own.
+	RejectOversized bool
+
+	// Compression is the content encoding of bulk request bodies. They aren't compressed by default.
+	Compression Compression
+
+	// CompressionLevel is passed to the compressor. 0 selects the default level of the compressor
+	// since sending bodies with a compression but without compressing them is pointless.
+	CompressionLevel int
+
+	// CompressionMinBytes is the size from which on bodies are compressed.
+	CompressionMinBytes int
+}
+
+// InitWithDefaults initialises BulkOptions with default values for each setting.
+func (o *BulkOptions) InitWithDefaults() {
+	o.MaxRequestBytes = 10 << 20
+	o.MaxRequestDocuments = 5000
+	o.Concurrency = 2
+	o.MaxInFlight = 8
+	o.RejectOversized = false
+	o.Compression = CompressionNone
+	o.CompressionLevel = flate.DefaultCompression
+	o.CompressionMinBytes = 1024
+}
+
+// ApplyOptions iterates over []BulkOption and applies every single one of them.
+func (o *BulkOptions) ApplyOptions(options []BulkOption) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// withDefaults returns a copy in which every unset setting has its default value.
+func (o BulkOptions) withDefaults() BulkOptions {
+	defaults := BulkOptions{}
+	defaults.InitWithDefaults()
+
+	if o.MaxRequestBytes <= 0 {
+		o.MaxRequestBytes = defaults.MaxRequestBytes
+	}
+	if o.MaxRequestDocuments <= 0 {
+		o.MaxRequestDocuments = defaults.MaxRequestDocuments
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaults.Concurrency
+	}
+	if o.CompressionLevel == 0 {
+		o.CompressionLevel = defaults.CompressionLevel
+	}
+	return o
+}
+
+// BulkChunk is the body of a single bulk request and the documents it contains.
+type BulkChunk struct {
+	Docs []Document
+	Body *bytes.Buffer
+
+	// Oversized is set when the chunk contains a single document which alone exceeds the maximum size.
+	Oversized bool
+}
+
+// Split marshals the documents into chunks which are at most maxBytes large and contain
+// at most maxDocuments. A document which alone exceeds maxBytes is isolated in an oversized chunk
+// so that it can't fail the other documents. The chunks keep the order of the documents.
+func (b Bulk) Split(maxBytes, maxDocuments int) ([]BulkChunk, error) {
+	var chunks []BulkChunk
+	current := BulkChunk{Body: &bytes.Buffer{}}
+	docBuffer := &bytes.Buffer{}
+
+	for _, doc := range b {
+		docBuffer.Reset()
+		if err := writeDocument(docBuffer, doc); err != nil {
+			return nil, err
+		}
+
+		if docBuffer.Len() > maxBytes {
+			// the current chunk is finished first so that the documents stay in order.
+			if len(current.Docs) > 0 {
+				chunks = append(chunks, current)
+				current = BulkChunk{Body: &bytes.Buffer{}}
+			}
+			body := bytes.NewBuffer(make([]byte, 0, docBuffer.Len()))
+			body.Write(docBuffer.Bytes())
+			chunks = append(chunks, BulkChunk{Docs: []Document{doc}, Body: body, Oversized: true})
+			continue
+		}
+
+		if len(current.Docs) > 0 &&
+			(current.Body.Len()+docBuffer.Len() > maxBytes || len(current.Docs) >= maxDocuments) {
+			chunks = append(chunks, current)
+			current = BulkChunk{Body: &bytes.Buffer{}}
+		}
+
+		current.Docs = append(current.Docs, doc)
+		current.Body.Write(docBuffer.Bytes())
+	}
+
+	if len(current.Docs) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks, nil
+}
+
+// dependencies returns for every chunk the earlier chunks which write one of its documents as well.
+// A chunk has to be sent after those so that the writes of a document stay in order.
+func dependencies(chunks []BulkChunk) [][]int {
+	type docKey struct{ index, id string }
+
+	last := map[docKey]int{}
+	deps := make([][]int, len(chunks))
+	for i, chunk := range chunks {
+		for _, doc := range chunk.Docs {
+			if doc.ID() == "" {
+				continue
+			}
+			key := docKey{index: doc.Index(), id: doc.ID()}
+			if previous, ok := last[key]; ok && previous != i && !containsInt(deps[i], previous) {
+				deps[i] = append(deps[i], previous)
+			}
+			last[key] = i
+		}
+	}
+	return deps
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,438 @@
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+var (
+	// ErrTemplateDrift is returned when the live index template differs in a way the UpdatePolicy doesn't allow to fix.
+	ErrTemplateDrift = errors.New("the index template differs from its configuration")
+	// ErrUnknownUpdatePolicy is returned by ParseUpdatePolicy.
+	ErrUnknownUpdatePolicy = errors.New("unknown template update policy")
+)
+
+// Template is the part of an index template which a DataStream can configure.
+// It can be read from JSON with the field names of the index template API.
+type Template struct {
+	// Mappings of the backing indices, e.g. {"properties": {"message": {"type": "text"}}}.
+	Mappings map[string]interface{} `json:"mappings,omitempty"`
+
+	// Settings of the backing indices. The "index." prefix is optional.
+	Settings map[string]interface{} `json:"settings,omitempty"`
+
+	// Priority of the template. The default of the Backend is used when 0.
+	Priority int `json:"priority,omitempty"`
+
+	// ComposedOf are the names of component templates which are applied before Mappings and Settings.
+	ComposedOf []string `json:"composed_of,omitempty"`
+}
+
+// TemplatedDataStream can be implemented by a DataStream which configures its index template.
+type TemplatedDataStream interface {
+	DataStream
+
+	// Template returns the configuration of the index template of the stream.
+	Template() Template
+}
+
+// TemplateOf returns the template configuration of the stream or an empty Template if there is none.
+func TemplateOf(stream DataStream) Template {
+	if templated, ok := stream.(TemplatedDataStream); ok {
+		return templated.Template()
+	}
+	return Template{}
+}
+
+// UpdatePolicy decides which differences between the live and the configured template are fixed.
+type UpdatePolicy string
+
+const (
+	// UpdateNever leaves an existing template untouched. Differences are only logged.
+	UpdateNever UpdatePolicy = "never"
+	// UpdateAdditive updates the template when the configuration only adds to it, e.g. new fields.
+	// Other differences are returned as ErrTemplateDrift.
+	UpdateAdditive UpdatePolicy = "additive"
+	// UpdateAlways replaces the template whenever it differs.
+	UpdateAlways UpdatePolicy = "always"
+)
+
+// ParseUpdatePolicy parses the name of an UpdatePolicy.
+func ParseUpdatePolicy(name string) (UpdatePolicy, error) {
+	switch policy := UpdatePolicy(name); policy {
+	case UpdateNever, UpdateAdditive, UpdateAlways:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownUpdatePolicy, name)
+}
+
+// TemplateOption can be applied to TemplateOptions.
+type TemplateOption = func(options *TemplateOptions)
+
+// WithUpdatePolicy configures which differences to an existing template are fixed.
+func WithUpdatePolicy(policy UpdatePolicy) TemplateOption {
+	return func(options *TemplateOptions) {
+		options.UpdatePolicy = policy
+	}
+}
+
+// WithRollover rolls the data stream over after its template was updated,
+// so that the new mappings and settings take effect right away.
+func WithRollover(rollover bool) TemplateOption {
+	return func(options *TemplateOptions) {
+		options.Rollover = rollover
+	}
+}
+
+type TemplateOptions struct {
+	UpdatePolicy UpdatePolicy
+	Rollover     bool
+}
+
+// InitWithDefaults initialises TemplateOptions with default values for each setting.
+func (o *TemplateOptions) InitWithDefaults() {
+	o.UpdatePolicy = UpdateNever
+	o.Rollover = false
+}
+
+// ApplyOptions iterates over []TemplateOption and applies every single one of them.
+func (o *TemplateOptions) ApplyOptions(options []TemplateOption) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// EnsureIndexTemplate makes sure that the index template of the stream is present and matches its configuration.
+// An existing template is compared to the configuration and updated as far as the UpdatePolicy allows it.
+func EnsureIndexTemplate(ctx context.Context, client Client, stream DataStream, options ...TemplateOption) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").
+		WithValues(logFieldStream, stream.Name())
+
+	templateOptions := TemplateOptions{}
+	templateOptions.InitWithDefaults()
+	templateOptions.ApplyOptions(options)
+
+	desired, err := IndexTemplate(client.Backend(), stream)
+	if err != nil {
+		return err
+	}
+	live, exists, err := GetIndexTemplate(ctx, client, stream.Name())
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		diff := DiffTemplates(live, desired)
+		if len(diff) == 0 {
+			log.Info("index template is up to date")
+			return nil
+		}
+		log.Info("index template differs from its configuration", "diff", diff.String(), "policy", templateOptions.UpdatePolicy)
+
+		switch {
+		case templateOptions.UpdatePolicy == UpdateNever:
+			return nil
+		case templateOptions.UpdatePolicy == UpdateAdditive && !diff.Additive():
+			return fmt.Errorf("%w: %d changes aren't additive", ErrTemplateDrift, len(diff))
+		}
+	}
+
+	if err := putIndexTemplate(ctx, client, stream.Name(), desired); err != nil {
+		return err
+	}
+	log.Info("index template was written", "updated", exists)
+
+	if exists && templateOptions.Rollover {
+		return RolloverDataStream(ctx, client, stream.Name())
+	}
+	return nil
+}
+
+// DiffIndexTemplate compares the live index template of the stream to its configuration.
+// Every configured field is reported as added when there is no template yet.
+func DiffIndexTemplate(ctx context.Context, client Client, stream DataStream) (TemplateDiff, error) {
+	desired, err := IndexTemplate(client.Backend(), stream)
+	if err != nil {
+		return nil, err
+	}
+	live, _, err := GetIndexTemplate(ctx, client, stream.Name())
+	if err != nil {
+		return nil, err
+	}
+	return DiffTemplates(live, desired), nil
+}
+
+// IndexTemplate returns the body of the index template which is written for the stream.
+func IndexTemplate(backend Backend, stream DataStream) (map[string]interface{}, error) {
+	config := TemplateOf(stream)
+	priority := config.Priority
+	if priority == 0 {
+		priority = backend.templatePriority()
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": []string{stream.Name()},
+		"data_stream":    map[string]interface{}{},
+		"priority":       priority,
+	}
+	if len(config.ComposedOf) > 0 {
+		template["composed_of"] = config.ComposedOf
+	}
+	inner := map[string]interface{}{}
+	if len(config.Mappings) > 0 {
+		inner["mappings"] = config.Mappings
+	}
+	if len(config.Settings) > 0 {
+		inner["settings"] = config.Settings
+	}
+	if len(inner) > 0 {
+		template["template"] = inner
+	}
+
+	// the template is decoded the same way as the live one to compare them.
+	encoded, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode index template: %w", err)
+	}
+	return decodeJSON(bytes.NewReader(encoded))
+}
+
+// GetIndexTemplate returns the live index template with the given name and whether it exists.
+func GetIndexTemplate(ctx context.Context, client Client, name string) (map[string]interface{}, bool, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client")
+
+	flat := true
+	request := opensearchapi.IndicesGetIndexTemplateRequest{Name: []string{name}, FlatSettings: &flat}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return nil, false, fmt.Errorf("executing get index template request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if response.IsError() {
+		analyzeBody(log, response)
+		return nil, false, fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+
+	var templates struct {
+		IndexTemplates []struct {
+			Name          string          `json:"name"`
+			IndexTemplate json.RawMessage `json:"index_template"`
+		} `json:"index_templates"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&templates); err != nil {
+		return nil, false, fmt.Errorf("unable to decode index template: %w", err)
+	}
+	for _, template := range templates.IndexTemplates {
+		if template.Name == name {
+			decoded, err := decodeJSON(bytes.NewReader(template.IndexTemplate))
+			return decoded, err == nil, err
+		}
+	}
+	return nil, false, nil
+}
+
+func putIndexTemplate(ctx context.Context, client Client, name string, template map[string]interface{}) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client")
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("unable to encode index template: %w", err)
+	}
+	request := opensearchapi.IndicesPutIndexTemplateRequest{Name: name, Body: bytes.NewReader(body)}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		log.Error(err, "error when executing request")
+		return err
+	}
+	defer logClose(log, response.Body)
+
+	if response.IsError() {
+		analyzeBody(log, response)
+		log.Info("unexpected status code", "statusCode", response.StatusCode)
+		return fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+	return nil
+}
+
+// DeleteIndexTemplate deletes the index template with the given name and reports whether it existed.
+// opensearch refuses to delete the template of an existing data stream.
+func DeleteIndexTemplate(ctx context.Context, client Client, name string) (bool, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").WithValues(logFieldStream, name)
+
+	request := opensearchapi.IndicesDeleteIndexTemplateRequest{Name: name}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return false, fmt.Errorf("executing delete index template request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if response.IsError() {
+		analyzeBody(log, response)
+		return false, fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+	log.Info("index template was deleted")
+	return true, nil
+}
+
+// RolloverDataStream starts a new backing index of the data stream, which uses the current template.
+// Nothing happens when the data stream doesn't exist yet.
+func RolloverDataStream(ctx context.Context, client Client, name string) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("opensearch-client").WithValues(logFieldStream, name)
+
+	request := opensearchapi.IndicesRolloverRequest{Alias: name}
+	response, err := request.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("executing rollover request failed: %w", err)
+	}
+	defer logClose(log, response.Body)
+
+	if response.StatusCode == http.StatusNotFound {
+		log.Info("data stream doesn't exist yet, no rollover needed")
+		return nil
+	}
+	if response.IsError() {
+		analyzeBody(log, response)
+		return fmt.Errorf("%w: %d", ErrorNegativeStatusCode, response.StatusCode)
+	}
+	log.Info("data stream was rolled over")
+	return nil
+}
+
+// TemplateChange is a single difference between the live and the configured index template.
+// Live is empty when the configuration adds the field, Desired is empty when it removes it.
+type TemplateChange struct {
+	Path    string
+	Live    string
+	Desired string
+}
+
+// TemplateDiff are the differences between the live and the configured index template, ordered by path.
+type TemplateDiff []TemplateChange
+
+// Additive reports whether the configuration only adds to the live template.
+func (d TemplateDiff) Additive() bool {
+	for _, change := range d {
+		if change.Live != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// String lists the changes, prefixed with + when added, - when removed and ~ when changed.
+func (d TemplateDiff) String() string {
+	var builder strings.Builder
+	for _, change := range d {
+		switch {
+		case change.Live == "":
+			fmt.Fprintf(&builder, "+ %s: %s\n", change.Path, change.Desired)
+		case change.Desired == "":
+			fmt.Fprintf(&builder, "- %s: %s\n", change.Path, change.Live)
+		default:
+			fmt.Fprintf(&builder, "~ %s: %s -> %s\n", change.Path, change.Live, change.Desired)
+		}
+	}
+	return builder.String()
+}
+
+// DiffTemplates compares the fields of index templates which the bouncer manages.
+// Fields opensearch adds on its own, like the timestamp field of data streams, are ignored.
+func DiffTemplates(live, desired map[string]interface{}) TemplateDiff {
+	liveFields := templateFields(live)
+	desiredFields := templateFields(desired)
+
+	var diff TemplateDiff
+	for path, value := range desiredFields {
+		if liveValue := liveFields[path]; liveValue != value {
+			diff = append(diff, TemplateChange{Path: path, Live: liveValue, Desired: value})
+		}
+	}
+	for path, value := range liveFields {
+		if _, ok := desiredFields[path]; !ok {
+			diff = append(diff, TemplateChange{Path: path, Live: value})
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Path < diff[j].Path })
+	return diff
+}
+
+// templateFields flattens the managed fields of a template into paths and their values.
+func templateFields(template map[string]interface{}) map[string]string {
+	fields := map[string]string{}
+	if template == nil {
+		return fields
+	}
+
+	for _, key := range []string{"index_patterns", "composed_of", "priority"} {
+		flattenFields(fields, key, template[key])
+	}
+	if _, ok := template["data_stream"]; ok {
+		fields["data_stream"] = "enabled"
+	}
+
+	inner, _ := template["template"].(map[string]interface{})
+	flattenFields(fields, "template.mappings", inner["mappings"])
+
+	settings := map[string]string{}
+	flattenFields(settings, "", inner["settings"])
+	for key, value := range settings {
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		fields["template.settings."+key] = value
+	}
+	return fields
+}
+
+// flattenFields adds every leaf of value with its dotted path. Arrays are leaves, empty ones are skipped.
+// Leaves are compared by their string form since opensearch returns settings as strings.
+func flattenFields(fields map[string]string, path string, value interface{}) {
+	switch typed := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, inner := range typed {
+			if path != "" {
+				key = path + "." + key
+			}
+			flattenFields(fields, key, inner)
+		}
+	case []interface{}:
+		if len(typed) > 0 {
+			encoded, _ := json.Marshal(typed)
+			fields[path] = string(encoded)
+		}
+	case string:
+		fields[path] = typed
+	default:
+		encoded, _ := json.Marshal(typed)
+		fields[path] = string(encoded)
+	}
+}
+
+// decodeJSON decodes an object and keeps numbers as json.Number.
+func decodeJSON(body *bytes.Reader) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+
+	var decoded map[string]interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("unable to decode index template: %w", err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,230 @@
+package opensearch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Parallel()
+
+	stream := Stream{StreamName: "events", IndexTemplate: Template{
+		Mappings: map[string]interface{}{
+			"properties": map[string]interface{}{"message": map[string]interface{}{"type": "text"}},
+		},
+		Settings: map[string]interface{}{"number_of_shards": 1},
+	}}
+	extended := stream
+	extended.IndexTemplate.Mappings = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "text"},
+			"level":   map[string]interface{}{"type": "keyword"},
+		},
+	}
+	changed := stream
+	changed.IndexTemplate.Mappings = map[string]interface{}{
+		"properties": map[string]interface{}{"message": map[string]interface{}{"type": "keyword"}},
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newTemplateCluster(t)
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, stream))
+		assert.Equal(t, 1, cluster.puts)
+		assert.JSONEq(t, `{
+			"index_patterns": ["events"],
+			"data_stream": {},
+			"priority": 100,
+			"template": {
+				"mappings": {"properties": {"message": {"type": "text"}}},
+				"settings": {"number_of_shards": 1}
+			}
+		}`, cluster.template)
+
+		diff, err := DiffIndexTemplate(context.Background(), cluster.client, stream)
+		assert.NoError(t, err)
+		assert.Empty(t, diff)
+
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, stream, WithUpdatePolicy(UpdateAlways)))
+		assert.Equal(t, 1, cluster.puts)
+	})
+
+	t.Run("Additive Update", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newTemplateCluster(t)
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, stream))
+
+		diff, err := DiffIndexTemplate(context.Background(), cluster.client, extended)
+		assert.NoError(t, err)
+		assert.Equal(t, TemplateDiff{{Path: "template.mappings.properties.level.type", Desired: "keyword"}}, diff)
+		assert.True(t, diff.Additive())
+
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, extended, WithUpdatePolicy(UpdateNever)))
+		assert.Equal(t, 1, cluster.puts)
+
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, extended,
+			WithUpdatePolicy(UpdateAdditive), WithRollover(true)))
+		assert.Equal(t, 2, cluster.puts)
+		assert.Equal(t, 1, cluster.rollovers)
+	})
+
+	t.Run("Drift", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newTemplateCluster(t)
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, stream))
+
+		diff, err := DiffIndexTemplate(context.Background(), cluster.client, changed)
+		assert.NoError(t, err)
+		assert.Equal(t, "~ template.mappings.properties.message.type: text -> keyword\n", diff.String())
+		assert.False(t, diff.Additive())
+
+		err = EnsureIndexTemplate(context.Background(), cluster.client, changed, WithUpdatePolicy(UpdateAdditive))
+		assert.ErrorIs(t, err, ErrTemplateDrift)
+		assert.Equal(t, 1, cluster.puts)
+
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, changed, WithUpdatePolicy(UpdateAlways)))
+		assert.Equal(t, 2, cluster.puts)
+		assert.Equal(t, 0, cluster.rollovers)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		t.Parallel()
+
+		cluster := newTemplateCluster(t)
+		assert.NoError(t, EnsureIndexTemplate(context.Background(), cluster.client, stream))
+
+		deleted, err := DeleteIndexTemplate(context.Background(), cluster.client, "events")
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		_, exists, err := GetIndexTemplate(context.Background(), cluster.client, "events")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		deleted, err = DeleteIndexTemplate(context.Background(), cluster.client, "events")
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		t.Parallel()
+
+		live := map[string]interface{}{
+			"index_patterns": []interface{}{"events"},
+			"composed_of":    []interface{}{},
+			"priority":       json.Number("100"),
+			"data_stream":    map[string]interface{}{"timestamp_field": map[string]interface{}{"name": "@timestamp"}},
+			"template": map[string]interface{}{
+				"settings": map[string]interface{}{"index.number_of_shards": "1", "index.refresh_interval": "5s"},
+			},
+		}
+		desired, err := IndexTemplate(Backend{}, Stream{StreamName: "events", IndexTemplate: Template{
+			Settings:   map[string]interface{}{"index": map[string]interface{}{"number_of_shards": 1}},
+			ComposedOf: []string{"base"},
+		}})
+		assert.NoError(t, err)
+
+		assert.Equal(t, TemplateDiff{
+			{Path: "composed_of", Desired: `["base"]`},
+			{Path: "template.settings.index.refresh_interval", Live: "5s"},
+		}, DiffTemplates(live, desired))
+	})
+
+	t.Run("Parse Policy", func(t *testing.T) {
+		t.Parallel()
+
+		policy, err := ParseUpdatePolicy("additive")
+		assert.NoError(t, err)
+		assert.Equal(t, UpdateAdditive, policy)
+
+		_, err = ParseUpdatePolicy("sometimes")
+		assert.ErrorIs(t, err, ErrUnknownUpdatePolicy)
+	})
+}
+
+// templateCluster stores a single index template and returns it with flat settings like opensearch.
+type templateCluster struct {
+	client Client
+
+	mu        sync.Mutex
+	template  string
+	puts      int
+	rollovers int
+}
+
+func newTemplateCluster(t *testing.T) *templateCluster {
+	t.Helper()
+
+	cluster := &templateCluster{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cluster.mu.Lock()
+		defer cluster.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/_index_template/events" && r.Method == http.MethodGet:
+			if cluster.template == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = io.WriteString(w, `{"index_templates": [{"name": "events", "index_template": `+flatSettings(t, cluster.template)+`}]}`)
+		case r.URL.Path == "/_index_template/events" && r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			cluster.template = string(body)
+			cluster.puts++
+			_, _ = io.WriteString(w, `{"acknowledged": true}`)
+		case r.URL.Path == "/_index_template/events" && r.Method == http.MethodDelete:
+			if cluster.template == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			cluster.template = ""
+			_, _ = io.WriteString(w, `{"acknowledged": true}`)
+		case r.URL.Path == "/events/_rollover":
+			cluster.rollovers++
+			_, _ = io.WriteString(w, `{"acknowledged": true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(opensearch.Config{Addresses: []string{server.URL}})
+	assert.NoError(t, err)
+	cluster.client = client
+	return cluster
+}
+
+// flatSettings prefixes settings with "index." and turns their values into strings.
+func flatSettings(t *testing.T, template string) string {
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(template), &decoded))
+	if inner, ok := decoded["template"].(map[string]interface{}); ok {
+		if settings, ok := inner["settings"].(map[string]interface{}); ok {
+			flat := map[string]interface{}{}
+			for key, value := range settings {
+				if !strings.HasPrefix(key, "index.") {
+					key = "index." + key
+				}
+				encoded, _ := json.Marshal(value)
+				flat[key] = strings.Trim(string(encoded), `"`)
+			}
+			inner["settings"] = flat
+		}
+	}
+	// opensearch reports the timestamp field of data streams.
+	decoded["data_stream"] = map[string]interface{}{"timestamp_field": map[string]interface{}{"name": "@timestamp"}}
+	encoded, _ := json.Marshal(decoded)
+	return string(encoded)
+}
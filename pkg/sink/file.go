@@ -0,0 +1,196 @@
+package sink
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+// FileOption can be applied to FileOptions.
+type FileOption = func(options *FileOptions)
+
+// WithRotation configures when a new file is started. A limit of 0 disables it.
+func WithRotation(maxBytes int64, maxAge time.Duration) FileOption {
+	return func(options *FileOptions) {
+		options.MaxBytes = maxBytes
+		options.MaxAge = maxAge
+	}
+}
+
+// WithGzip compresses the files.
+func WithGzip(enabled bool) FileOption {
+	return func(options *FileOptions) {
+		options.Gzip = enabled
+	}
+}
+
+// WithPrefix configures the prefix of the file names.
+func WithPrefix(prefix string) FileOption {
+	return func(options *FileOptions) {
+		options.Prefix = prefix
+	}
+}
+
+type FileOptions struct {
+	// MaxBytes is the uncompressed size after which a new file is started.
+	MaxBytes int64
+
+	// MaxAge is the time after which a new file is started. It is checked when documents are written.
+	MaxAge time.Duration
+
+	// Gzip compresses the files.
+	Gzip bool
+
+	// Prefix is the start of every file name.
+	Prefix string
+}
+
+// InitWithDefaults initialises FileOptions with default values for each setting.
+func (o *FileOptions) InitWithDefaults() {
+	o.MaxBytes = 128 << 20
+	o.MaxAge = time.Hour
+	o.Gzip = false
+	o.Prefix = "bouncer"
+}
+
+// ApplyOptions iterates over []FileOption and applies every single one of them.
+func (o *FileOptions) ApplyOptions(options []FileOption) {
+	for _, op := range options {
+		op(o)
+	}
+}
+
+// File writes the documents as NDJSON bulk requests to rolling files in a directory.
+// The files can be replayed against the _bulk API of opensearch.
+type File struct {
+	dir     string
+	options FileOptions
+	now     func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	gzip     *gzip.Writer
+	writer   io.Writer
+	written  int64
+	opened   time.Time
+	sequence int
+}
+
+// NewFile creates a File sink which writes to dir. The directory is created if it doesn't exist.
+func NewFile(dir string, options ...FileOption) (*File, error) {
+	fileOptions := FileOptions{}
+	fileOptions.InitWithDefaults()
+	fileOptions.ApplyOptions(options)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir, options: fileOptions, now: time.Now}, nil
+}
+
+// BulkIndex appends the documents to the current file. Every batch is flushed, so that
+// the files can be read while they are written.
+func (f *File) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	buffer, err := opensearch.Bulk(docs).MarshalJSONToBuffer()
+	if err != nil {
+		return opensearch.BulkResult{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rotate(int64(buffer.Len())) {
+		if err := f.close(); err != nil {
+			return opensearch.BulkResult{}, err
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return opensearch.BulkResult{}, err
+		}
+	}
+
+	written, err := buffer.WriteTo(f.writer)
+	f.written += written
+	if err == nil && f.gzip != nil {
+		err = f.gzip.Flush()
+	}
+	if err != nil {
+		return opensearch.BulkResult{}, err
+	}
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
+
+// Close closes the current file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.close()
+}
+
+// rotate reports whether the current file has to be closed before size bytes are written.
+// A file always takes at least one batch, even if it exceeds MaxBytes.
+func (f *File) rotate(size int64) bool {
+	if f.file == nil {
+		return false
+	}
+	if f.options.MaxBytes > 0 && f.written > 0 && f.written+size > f.options.MaxBytes {
+		return true
+	}
+	return f.options.MaxAge > 0 && f.now().Sub(f.opened) >= f.options.MaxAge
+}
+
+func (f *File) open() error {
+	f.opened = f.now()
+	for {
+		f.sequence++
+		name := fmt.Sprintf("%s-%s-%04d.ndjson", f.options.Prefix, f.opened.UTC().Format("20060102T150405"), f.sequence)
+		if f.options.Gzip {
+			name += ".gz"
+		}
+
+		// a file of an earlier run can have the same name when it was started in the same second.
+		file, err := os.OpenFile(filepath.Join(f.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f.setFile(file)
+		return nil
+	}
+}
+
+func (f *File) setFile(file *os.File) {
+	f.file = file
+	f.writer = file
+	f.written = 0
+	if f.options.Gzip {
+		f.gzip = gzip.NewWriter(file)
+		f.writer = f.gzip
+	}
+}
+
+func (f *File) close() error {
+	if f.file == nil {
+		return nil
+	}
+
+	var err error
+	if f.gzip != nil {
+		err = f.gzip.Close()
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file, f.gzip, f.writer = nil, nil, nil
+	return err
+}
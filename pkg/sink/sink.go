@@ -0,0 +1,42 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+// Sink writes the batches of documents the Debouncer flushes.
+// opensearch.Client is the Sink used in production, File and Writer allow
+// to run the bouncer without opensearch.
+type Sink interface {
+	BulkIndex(ctx context.Context, docs []opensearch.Document) (opensearch.BulkResult, error)
+}
+
+var _ Sink = opensearch.Client{}
+
+// Writer writes the documents as NDJSON bulk request to an io.Writer, e.g. os.Stdout for a dry run.
+type Writer struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{writer: writer}
+}
+
+func (w *Writer) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	buffer, err := opensearch.Bulk(docs).MarshalJSONToBuffer()
+	if err != nil {
+		return opensearch.BulkResult{}, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := buffer.WriteTo(w.writer); err != nil {
+		return opensearch.BulkResult{}, err
+	}
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
@@ -0,0 +1,171 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	writer := NewWriter(&out)
+
+	result, err := writer.BulkIndex(context.Background(), []opensearch.Document{
+		testingDoc{id: "1", data: map[string]interface{}{"message": "hello"}},
+		testingDoc{id: "2", action: opensearch.ActionDelete},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Succeeded)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.JSONEq(t, `{"index":{"_index":"index","_id":"1"}}`, lines[0])
+	assert.JSONEq(t, `{"message":"hello"}`, lines[1])
+	assert.JSONEq(t, `{"delete":{"_index":"index","_id":"2"}}`, lines[2])
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	doc := testingDoc{id: "1", data: map[string]interface{}{"message": "hello"}}
+	docSize := func(t *testing.T) int64 {
+		buffer, err := opensearch.Bulk{doc}.MarshalJSONToBuffer()
+		assert.NoError(t, err)
+		return int64(buffer.Len())
+	}
+
+	t.Run("Rotates By Size", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		file, err := NewFile(dir, WithRotation(2*docSize(t), 0))
+		assert.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err := file.BulkIndex(context.Background(), []opensearch.Document{doc})
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, file.Close())
+
+		contents := readFiles(t, dir)
+		assert.Len(t, contents, 3)
+		assert.Equal(t, 4, strings.Count(contents[0], "\n"))
+		assert.Equal(t, 2, strings.Count(contents[2], "\n"))
+	})
+
+	t.Run("Rotates By Age", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		file, err := NewFile(dir, WithRotation(0, time.Minute))
+		assert.NoError(t, err)
+		now := time.Now()
+		file.now = func() time.Time { return now }
+
+		_, err = file.BulkIndex(context.Background(), []opensearch.Document{doc})
+		assert.NoError(t, err)
+		now = now.Add(30 * time.Second)
+		_, err = file.BulkIndex(context.Background(), []opensearch.Document{doc})
+		assert.NoError(t, err)
+		now = now.Add(30 * time.Second)
+		_, err = file.BulkIndex(context.Background(), []opensearch.Document{doc})
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+
+		assert.Len(t, readFiles(t, dir), 2)
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		file, err := NewFile(dir, WithGzip(true), WithPrefix("events"))
+		assert.NoError(t, err)
+
+		result, err := file.BulkIndex(context.Background(), []opensearch.Document{doc, doc})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, result.Succeeded)
+
+		// batches are flushed, so the file is readable before it is closed.
+		contents := readFiles(t, dir)
+		assert.Len(t, contents, 1)
+		assert.Equal(t, 4, strings.Count(contents[0], "\n"))
+		assert.NoError(t, file.Close())
+
+		names, err := filepath.Glob(filepath.Join(dir, "events-*.ndjson.gz"))
+		assert.NoError(t, err)
+		assert.Len(t, names, 1)
+	})
+}
+
+// readFiles returns the uncompressed content of every file in dir in the order they were written.
+func readFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	contents := make([]string, 0, len(names))
+	for _, name := range names {
+		file, err := os.Open(filepath.Join(dir, name))
+		assert.NoError(t, err)
+
+		var reader io.Reader = file
+		if strings.HasSuffix(name, ".gz") {
+			gzipReader, err := gzip.NewReader(file)
+			assert.NoError(t, err)
+			reader = gzipReader
+		}
+		content, err := io.ReadAll(reader)
+		if !unfinishedGzip(err) {
+			assert.NoError(t, err)
+		}
+		file.Close()
+		contents = append(contents, string(content))
+	}
+	return contents
+}
+
+// unfinishedGzip reports whether the error is caused by the missing footer of a gzip file which is still written.
+func unfinishedGzip(err error) bool {
+	return err == io.ErrUnexpectedEOF
+}
+
+type testingDoc struct {
+	id     string
+	action opensearch.Action
+	data   map[string]interface{}
+}
+
+func (t testingDoc) ID() string {
+	return t.id
+}
+
+func (t testingDoc) Index() string {
+	return "index"
+}
+
+func (t testingDoc) Data() interface{} {
+	return t.data
+}
+
+func (t testingDoc) Action() opensearch.Action {
+	return t.action
+}
@@ -0,0 +1,225 @@
+package spill
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kstiehl/index-bouncer/pkg/breaker"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/metrics"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+)
+
+// Indexer protects another debounce.Indexer with a circuit breaker.
+// While the breaker is open documents are written to the spill Queue instead.
+// Documents which failed since opensearch was unavailable are spilled as well.
+// Once the cooldown of the breaker passed, Run probes it with a spilled segment
+// and drains the Queue at a limited rate after it closed.
+//
+// Spilled documents are written after documents which were flushed in the meantime.
+// Events which rely on their order should carry a version.
+type Indexer struct {
+	// Metrics records spilled, retried and dropped documents when set.
+	Metrics *metrics.Metrics
+
+	indexer   debounce.Indexer
+	breaker   *breaker.Breaker
+	queue     *Queue
+	drainRate int
+}
+
+// NewIndexer creates an Indexer which drains at most drainRate documents per second.
+func NewIndexer(indexer debounce.Indexer, b *breaker.Breaker, queue *Queue, drainRate int) *Indexer {
+	return &Indexer{
+		indexer:   indexer,
+		breaker:   b,
+		queue:     queue,
+		drainRate: drainRate,
+	}
+}
+
+// Admit rejects documents while the breaker isn't closed and the spill Queue is full.
+func (i *Indexer) Admit() error {
+	if i.breaker.State() != breaker.Closed && i.queue.Full() {
+		return fmt.Errorf("%w: opensearch is unavailable and the spill queue is full", debounce.ErrRetryLater)
+	}
+	return nil
+}
+
+// BulkIndex writes the documents to the wrapped Indexer or spills them while the breaker is open.
+func (i *Indexer) BulkIndex(ctx context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	log := logr.FromContextOrDiscard(ctx).WithName("spill")
+
+	if !i.breaker.Allow() {
+		return i.spill(docs, opensearch.BulkResult{}, nil)
+	}
+
+	result, err := i.indexer.BulkIndex(ctx, docs)
+	retryable := retryableDocs(docs, result)
+	if unavailable(result, err, retryable) {
+		i.breaker.Failure()
+		log.Info("opensearch is unavailable", "breaker", i.breaker.State().String(), "error", err.Error())
+	} else {
+		i.breaker.Success()
+	}
+
+	if len(retryable) == 0 {
+		return result, err
+	}
+	return i.spill(retryable, result, err)
+}
+
+// spill writes the documents to the Queue and removes them from the failed ones of the result.
+func (i *Indexer) spill(docs []opensearch.Document, result opensearch.BulkResult, err error) (opensearch.BulkResult, error) {
+	if pushErr := i.queue.Push(docs); pushErr != nil {
+		if err == nil {
+			// the breaker was open, so none of the documents was sent.
+			result.Failed = append(result.Failed, failedItems(docs, pushErr)...)
+			err = pushErr
+		}
+		return result, err
+	}
+
+	spilled := make(map[docKey]bool, len(docs))
+	for _, doc := range docs {
+		spilled[docKey{index: doc.Index(), id: doc.ID()}] = true
+	}
+	failed := result.Failed[:0]
+	for _, item := range result.Failed {
+		if !spilled[docKey{index: item.Index, id: item.ID}] {
+			failed = append(failed, item)
+		}
+	}
+	result.Failed = failed
+	result.Spilled += len(docs)
+	i.Metrics.Spilled(len(docs))
+
+	if len(result.Failed) == 0 {
+		err = nil
+	}
+	return result, err
+}
+
+// Run drains the spill Queue until the context is done. While the breaker isn't closed,
+// a single segment is written as its probe once the cooldown passed.
+func (i *Indexer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		i.tick(ctx)
+	}
+}
+
+// tick drains the spill Queue for the current second if the breaker allows it.
+func (i *Indexer) tick(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx).WithName("spill")
+
+	if i.queue.Len() == 0 || !i.breaker.Allow() {
+		return
+	}
+	probe := i.breaker.State() != breaker.Closed
+	drained, err := i.drain(ctx, probe)
+	if err != nil {
+		log.Error(err, "draining spilled documents failed")
+	}
+	if drained > 0 {
+		log.Info("drained spilled documents", "drained", drained, "remaining", i.queue.Len(), "probe", probe)
+	}
+}
+
+// drain writes spilled segments until the rate for the current second is used up.
+// A probe of the breaker writes a single segment, whose outcome decides whether the breaker closes.
+func (i *Indexer) drain(ctx context.Context, probe bool) (int, error) {
+	drained := 0
+	for drained < i.drainRate {
+		docs, ack, err := i.queue.Peek()
+		if err != nil || len(docs) == 0 {
+			if probe {
+				// nothing was sent, so the breaker stays open for another cooldown.
+				i.breaker.Failure()
+			}
+			return drained, err
+		}
+
+		i.Metrics.Retried(len(docs))
+		result, err := i.indexer.BulkIndex(ctx, docs)
+		retryable := retryableDocs(docs, result)
+		if len(retryable) > 0 {
+			// the segment is kept and written again once opensearch is available.
+			if unavailable(result, err, retryable) {
+				i.breaker.Failure()
+			} else {
+				i.breaker.Success()
+			}
+			return drained, err
+		}
+		i.breaker.Success()
+
+		if len(result.Failed) > 0 {
+			logr.FromContextOrDiscard(ctx).Info("dropped spilled documents which can't be written",
+				"failed", len(result.Failed), "firstError", result.Failed[0].Error)
+		}
+		for _, item := range result.Failed {
+			i.Metrics.Dropped(item.Index, item.ErrorType())
+		}
+		if err := ack(); err != nil {
+			return drained, err
+		}
+		drained += len(docs)
+		if probe {
+			return drained, nil
+		}
+	}
+	return drained, nil
+}
+
+type docKey struct {
+	index string
+	id    string
+}
+
+// retryableDocs returns the documents which failed since opensearch was unavailable.
+func retryableDocs(docs []opensearch.Document, result opensearch.BulkResult) []opensearch.Document {
+	retryable := map[docKey]bool{}
+	for _, item := range result.Failed {
+		if item.Retryable() {
+			retryable[docKey{index: item.Index, id: item.ID}] = true
+		}
+	}
+	if len(retryable) == 0 {
+		return nil
+	}
+
+	var retry []opensearch.Document
+	for _, doc := range docs {
+		if retryable[docKey{index: doc.Index(), id: doc.ID()}] {
+			retry = append(retry, doc)
+		}
+	}
+	return retry
+}
+
+// unavailable reports whether the request failed since opensearch was unavailable.
+func unavailable(result opensearch.BulkResult, err error, retryable []opensearch.Document) bool {
+	return err != nil && result.Succeeded == 0 && len(retryable) > 0
+}
+
+func failedItems(docs []opensearch.Document, err error) []opensearch.BulkResponseItem {
+	items := make([]opensearch.BulkResponseItem, 0, len(docs))
+	for _, doc := range docs {
+		items = append(items, opensearch.BulkResponseItem{
+			Index: doc.Index(),
+			ID:    doc.ID(),
+			Error: &opensearch.BulkItemError{Type: "spill_exception", Reason: err.Error()},
+		})
+	}
+	return items
+}
@@ -0,0 +1,281 @@
+package spill
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrQuotaExceeded = errors.New("spill queue quota exceeded")
+
+const segmentSuffix = ".ndjson"
+
+// Queue is a disk backed FIFO queue of documents.
+// Every Push writes a segment file which is removed once it was acknowledged after Peek.
+// Segments which are present in the directory when the Queue is opened are recovered.
+type Queue struct {
+	dir   string
+	quota int64
+
+	mu       sync.Mutex
+	segments []segment
+	bytes    int64
+	docs     int
+	nextSeq  uint64
+	rejected bool
+}
+
+type segment struct {
+	seq  uint64
+	size int64
+	docs int
+}
+
+// record is how a document is stored in a segment.
+type record struct {
+	Index     string            `json:"index"`
+	ID        string            `json:"id"`
+	Action    opensearch.Action `json:"action"`
+	Version   int64             `json:"version,omitempty"`
+	Partition string            `json:"partition,omitempty"`
+	Data      json.RawMessage   `json:"data,omitempty"`
+
+	// TraceID, SpanID and TraceFlags are the span the document was received in, so that
+	// the bulk request which replays it is still linked to it.
+	TraceID    string `json:"trace_id,omitempty"`
+	SpanID     string `json:"span_id,omitempty"`
+	TraceFlags byte   `json:"trace_flags,omitempty"`
+}
+
+// spilledDocument is a document which was read back from a segment.
+type spilledDocument struct {
+	record record
+}
+
+func (s spilledDocument) ID() string {
+	return s.record.ID
+}
+
+func (s spilledDocument) Index() string {
+	return s.record.Index
+}
+
+func (s spilledDocument) Data() interface{} {
+	return s.record.Data
+}
+
+func (s spilledDocument) Action() opensearch.Action {
+	return s.record.Action
+}
+
+func (s spilledDocument) Version() int64 {
+	return s.record.Version
+}
+
+func (s spilledDocument) PartitionKey() string {
+	return s.record.Partition
+}
+
+func (s spilledDocument) SpanContext() trace.SpanContext {
+	traceID, err := trace.TraceIDFromHex(s.record.TraceID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	spanID, err := trace.SpanIDFromHex(s.record.SpanID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.TraceFlags(s.record.TraceFlags),
+		Remote:     true,
+	})
+}
+
+// Open opens the Queue in the given directory which holds at most quota bytes.
+func Open(dir string, quota int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("unable to create spill directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read spill directory: %w", err)
+	}
+
+	queue := &Queue{dir: dir, quota: quota}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("unable to recover spilled segment: %w", err)
+		}
+		docs := bytes.Count(content, []byte{'\n'})
+		queue.segments = append(queue.segments, segment{seq: seq, size: int64(len(content)), docs: docs})
+		queue.bytes += int64(len(content))
+		queue.docs += docs
+		if seq >= queue.nextSeq {
+			queue.nextSeq = seq + 1
+		}
+	}
+
+	sort.Slice(queue.segments, func(i, j int) bool {
+		return queue.segments[i].seq < queue.segments[j].seq
+	})
+	return queue, nil
+}
+
+// Push appends the documents as a new segment. ErrQuotaExceeded is returned when
+// the segment doesn't fit into the quota.
+func (q *Queue) Push(docs []opensearch.Document) error {
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	for _, doc := range docs {
+		data, err := json.Marshal(doc.Data())
+		if err != nil {
+			return fmt.Errorf("unable to spill document: %w", err)
+		}
+		rec := record{
+			Index:   doc.Index(),
+			ID:      doc.ID(),
+			Action:  opensearch.ActionOf(doc),
+			Version: opensearch.VersionOf(doc),
+			Data:    data,
+		}
+		if rec.Action == opensearch.ActionDelete {
+			rec.Data = nil
+		}
+		if partitioned, ok := doc.(debounce.PartitionedDocument); ok {
+			rec.Partition = partitioned.PartitionKey()
+		}
+		if span := opensearch.SpanContextOf(doc); span.IsValid() {
+			rec.TraceID, rec.SpanID, rec.TraceFlags = span.TraceID().String(), span.SpanID().String(), byte(span.TraceFlags())
+		}
+		if err := encoder.Encode(rec); err != nil {
+			return fmt.Errorf("unable to spill document: %w", err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.bytes+int64(buffer.Len()) > q.quota {
+		q.rejected = true
+		return ErrQuotaExceeded
+	}
+
+	seq := q.nextSeq
+	path := q.path(seq)
+	if err := os.WriteFile(path+".tmp", buffer.Bytes(), 0o640); err != nil {
+		return fmt.Errorf("unable to write spill segment: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("unable to write spill segment: %w", err)
+	}
+
+	q.nextSeq++
+	q.segments = append(q.segments, segment{seq: seq, size: int64(buffer.Len()), docs: len(docs)})
+	q.bytes += int64(buffer.Len())
+	q.docs += len(docs)
+	return nil
+}
+
+// Peek returns the documents of the oldest segment and a function which removes the segment.
+// The segment stays in the queue until it was acknowledged, so it is read again when writing it failed.
+// No documents are returned when the Queue is empty.
+func (q *Queue) Peek() ([]opensearch.Document, func() error, error) {
+	q.mu.Lock()
+	if len(q.segments) == 0 {
+		q.mu.Unlock()
+		return nil, nil, nil
+	}
+	oldest := q.segments[0]
+	q.mu.Unlock()
+
+	file, err := os.Open(q.path(oldest.seq))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read spill segment: %w", err)
+	}
+	defer file.Close()
+
+	docs := make([]opensearch.Document, 0, oldest.docs)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(oldest.size)+1)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, nil, fmt.Errorf("corrupt spill segment %d: %w", oldest.seq, err)
+		}
+		docs = append(docs, spilledDocument{record: rec})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("unable to read spill segment: %w", err)
+	}
+
+	return docs, func() error { return q.remove(oldest) }, nil
+}
+
+// remove deletes an acknowledged segment.
+func (q *Queue) remove(acked segment) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.segments) == 0 || q.segments[0].seq != acked.seq {
+		return nil
+	}
+	if err := os.Remove(q.path(acked.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove spill segment: %w", err)
+	}
+
+	q.segments = q.segments[1:]
+	q.bytes -= acked.size
+	q.docs -= acked.docs
+	q.rejected = false
+	return nil
+}
+
+// Bytes returns the size of all segments.
+func (q *Queue) Bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// Len returns the number of spilled documents.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.docs
+}
+
+// Full reports whether the quota is used up or the last Push was rejected because of it.
+func (q *Queue) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rejected || q.bytes >= q.quota
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
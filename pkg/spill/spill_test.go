@@ -0,0 +1,230 @@
+package spill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kstiehl/index-bouncer/pkg/breaker"
+	"github.com/kstiehl/index-bouncer/pkg/debounce"
+	"github.com/kstiehl/index-bouncer/pkg/opensearch"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Push And Peek", func(t *testing.T) {
+		t.Parallel()
+
+		queue, err := Open(t.TempDir(), 1<<20)
+		assert.NoError(t, err)
+		span := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{2},
+			TraceFlags: trace.FlagsSampled,
+		})
+
+		assert.NoError(t, queue.Push([]opensearch.Document{
+			testingDoc{id: "1", action: opensearch.ActionIndex, version: 3, partition: "object", span: span},
+			testingDoc{id: "2", action: opensearch.ActionDelete},
+		}))
+		assert.NoError(t, queue.Push([]opensearch.Document{testingDoc{id: "3", action: opensearch.ActionCreate}}))
+		assert.Equal(t, 3, queue.Len())
+
+		docs, ack, err := queue.Peek()
+		assert.NoError(t, err)
+		assert.Len(t, docs, 2)
+		assert.Equal(t, "1", docs[0].ID())
+		assert.Equal(t, opensearch.ActionIndex, opensearch.ActionOf(docs[0]))
+		assert.Equal(t, int64(3), opensearch.VersionOf(docs[0]))
+		assert.JSONEq(t, `{"foo":"bar"}`, string(docs[0].Data().(json.RawMessage)))
+		// replayed documents keep their worker and stay linked to the span they were received in.
+		assert.Equal(t, "object", docs[0].(debounce.PartitionedDocument).PartitionKey())
+		assert.Equal(t, span.WithRemote(true), opensearch.SpanContextOf(docs[0]))
+		assert.False(t, opensearch.SpanContextOf(docs[1]).IsValid())
+		assert.Equal(t, opensearch.ActionDelete, opensearch.ActionOf(docs[1]))
+
+		// without acknowledging the segment is read again.
+		again, _, err := queue.Peek()
+		assert.NoError(t, err)
+		assert.Len(t, again, 2)
+
+		assert.NoError(t, ack())
+		docs, _, err = queue.Peek()
+		assert.NoError(t, err)
+		assert.Equal(t, "3", docs[0].ID())
+		assert.Equal(t, 1, queue.Len())
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		queue, err := Open(dir, 1<<20)
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push([]opensearch.Document{testingDoc{id: "1"}}))
+		assert.NoError(t, queue.Push([]opensearch.Document{testingDoc{id: "2"}}))
+
+		recovered, err := Open(dir, 1<<20)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, recovered.Len())
+		assert.Equal(t, queue.Bytes(), recovered.Bytes())
+
+		docs, _, err := recovered.Peek()
+		assert.NoError(t, err)
+		assert.Equal(t, "1", docs[0].ID())
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		t.Parallel()
+
+		queue, err := Open(t.TempDir(), 100)
+		assert.NoError(t, err)
+
+		assert.NoError(t, queue.Push([]opensearch.Document{testingDoc{id: "1"}}))
+		assert.False(t, queue.Full())
+		assert.ErrorIs(t, queue.Push([]opensearch.Document{testingDoc{id: "2"}}), ErrQuotaExceeded)
+		assert.True(t, queue.Full())
+
+		_, ack, err := queue.Peek()
+		assert.NoError(t, err)
+		assert.NoError(t, ack())
+		assert.False(t, queue.Full())
+	})
+}
+
+func TestIndexer(t *testing.T) {
+	t.Parallel()
+
+	queue, err := Open(t.TempDir(), 200)
+	assert.NoError(t, err)
+	target := &testingIndexer{err: errors.New("connection refused")}
+	indexer := NewIndexer(target, breaker.New(1, 200*time.Millisecond), queue, 100)
+
+	// the failed request opens the breaker and its documents are spilled.
+	result, err := indexer.BulkIndex(context.Background(), []opensearch.Document{testingDoc{id: "1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Spilled)
+	assert.Empty(t, result.Failed)
+	assert.Equal(t, 1, target.calls())
+
+	// while the breaker is open opensearch isn't called at all.
+	result, err = indexer.BulkIndex(context.Background(), []opensearch.Document{testingDoc{id: "2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Spilled)
+	assert.Equal(t, 1, target.calls())
+	assert.NoError(t, indexer.Admit())
+
+	// once the quota is used up documents are rejected.
+	_, err = indexer.BulkIndex(context.Background(), []opensearch.Document{testingDoc{id: "3"}})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.ErrorIs(t, indexer.Admit(), debounce.ErrRetryLater)
+
+	// before the cooldown passed nothing is drained.
+	indexer.tick(context.Background())
+	assert.Equal(t, 1, target.calls())
+
+	// a probe which fails keeps the breaker open.
+	time.Sleep(250 * time.Millisecond)
+	indexer.tick(context.Background())
+	assert.Equal(t, 2, target.calls())
+	assert.Equal(t, breaker.Open, indexer.breaker.State())
+	assert.Equal(t, 2, queue.Len())
+
+	// after recovery the probe closes the breaker and the spilled documents are drained in order.
+	target.setErr(nil)
+	time.Sleep(250 * time.Millisecond)
+	indexer.tick(context.Background())
+	assert.Equal(t, breaker.Closed, indexer.breaker.State())
+	assert.Equal(t, 1, queue.Len())
+	assert.NoError(t, indexer.Admit())
+
+	indexer.tick(context.Background())
+	assert.Equal(t, 0, queue.Len())
+	assert.Equal(t, []string{"1", "2"}, target.writtenIDs())
+}
+
+type testingDoc struct {
+	id        string
+	action    opensearch.Action
+	version   int64
+	partition string
+	span      trace.SpanContext
+}
+
+func (t testingDoc) ID() string {
+	return t.id
+}
+
+func (t testingDoc) Index() string {
+	return "testIndex"
+}
+
+func (t testingDoc) Data() interface{} {
+	return map[string]interface{}{"foo": "bar"}
+}
+
+func (t testingDoc) Action() opensearch.Action {
+	return t.action
+}
+
+func (t testingDoc) Version() int64 {
+	return t.version
+}
+
+func (t testingDoc) PartitionKey() string {
+	return t.partition
+}
+
+func (t testingDoc) SpanContext() trace.SpanContext {
+	return t.span
+}
+
+type testingIndexer struct {
+	mu      sync.Mutex
+	err     error
+	called  int
+	written []string
+}
+
+func (t *testingIndexer) BulkIndex(_ context.Context, docs []opensearch.Document) (opensearch.BulkResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.called++
+
+	if t.err != nil {
+		result := opensearch.BulkResult{}
+		for _, doc := range docs {
+			result.Failed = append(result.Failed, opensearch.BulkResponseItem{Index: doc.Index(), ID: doc.ID()})
+		}
+		return result, t.err
+	}
+
+	for _, doc := range docs {
+		t.written = append(t.written, doc.ID())
+	}
+	return opensearch.BulkResult{Succeeded: len(docs)}, nil
+}
+
+func (t *testingIndexer) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+}
+
+func (t *testingIndexer) calls() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.called
+}
+
+func (t *testingIndexer) writtenIDs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.written
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// instrumentationName names the tracer of the bouncer.
+const instrumentationName = "github.com/kstiehl/index-bouncer"
+
+// maxLinks is the number of events a bulk request span can be linked to.
+// It matches the default maximum number of documents in a bulk request.
+const maxLinks = 5000
+
+// Propagator extracts and injects W3C trace context and baggage.
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{}, propagation.Baggage{})
+
+// Tracer returns the tracer of the bouncer. A nil provider disables tracing.
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = trace.NewNoopTracerProvider()
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+// NewProvider creates a TracerProvider which exports spans with OTLP over HTTP to the endpoint,
+// e.g. localhost:4318. sampleRatio is the fraction of traces without sampled parent which are recorded.
+// The provider has to be shut down to export the remaining spans.
+func NewProvider(ctx context.Context, endpoint string, insecure bool, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewProviderWithExporter(exporter, sampleRatio), nil
+}
+
+// NewProviderWithExporter creates a TracerProvider which batches spans for the exporter.
+func NewProviderWithExporter(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	limits := sdktrace.NewSpanLimits()
+	limits.LinkCountLimit = maxLinks
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithRawSpanLimits(limits),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("index-bouncer"))),
+	)
+}
+
+// Extract returns a context with the remote span which is propagated by the incoming gRPC metadata.
+func Extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return Propagator.Extract(ctx, MetadataCarrier(md))
+}
+
+// Inject adds the span of the context to the outgoing gRPC metadata.
+func Inject(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	Propagator.Inject(ctx, MetadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// MetadataCarrier adapts gRPC metadata to a propagation.TextMapCarrier.
+type MetadataCarrier metadata.MD
+
+func (c MetadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c MetadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Links returns a link to every distinct valid span context.
+func Links(spans []trace.SpanContext) []trace.Link {
+	seen := make(map[trace.SpanID]bool, len(spans))
+	links := make([]trace.Link, 0, len(spans))
+	for _, span := range spans {
+		if !span.IsValid() || seen[span.SpanID()] {
+			continue
+		}
+		seen[span.SpanID()] = true
+		links = append(links, trace.Link{SpanContext: span})
+	}
+	return links
+}
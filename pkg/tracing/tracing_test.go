@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Propagate", func(t *testing.T) {
+		t.Parallel()
+
+		exporter := tracetest.NewInMemoryExporter()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+		ctx, client := Tracer(provider).Start(context.Background(), "client")
+		outgoing, _ := metadata.FromOutgoingContext(Inject(ctx))
+		client.End()
+
+		incoming := metadata.NewIncomingContext(context.Background(), outgoing)
+		_, server := Tracer(provider).Start(Extract(incoming), "server")
+		server.End()
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, spans[0].SpanContext.TraceID(), spans[1].SpanContext.TraceID())
+		assert.Equal(t, spans[0].SpanContext.SpanID(), spans[1].Parent.SpanID())
+		assert.True(t, spans[1].Parent.IsRemote())
+	})
+
+	t.Run("No Metadata", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := Extract(context.Background())
+		assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		t.Parallel()
+
+		provider := sdktrace.NewTracerProvider()
+		_, first := Tracer(provider).Start(context.Background(), "first")
+		_, second := Tracer(provider).Start(context.Background(), "second")
+
+		links := Links([]trace.SpanContext{
+			first.SpanContext(), {}, second.SpanContext(), first.SpanContext(),
+		})
+		assert.Len(t, links, 2)
+		assert.Equal(t, first.SpanContext(), links[0].SpanContext)
+		assert.Equal(t, second.SpanContext(), links[1].SpanContext)
+	})
+
+	t.Run("Nil Provider", func(t *testing.T) {
+		t.Parallel()
+
+		_, span := Tracer(nil).Start(context.Background(), "noop")
+		assert.False(t, span.IsRecording())
+	})
+}